@@ -0,0 +1,284 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nightlyone/lockfile"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Result of an entry
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Entry is a mutation of vCD. Hash is sha256 of the entry without hash, it includes hash of the previous entry
+// so a changed, removed or inserted entry breaks the chain
+type Entry struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Node       string    `json:"node"`
+	Volume     string    `json:"volume,omitempty"`
+	Operation  string    `json:"operation"`
+	DiskName   string    `json:"diskName,omitempty"`
+	DiskHref   string    `json:"diskHref,omitempty"`
+	VmName     string    `json:"vmName,omitempty"`
+	TaskIds    []string  `json:"taskIds,omitempty"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	PrevHash   string    `json:"prevHash"`
+	Hash       string    `json:"hash"`
+}
+
+// Log is an append only JSON lines file of entries.
+// nil *Log is valid and records nothing
+type Log struct {
+	Path string
+}
+
+// ChainError is the first entry which breaks the chain
+type ChainError struct {
+	Line   int
+	Seq    int64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at line %d (seq %d): %s", e.Line, e.Seq, e.Reason)
+}
+
+// Append sets seq and hashes of entry and appends it
+func (log *Log) Append(entry *Entry) error {
+	if log == nil {
+		return nil
+	}
+
+	path, err := filepath.Abs(log.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// the driver serializes its invocations, the lock also covers other writers, e.g. admin tools
+	lock, err := lockfile.New(path + ".lck")
+	if err != nil {
+		return err
+	}
+	if err = tryLock(lock, 20, 50*time.Millisecond); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	last, err := lastEntry(path)
+	if err != nil {
+		return err
+	}
+
+	// hash is verified on the entry read back, time must be written as it is hashed
+	entry.Time = entry.Time.UTC()
+	entry.Seq, entry.PrevHash = 1, ""
+	if last != nil {
+		entry.Seq, entry.PrevHash = last.Seq+1, last.Hash
+	}
+	entry.Hash, err = hash(entry)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	// entry of a mutation must survive a crash of the node
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Read returns all entries, no entries when log file does not exist
+func (log *Log) Read() ([]*Entry, error) {
+	if log == nil {
+		return nil, nil
+	}
+
+	var entries []*Entry
+	err := log.scan(func(line int, entry *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+
+	return entries, err
+}
+
+// Verify checks chain of all entries and returns number of entries, a *ChainError when the chain is broken.
+// a removed tail is only detected by comparing the last seq with an earlier verification
+func (log *Log) Verify() (int, error) {
+	if log == nil {
+		return 0, nil
+	}
+
+	count := 0
+	var previous *Entry
+	err := log.scan(func(line int, entry *Entry, err error) error {
+		if err != nil {
+			return &ChainError{Line: line, Reason: "unreadable entry: " + err.Error()}
+		}
+
+		expectSeq, expectPrevHash := int64(1), ""
+		if previous != nil {
+			expectSeq, expectPrevHash = previous.Seq+1, previous.Hash
+		}
+
+		if entry.Seq != expectSeq {
+			return &ChainError{Line: line, Seq: entry.Seq, Reason: fmt.Sprintf("expect seq %d", expectSeq)}
+		}
+		if entry.PrevHash != expectPrevHash {
+			return &ChainError{Line: line, Seq: entry.Seq, Reason: "previous hash mismatch"}
+		}
+
+		entryHash, err := hash(entry)
+		if err != nil {
+			return err
+		}
+		if entry.Hash != entryHash {
+			return &ChainError{Line: line, Seq: entry.Seq, Reason: "hash mismatch, entry is modified"}
+		}
+
+		previous = entry
+		count++
+		return nil
+	})
+
+	return count, err
+}
+
+func (log *Log) scan(fn func(line int, entry *Entry, err error) error) error {
+	file, err := os.Open(log.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		b, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(b) == 0 {
+			return nil
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+
+		entry := &Entry{}
+		if fnErr := fn(line, entry, json.Unmarshal(b, entry)); fnErr != nil {
+			return fnErr
+		}
+	}
+}
+
+// hash returns sha256 in hex of entry without its hash
+func hash(entry *Entry) (string, error) {
+	entryCopy := *entry
+	entryCopy.Hash = ""
+
+	b, err := json.Marshal(&entryCopy)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastEntry reads the last line of log file, nil when the file is empty or does not exist
+func lastEntry(path string) (*Entry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// read backward until a whole line is read, entries are small
+	size := info.Size()
+	chunk := int64(4096)
+	for {
+		if chunk > size {
+			chunk = size
+		}
+		if chunk == 0 {
+			return nil, nil
+		}
+
+		b := make([]byte, chunk)
+		if _, err := file.ReadAt(b, size-chunk); err != nil {
+			return nil, err
+		}
+
+		end := len(b)
+		for end > 0 && b[end-1] == '\n' {
+			end--
+		}
+		start := end - 1
+		for start >= 0 && b[start] != '\n' {
+			start--
+		}
+
+		if start >= 0 || chunk == size {
+			entry := &Entry{}
+			if err := json.Unmarshal(b[start+1:end], entry); err != nil {
+				return nil, errors.New("last audit entry: " + err.Error())
+			}
+			return entry, nil
+		}
+
+		chunk *= 2
+	}
+}
+
+func tryLock(lock lockfile.Lockfile, attempts int, interval time.Duration) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = lock.TryLock(); err == nil {
+			return nil
+		}
+		time.Sleep(interval)
+	}
+
+	return errors.New("lock audit log: " + err.Error())
+}
@@ -0,0 +1,170 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempLog(t *testing.T, entries int) (*Log, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := &Log{Path: filepath.Join(dir, "audit.log")}
+	for i := 0; i < entries; i++ {
+		entry := &Entry{
+			Time:      time.Now(),
+			Node:      "node1",
+			Volume:    "pv1",
+			Operation: "attachDisk",
+			DiskName:  "pv1",
+			Result:    ResultSuccess,
+		}
+		// an entry larger than a chunk of lastEntry
+		if i == 1 {
+			entry.Result, entry.Error = ResultFailure, strings.Repeat("x", 5000)
+		}
+		if err := log.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return log, func() { os.RemoveAll(dir) }
+}
+
+func TestAppend(t *testing.T) {
+	log, cleanup := tempLog(t, 4)
+	defer cleanup()
+
+	entries, err := log.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("%d entries, want 4", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Seq != int64(i+1) {
+			t.Errorf("entry %d: seq %d", i, entry.Seq)
+		}
+		if i > 0 && entry.PrevHash != entries[i-1].Hash {
+			t.Errorf("entry %d: prevHash %s, want %s", i, entry.PrevHash, entries[i-1].Hash)
+		}
+	}
+
+	count, err := log.Verify()
+	if err != nil || count != 4 {
+		t.Errorf("Verify() = %d, %v", count, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes lines of the log
+		tamper     func(lines [][]byte) [][]byte
+		wantLine   int
+		wantReason string
+	}{
+		{
+			name:   "intact",
+			tamper: func(lines [][]byte) [][]byte { return lines },
+		},
+		{
+			name: "modified entry",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[2] = bytes.Replace(lines[2], []byte(`"attachDisk"`), []byte(`"detachDisk"`), 1)
+				return lines
+			},
+			wantLine:   3,
+			wantReason: "hash mismatch",
+		},
+		{
+			name: "removed entry",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			},
+			wantLine:   2,
+			wantReason: "expect seq 2",
+		},
+		{
+			name: "swapped entries",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			wantLine:   2,
+			wantReason: "expect seq 2",
+		},
+		{
+			name: "replayed entry",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines, lines[3])
+			},
+			wantLine:   5,
+			wantReason: "expect seq 5",
+		},
+		{
+			name: "renumbered entry",
+			tamper: func(lines [][]byte) [][]byte {
+				lines = append(lines[:1], lines[2:]...)
+				lines[1] = bytes.Replace(lines[1], []byte(`"seq":3`), []byte(`"seq":2`), 1)
+				return lines
+			},
+			wantLine:   2,
+			wantReason: "previous hash mismatch",
+		},
+		{
+			name: "unreadable entry",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[0] = []byte("{")
+				return lines
+			},
+			wantLine:   1,
+			wantReason: "unreadable entry",
+		},
+	}
+
+	for _, test := range tests {
+		log, cleanup := tempLog(t, 4)
+
+		b, err := ioutil.ReadFile(log.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := bytes.Split(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+		lines = test.tamper(lines)
+		if err := ioutil.WriteFile(log.Path, append(bytes.Join(lines, []byte("\n")), '\n'), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = log.Verify()
+		if test.wantLine == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+		} else if chainErr, ok := err.(*ChainError); !ok {
+			t.Errorf("%s: error %v, want chain error", test.name, err)
+		} else if chainErr.Line != test.wantLine || !strings.Contains(chainErr.Reason, test.wantReason) {
+			t.Errorf("%s: broken at line %d: %s, want line %d: %s", test.name, chainErr.Line, chainErr.Reason, test.wantLine, test.wantReason)
+		}
+
+		cleanup()
+	}
+}
+
+func TestNilLog(t *testing.T) {
+	var log *Log
+	if err := log.Append(&Entry{}); err != nil {
+		t.Error(err)
+	}
+	if count, err := log.Verify(); count != 0 || err != nil {
+		t.Errorf("Verify() = %d, %v", count, err)
+	}
+}
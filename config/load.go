@@ -0,0 +1,58 @@
+package config
+
+import (
+	"github.com/ty2/vcdfv/errcode"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DefaultPath = "/etc/kubernetes/vcdfv-config.yaml"
+	// PathEnv overrides config path
+	PathEnv = "VCDFV_CONFIG"
+	// FileName is looked up next to the driver binary
+	FileName = "vcdfv-config.yaml"
+)
+
+// Path returns config path, in order: env VCDFV_CONFIG, vcdfv-config.yaml next to the binary, DefaultPath
+func Path() string {
+	if path := os.Getenv(PathEnv); path != "" {
+		return path
+	}
+
+	if executable, err := os.Executable(); err == nil {
+		path := filepath.Join(filepath.Dir(executable), FileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return DefaultPath
+}
+
+func Load(path string) (*Vcdfv, error) {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errcode.Wrap(errcode.ConfigInvalid, err, "read config")
+	}
+
+	vcdfvConfig := &Vcdfv{}
+	err = yaml.Unmarshal(fileBytes, vcdfvConfig)
+	if err != nil {
+		return nil, errcode.Wrap(errcode.ConfigInvalid, err, "parse config "+path)
+	}
+
+	return vcdfvConfig, nil
+}
+
+// UnknownFields returns the error of keys in config file which are not config fields, e.g. typos
+func UnknownFields(path string) error {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return yaml.UnmarshalStrict(fileBytes, &Vcdfv{})
+}
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type Vcdfv struct {
 	VcdApiEndpoint string `yaml:"vcdApiEndpoint"`
 	VcdInsecure    bool   `yaml:"vcdInsecure"`
@@ -9,4 +11,51 @@ type Vcdfv struct {
 	VcdVdc         string `yaml:"vcdVdc"`
 	VcdVdcVApp     string `yaml:"vcdVdcVApp"`
 	ManualUnmount  bool   `yaml:"manualUnmount"`
+	// clusters sharing a VDC, disks owned by other clusters are not touched unless allowForeignDisks
+	ClusterId         string `yaml:"clusterId"`
+	DiskNamePrefix    string `yaml:"diskNamePrefix"`
+	AllowForeignDisks bool   `yaml:"allowForeignDisks"`
+	// failed mount deletes the disk it created, attached disks are always detached
+	DeleteCreatedDiskOnFailure bool `yaml:"deleteCreatedDiskOnFailure"`
+	// quantities, e.g. 1Gi. a new disk is at least diskMinSize, a larger size than diskMaxSize is refused. empty has no limit
+	DiskMinSize string `yaml:"diskMinSize"`
+	DiskMaxSize string `yaml:"diskMaxSize"`
+	// which unformatted disks are formatted on mount: format, formatNew or refuse. empty formats all
+	UnformattedDiskPolicy string `yaml:"unformattedDiskPolicy"`
+	// credential sources referenced instead of plaintext vcdPassword
+	VcdPasswordFile      string   `yaml:"vcdPasswordFile"`
+	VcdApiTokenFile      string   `yaml:"vcdApiTokenFile"`
+	VcdRefreshTokenFile  string   `yaml:"vcdRefreshTokenFile"`
+	VcdCredentialSources []string `yaml:"vcdCredentialSources"`
+	// authenticated sessions are reused across invocations, empty dir disables it
+	VcdSessionCacheDir string        `yaml:"vcdSessionCacheDir"`
+	VcdSessionTtl      time.Duration `yaml:"vcdSessionTtl"`
+	// retry of busy / concurrent modification errors and deadline of vCD tasks, zero uses default
+	VcdRetryMaxAttempts    int                      `yaml:"vcdRetryMaxAttempts"`
+	VcdRetryInitialBackoff time.Duration            `yaml:"vcdRetryInitialBackoff"`
+	VcdRetryMaxBackoff     time.Duration            `yaml:"vcdRetryMaxBackoff"`
+	VcdTaskPollInterval    time.Duration            `yaml:"vcdTaskPollInterval"`
+	VcdTaskTimeout         time.Duration            `yaml:"vcdTaskTimeout"`
+	VcdTaskTimeouts        map[string]time.Duration `yaml:"vcdTaskTimeouts"`
+	// JSON lines operation log, empty file disables it
+	LogFile       string `yaml:"logFile"`
+	LogLevel      string `yaml:"logLevel"`
+	LogMaxSizeMB  int    `yaml:"logMaxSizeMB"`
+	LogMaxBackups int    `yaml:"logMaxBackups"`
+	// node_exporter textfile collector dir, vcdfv.prom is written in it, empty disables metrics
+	MetricsTextfileDir string `yaml:"metricsTextfileDir"`
+	// steps of mount and unmount are journaled per volume for resume after a crash, empty disables it
+	JournalDir string `yaml:"journalDir"`
+	// hash chained log of every mutation of vCD, empty disables it
+	AuditLogFile string `yaml:"auditLogFile"`
+	// Kubernetes events of mount are posted on pod and PV with this kubeconfig, empty disables events
+	Kubeconfig string `yaml:"kubeconfig"`
+	// attach which takes longer posts a SlowAttach event, zero uses default
+	KubeEventSlowAttach time.Duration `yaml:"kubeEventSlowAttach"`
+	// unmount of a mount which processes still use: fail, lazy or kill. empty fails
+	UnmountBusyPolicy string `yaml:"unmountBusyPolicy"`
+	// lazy unmount waits for the filesystem to be released before the disk is detached, zero uses default
+	UnmountLazyDeadline time.Duration `yaml:"unmountLazyDeadline"`
+	// kill sends SIGTERM, then SIGKILL to processes which still use the mount after grace period, zero uses default
+	UnmountKillGracePeriod time.Duration `yaml:"unmountKillGracePeriod"`
 }
@@ -7,10 +7,19 @@ const (
 	opMount   = "mount"
 	opUnmount = "unmount"
 	opInit    = "init"
+	// not a FlexVolume call, checks config of the node
+	opValidateConfig = "validate-config"
+	// not a FlexVolume call, reports usage of a volume like getvolumestats of CSI
+	opGetVolumeStats = "getvolumestats"
 )
 
+// validate-config also logins VCD and resolves org, VDC and vApp
+const validateConfigOnlineFlag = "--online"
+
+// true prints plan of mount and unmount instead of running them
+const dryRunEnv = "VCDFV_DRY_RUN"
+
 // Error Predefine
 const (
-	errorInvalidOperationCode       = "invalid operation"
 	errorInvalidOperationDefaultMsg = "expect: %s, got: %s"
 )
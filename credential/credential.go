@@ -0,0 +1,253 @@
+package credential
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Credential sources, in the default order they are tried
+const (
+	SourceSecret = "secret"
+	SourceEnv    = "env"
+	SourceFile   = "file"
+	SourceConfig = "config"
+)
+
+var DefaultSources = []string{SourceSecret, SourceEnv, SourceFile, SourceConfig}
+
+// Environment variables read by the env source
+const (
+	EnvUser         = "VCDFV_VCD_USER"
+	EnvPassword     = "VCDFV_VCD_PASSWORD"
+	EnvApiToken     = "VCDFV_VCD_API_TOKEN"
+	EnvRefreshToken = "VCDFV_VCD_REFRESH_TOKEN"
+)
+
+// Keys of a Kubernetes secret referenced by the volume's secretRef.
+// kubelet passes them to the driver as "kubernetes.io/secret/<key>" options with base64 encoded values
+const (
+	SecretOptionPrefix = "kubernetes.io/secret/"
+	SecretUser         = "username"
+	SecretPassword     = "password"
+	SecretApiToken     = "apiToken"
+	SecretRefreshToken = "refreshToken"
+)
+
+// ErrNoCredential is returned by a provider which has nothing to offer, the chain moves on to the next provider
+var ErrNoCredential = errors.New("no credential")
+
+type Credential struct {
+	User         string
+	Password     string
+	ApiToken     string
+	RefreshToken string
+	// name of the source which provides this credential
+	Source string
+}
+
+// usable credential is an API token, a refresh token, or a user with password
+func (credential *Credential) usable() bool {
+	return credential.ApiToken != "" || credential.RefreshToken != "" || (credential.User != "" && credential.Password != "")
+}
+
+type Provider interface {
+	Name() string
+	Retrieve() (*Credential, error)
+}
+
+type Chain []Provider
+
+// NewChain builds a chain of providers in the order of sources, empty sources uses DefaultSources
+func NewChain(sources []string, secret *SecretProvider, env *EnvProvider, file *FileProvider, config *ConfigProvider) (Chain, error) {
+	if len(sources) == 0 {
+		sources = DefaultSources
+	}
+
+	providers := map[string]Provider{
+		SourceSecret: secret,
+		SourceEnv:    env,
+		SourceFile:   file,
+		SourceConfig: config,
+	}
+
+	chain := Chain{}
+	for _, source := range sources {
+		provider, ok := providers[source]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("unknown credential source: %s, expect: %s", source, strings.Join(DefaultSources, ",")))
+		}
+		chain = append(chain, provider)
+	}
+
+	return chain, nil
+}
+
+// Retrieve returns the credential of the first provider which has a usable one
+func (chain Chain) Retrieve() (*Credential, error) {
+	var tried []string
+	for _, provider := range chain {
+		credential, err := provider.Retrieve()
+		if err == ErrNoCredential {
+			tried = append(tried, provider.Name())
+			continue
+		} else if err != nil {
+			return nil, errors.New(fmt.Sprintf("credential source %s: %s", provider.Name(), err.Error()))
+		}
+
+		if !credential.usable() {
+			tried = append(tried, provider.Name())
+			continue
+		}
+
+		credential.Source = provider.Name()
+		return credential, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("no usable credential found, tried: %s", strings.Join(tried, ",")))
+}
+
+// SecretProvider reads credential from FlexVolume kubernetes.io/secret/* options
+type SecretProvider struct {
+	// options with SecretOptionPrefix, value is base64 encoded
+	Secrets map[string]string
+	// used when secret has no username
+	DefaultUser string
+}
+
+func (provider *SecretProvider) Name() string {
+	return SourceSecret
+}
+
+func (provider *SecretProvider) Retrieve() (*Credential, error) {
+	if provider == nil || len(provider.Secrets) == 0 {
+		return nil, ErrNoCredential
+	}
+
+	decoded := map[string]string{}
+	for key, value := range provider.Secrets {
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("decode secret %s: %s", key, err.Error()))
+		}
+		decoded[strings.TrimPrefix(key, SecretOptionPrefix)] = strings.TrimSpace(string(b))
+	}
+
+	return &Credential{
+		User:         firstNonEmpty(decoded[SecretUser], provider.DefaultUser),
+		Password:     decoded[SecretPassword],
+		ApiToken:     decoded[SecretApiToken],
+		RefreshToken: decoded[SecretRefreshToken],
+	}, nil
+}
+
+// EnvProvider reads credential from environment variables
+type EnvProvider struct {
+	// used when EnvUser is not set
+	DefaultUser string
+}
+
+func (provider *EnvProvider) Name() string {
+	return SourceEnv
+}
+
+func (provider *EnvProvider) Retrieve() (*Credential, error) {
+	credential := &Credential{
+		Password:     os.Getenv(EnvPassword),
+		ApiToken:     os.Getenv(EnvApiToken),
+		RefreshToken: os.Getenv(EnvRefreshToken),
+	}
+
+	if credential.Password == "" && credential.ApiToken == "" && credential.RefreshToken == "" {
+		return nil, ErrNoCredential
+	}
+
+	credential.User = os.Getenv(EnvUser)
+	if credential.User == "" && provider != nil {
+		credential.User = provider.DefaultUser
+	}
+
+	return credential, nil
+}
+
+// FileProvider reads password or tokens from files referenced by config
+type FileProvider struct {
+	User             string
+	PasswordFile     string
+	ApiTokenFile     string
+	RefreshTokenFile string
+}
+
+func (provider *FileProvider) Name() string {
+	return SourceFile
+}
+
+func (provider *FileProvider) Retrieve() (*Credential, error) {
+	if provider == nil || (provider.PasswordFile == "" && provider.ApiTokenFile == "" && provider.RefreshTokenFile == "") {
+		return nil, ErrNoCredential
+	}
+
+	credential := &Credential{
+		User: provider.User,
+	}
+
+	var err error
+	if credential.Password, err = readSecretFile(provider.PasswordFile); err != nil {
+		return nil, err
+	}
+	if credential.ApiToken, err = readSecretFile(provider.ApiTokenFile); err != nil {
+		return nil, err
+	}
+	if credential.RefreshToken, err = readSecretFile(provider.RefreshTokenFile); err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// ConfigProvider uses plaintext user and password of config file
+type ConfigProvider struct {
+	User     string
+	Password string
+}
+
+func (provider *ConfigProvider) Name() string {
+	return SourceConfig
+}
+
+func (provider *ConfigProvider) Retrieve() (*Credential, error) {
+	if provider == nil || provider.Password == "" {
+		return nil, ErrNoCredential
+	}
+
+	return &Credential{
+		User:     provider.User,
+		Password: provider.Password,
+	}, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
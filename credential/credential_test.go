@@ -0,0 +1,165 @@
+package credential
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func secret(values map[string]string) map[string]string {
+	secrets := map[string]string{}
+	for key, value := range values {
+		secrets[SecretOptionPrefix+key] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	return secrets
+}
+
+func TestChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("file-password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte(" file-token "), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		sources []string
+		secrets map[string]string
+		env     map[string]string
+		file    *FileProvider
+		config  *ConfigProvider
+		// user of config is not set, env has no default user
+		noDefaultUser bool
+		want          *Credential
+		wantErr       string
+	}{
+		{
+			name:    "secret overrides node sources",
+			secrets: secret(map[string]string{SecretUser: "pod-user", SecretPassword: "secret-password"}),
+			env:     map[string]string{EnvPassword: "env-password"},
+			file:    &FileProvider{User: "node-user", PasswordFile: passwordFile},
+			config:  &ConfigProvider{User: "node-user", Password: "config-password"},
+			want:    &Credential{User: "pod-user", Password: "secret-password", Source: SourceSecret},
+		},
+		{
+			name:    "secret without username uses user of config",
+			secrets: secret(map[string]string{SecretApiToken: "secret-token"}),
+			want:    &Credential{User: "node-user", ApiToken: "secret-token", Source: SourceSecret},
+		},
+		{
+			name:   "env before file and config",
+			env:    map[string]string{EnvPassword: "env-password"},
+			file:   &FileProvider{User: "node-user", PasswordFile: passwordFile},
+			config: &ConfigProvider{User: "node-user", Password: "config-password"},
+			want:   &Credential{User: "node-user", Password: "env-password", Source: SourceEnv},
+		},
+		{
+			name: "user of env",
+			env:  map[string]string{EnvUser: "env-user", EnvRefreshToken: "env-refresh"},
+			want: &Credential{User: "env-user", RefreshToken: "env-refresh", Source: SourceEnv},
+		},
+		{
+			name:   "file before config",
+			file:   &FileProvider{User: "node-user", PasswordFile: passwordFile, ApiTokenFile: tokenFile},
+			config: &ConfigProvider{User: "node-user", Password: "config-password"},
+			want:   &Credential{User: "node-user", Password: "file-password", ApiToken: "file-token", Source: SourceFile},
+		},
+		{
+			name:   "config",
+			config: &ConfigProvider{User: "node-user", Password: "config-password"},
+			want:   &Credential{User: "node-user", Password: "config-password", Source: SourceConfig},
+		},
+		{
+			name:    "order of sources",
+			sources: []string{SourceConfig, SourceEnv},
+			env:     map[string]string{EnvPassword: "env-password"},
+			config:  &ConfigProvider{User: "node-user", Password: "config-password"},
+			want:    &Credential{User: "node-user", Password: "config-password", Source: SourceConfig},
+		},
+		{
+			name:    "source which is not listed is skipped",
+			sources: []string{SourceFile},
+			secrets: secret(map[string]string{SecretPassword: "secret-password"}),
+			config:  &ConfigProvider{User: "node-user", Password: "config-password"},
+			wantErr: "no usable credential found, tried: file",
+		},
+		{
+			name:          "password without user is not usable",
+			sources:       []string{SourceEnv, SourceConfig},
+			env:           map[string]string{EnvPassword: "env-password"},
+			config:        &ConfigProvider{User: "node-user", Password: "config-password"},
+			noDefaultUser: true,
+			want:          &Credential{User: "node-user", Password: "config-password", Source: SourceConfig},
+		},
+		{
+			name:    "missing file fails the chain",
+			file:    &FileProvider{User: "node-user", PasswordFile: filepath.Join(dir, "missing")},
+			config:  &ConfigProvider{User: "node-user", Password: "config-password"},
+			wantErr: "credential source file:",
+		},
+		{
+			name:    "invalid secret fails the chain",
+			secrets: map[string]string{SecretOptionPrefix + SecretPassword: "not base64!"},
+			config:  &ConfigProvider{User: "node-user", Password: "config-password"},
+			wantErr: "credential source secret: decode secret",
+		},
+		{
+			name:    "no credential",
+			wantErr: "no usable credential found, tried: secret,env,file,config",
+		},
+		{
+			name:    "unknown source",
+			sources: []string{SourceEnv, "vault"},
+			wantErr: "unknown credential source: vault",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{EnvUser, EnvPassword, EnvApiToken, EnvRefreshToken} {
+				os.Unsetenv(key)
+			}
+			for key, value := range tt.env {
+				os.Setenv(key, value)
+				defer os.Unsetenv(key)
+			}
+
+			envProvider := &EnvProvider{DefaultUser: "node-user"}
+			if tt.noDefaultUser {
+				envProvider.DefaultUser = ""
+			}
+
+			chain, err := NewChain(tt.sources, &SecretProvider{Secrets: tt.secrets, DefaultUser: "node-user"}, envProvider, tt.file, tt.config)
+			var credential *Credential
+			if err == nil {
+				credential, err = chain.Retrieve()
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if *credential != *tt.want {
+				t.Errorf("credential = %+v, want %+v", credential, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package errcode
+
+import "fmt"
+
+// Error codes, surfaced as "code" of the FlexVolume output
+const (
+	InvalidOperation  = "InvalidOperation"
+	InvalidArgument   = "InvalidArgument"
+	ConfigInvalid     = "ConfigInvalid"
+	NotFound          = "NotFound"
+	Duplicate         = "Duplicate"
+	AlreadyAttached   = "AlreadyAttached"
+	AttachedElsewhere = "AttachedElsewhere"
+	ForeignDisk       = "ForeignDisk"
+	Timeout           = "Timeout"
+	Canceled          = "Canceled"
+	Busy              = "Busy"
+	AuthFailed        = "AuthFailed"
+	VcdApiFailed      = "VcdApiFailed"
+	CreateFailed      = "CreateFailed"
+	AttachFailed      = "AttachFailed"
+	DetachFailed      = "DetachFailed"
+	DeleteFailed      = "DeleteFailed"
+	MetaFailed        = "MetaFailed"
+	DeviceNotFound    = "DeviceNotFound"
+	DeviceFailed      = "DeviceFailed"
+	FormatFailed      = "FormatFailed"
+	FsckFailed        = "FsckFailed" // filesystem has errors which fsck cannot repair, it is never mounted
+	FsUuidMismatch    = "FsUuidMismatch"  // filesystem of device is not of the disk, it is never mounted
+	UnformattedDisk   = "UnformattedDisk" // unformattedDiskPolicy refuses to format the device of disk
+	MountFailed       = "MountFailed"
+	UnmountFailed     = "UnmountFailed"
+	Unknown           = "Unknown"
+)
+
+type Error struct {
+	code    string
+	message string
+	cause   error
+}
+
+func New(code string, message string) *Error {
+	return &Error{code: code, message: message}
+}
+
+func Newf(code string, format string, args ...interface{}) *Error {
+	return &Error{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns error with code and message, err is kept as cause
+func Wrap(code string, err error, message string) *Error {
+	return &Error{code: code, message: message, cause: err}
+}
+
+// Annotate adds message to err and keeps its code, defaultCode is used when err has no code
+func Annotate(defaultCode string, err error, message string) *Error {
+	code := CodeOf(err)
+	if code == Unknown {
+		code = defaultCode
+	}
+
+	return Wrap(code, err, message)
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%s] %s", e.code, e.text())
+}
+
+// text is message with messages of causes, codes of causes are omitted
+func (e *Error) text() string {
+	if e.cause == nil {
+		return e.message
+	}
+
+	if cause, ok := e.cause.(*Error); ok {
+		return e.message + ": " + cause.text()
+	}
+
+	return e.message + ": " + e.cause.Error()
+}
+
+func (e *Error) Code() string {
+	return e.code
+}
+
+func (e *Error) Message() string {
+	return e.message
+}
+
+func (e *Error) Cause() error {
+	return e.cause
+}
+
+// CodeOf returns code of the outermost coded error, Unknown if err has no code
+func CodeOf(err error) string {
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			return e.code
+		}
+
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = causer.Cause()
+	}
+
+	return Unknown
+}
+
+// Is reports whether err or any of its causes has code
+func Is(err error, code string) bool {
+	for err != nil {
+		e, ok := err.(*Error)
+		if ok && e.code == code {
+			return true
+		}
+
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = causer.Cause()
+	}
+
+	return false
+}
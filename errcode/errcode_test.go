@@ -0,0 +1,114 @@
+package errcode
+
+import (
+	"errors"
+	"testing"
+)
+
+type causer struct {
+	cause error
+}
+
+func (c *causer) Error() string {
+	return "causer: " + c.cause.Error()
+}
+
+func (c *causer) Cause() error {
+	return c.cause
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    string
+		wantMessage string
+		is          []string
+		isNot       []string
+	}{
+		{
+			name:        "new",
+			err:         New(NotFound, "disk d1 not found"),
+			wantCode:    NotFound,
+			wantMessage: "[NotFound] disk d1 not found",
+			is:          []string{NotFound},
+			isNot:       []string{Unknown, VcdApiFailed},
+		},
+		{
+			name:        "newf",
+			err:         Newf(InvalidArgument, "invalid size %s", "10Gb"),
+			wantCode:    InvalidArgument,
+			wantMessage: "[InvalidArgument] invalid size 10Gb",
+			is:          []string{InvalidArgument},
+		},
+		{
+			name:        "wrap of plain error",
+			err:         Wrap(MountFailed, errors.New("exit status 32"), "mount"),
+			wantCode:    MountFailed,
+			wantMessage: "[MountFailed] mount: exit status 32",
+			is:          []string{MountFailed},
+			isNot:       []string{Unknown},
+		},
+		{
+			name:        "wrap replaces code, codes of causes are omitted",
+			err:         Wrap(AttachFailed, New(Busy, "disk is busy"), "attach"),
+			wantCode:    AttachFailed,
+			wantMessage: "[AttachFailed] attach: disk is busy",
+			is:          []string{AttachFailed, Busy},
+		},
+		{
+			name:        "annotate keeps code",
+			err:         Annotate(VcdApiFailed, New(NotFound, "disk d1 not found"), "find disk"),
+			wantCode:    NotFound,
+			wantMessage: "[NotFound] find disk: disk d1 not found",
+			is:          []string{NotFound},
+			isNot:       []string{VcdApiFailed},
+		},
+		{
+			name:        "annotate of plain error uses default code",
+			err:         Annotate(VcdApiFailed, errors.New("API Error: 500: failed"), "query disks"),
+			wantCode:    VcdApiFailed,
+			wantMessage: "[VcdApiFailed] query disks: API Error: 500: failed",
+			is:          []string{VcdApiFailed},
+		},
+		{
+			name:        "code under a causer",
+			err:         &causer{cause: Wrap(Timeout, errors.New("deadline exceeded"), "attachDisk")},
+			wantCode:    Timeout,
+			wantMessage: "causer: [Timeout] attachDisk: deadline exceeded",
+			is:          []string{Timeout},
+		},
+		{
+			name:        "plain error",
+			err:         errors.New("failed"),
+			wantCode:    Unknown,
+			wantMessage: "failed",
+			isNot:       []string{Unknown, NotFound},
+		},
+		{
+			name:     "nil",
+			err:      nil,
+			wantCode: Unknown,
+			isNot:    []string{Unknown},
+		},
+	}
+
+	for _, test := range tests {
+		if code := CodeOf(test.err); code != test.wantCode {
+			t.Errorf("%s: CodeOf() = %s, want %s", test.name, code, test.wantCode)
+		}
+		if test.err != nil && test.err.Error() != test.wantMessage {
+			t.Errorf("%s: Error() = %q, want %q", test.name, test.err.Error(), test.wantMessage)
+		}
+		for _, code := range test.is {
+			if !Is(test.err, code) {
+				t.Errorf("%s: Is(%s) = false, want true", test.name, code)
+			}
+		}
+		for _, code := range test.isNot {
+			if Is(test.err, code) {
+				t.Errorf("%s: Is(%s) = true, want false", test.name, code)
+			}
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package journal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Status of a step
+const (
+	StepPlanned = "planned"
+	StepDone    = "done"
+	StepFailed  = "failed"
+)
+
+// State of a journal, a finished operation removes its journal
+const (
+	// operation is running, or its process is killed
+	StateRunning = "running"
+	// operation failed and left steps for its next call to resume
+	StateFailed = "failed"
+)
+
+const fileSuffix = ".json"
+
+type Step struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+	// step of a previous call which is carried over by Begin
+	Carried bool `json:"carried,omitempty"`
+}
+
+// Journal records planned and completed steps of an operation of a volume, it is written before and after every step
+// so the next invocation for the volume knows where a killed process stopped. A finished operation removes its journal.
+// nil *Journal is valid and records nothing
+type Journal struct {
+	Volume    string `json:"volume"`
+	Operation string `json:"operation"`
+	MountDir  string `json:"mountDir"`
+	State     string `json:"state"`
+	// error of a failed operation
+	Error string `json:"error,omitempty"`
+	// resources touched by the operation, for resume and rollback
+	DiskId     string    `json:"diskId,omitempty"`
+	DiskName   string    `json:"diskName,omitempty"`
+	DiskHref   string    `json:"diskHref,omitempty"`
+	DeviceName string    `json:"deviceName,omitempty"`
+	VmName     string    `json:"vmName,omitempty"`
+	Steps      []*Step   `json:"steps"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	store      *Store
+}
+
+// Store keeps a journal file per volume in Dir.
+// nil *Store is valid, it has no journal and begins nil journals
+type Store struct {
+	Dir string
+}
+
+// Begin starts a journal of operation which replaces previous, the pending journal of the volume or nil.
+// what previous did not resolve is carried over, so it is not lost when this call fails before it resumes:
+// disk, VM and device, and the last step of every name when previous is of the same operation
+func (store *Store) Begin(volume string, operation string, mountDir string, previous *Journal) (*Journal, error) {
+	if store == nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	journal := &Journal{
+		Volume:    volume,
+		Operation: operation,
+		MountDir:  mountDir,
+		State:     StateRunning,
+		Steps:     []*Step{},
+		CreatedAt: now,
+		UpdatedAt: now,
+		store:     store,
+	}
+
+	if previous != nil {
+		journal.DiskId, journal.DiskName, journal.DiskHref = previous.DiskId, previous.DiskName, previous.DiskHref
+		journal.DeviceName, journal.VmName = previous.DeviceName, previous.VmName
+		if previous.Operation == operation {
+			journal.Steps = carriedSteps(previous.Steps)
+		}
+	}
+
+	return journal, journal.Save()
+}
+
+// carriedSteps returns the last step of every name in order, so steps of calls which are retried do not pile up
+func carriedSteps(steps []*Step) []*Step {
+	last := map[string]int{}
+	for i, step := range steps {
+		last[step.Name] = i
+	}
+
+	carried := []*Step{}
+	for i, step := range steps {
+		if last[step.Name] == i {
+			stepCopy := *step
+			stepCopy.Carried = true
+			carried = append(carried, &stepCopy)
+		}
+	}
+
+	return carried
+}
+
+// Load returns pending journal of volume, nil when there is none
+func (store *Store) Load(volume string) (*Journal, error) {
+	if store == nil {
+		return nil, nil
+	}
+
+	journal, err := store.read(store.path(volume))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return journal, err
+}
+
+// List returns pending journals sorted by volume
+func (store *Store) List() ([]*Journal, error) {
+	if store == nil {
+		return nil, nil
+	}
+
+	files, err := ioutil.ReadDir(store.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var journals []*Journal
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), fileSuffix) {
+			continue
+		}
+
+		journal, err := store.read(filepath.Join(store.Dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, journal)
+	}
+
+	sort.Slice(journals, func(i, j int) bool {
+		return journals[i].Volume < journals[j].Volume
+	})
+
+	return journals, nil
+}
+
+// Remove removes journal of volume, e.g. admin discards a pending journal
+func (store *Store) Remove(volume string) error {
+	if store == nil {
+		return nil
+	}
+
+	err := os.Remove(store.path(volume))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+var unsafeFileNameRegexp = regexp.MustCompile("[^a-zA-Z0-9._-]")
+
+func (store *Store) path(volume string) string {
+	return filepath.Join(store.Dir, unsafeFileNameRegexp.ReplaceAllString(volume, "_")+fileSuffix)
+}
+
+func (store *Store) read(path string) (*Journal, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	journal := &Journal{}
+	err = json.Unmarshal(b, journal)
+	if err != nil {
+		return nil, err
+	}
+	journal.store = store
+
+	return journal, nil
+}
+
+// Save writes journal atomically, a crash never leaves a partial journal
+func (journal *Journal) Save() error {
+	if journal == nil {
+		return nil
+	}
+
+	store := journal.store
+	if err := os.MkdirAll(store.Dir, 0700); err != nil {
+		return err
+	}
+
+	journal.UpdatedAt = time.Now()
+	b, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(store.Dir, ".journal-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = tmpFile.Write(b); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	// journal must survive a crash of the node too
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), store.path(journal.Volume))
+}
+
+// Plan records step is about to run
+func (journal *Journal) Plan(name string) error {
+	if journal == nil {
+		return nil
+	}
+
+	journal.Steps = append(journal.Steps, &Step{
+		Name:      name,
+		Status:    StepPlanned,
+		StartedAt: time.Now(),
+	})
+
+	return journal.Save()
+}
+
+// End records result of the last planned step of name
+func (journal *Journal) End(name string, err error) error {
+	if journal == nil {
+		return nil
+	}
+
+	step := journal.lastStep(name)
+	if step == nil {
+		return nil
+	}
+
+	step.Status = StepDone
+	if err != nil {
+		step.Status = StepFailed
+		step.Error = err.Error()
+	}
+	step.EndedAt = time.Now()
+
+	return journal.Save()
+}
+
+// SetVm records VM of the operation
+func (journal *Journal) SetVm(vmName string) error {
+	if journal == nil {
+		return nil
+	}
+
+	journal.VmName = vmName
+	return journal.Save()
+}
+
+// SetDisk records disk of the operation
+func (journal *Journal) SetDisk(diskId string, diskName string, diskHref string) error {
+	if journal == nil {
+		return nil
+	}
+
+	journal.DiskId, journal.DiskName, journal.DiskHref = diskId, diskName, diskHref
+	return journal.Save()
+}
+
+// SetDevice records block device of the disk
+func (journal *Journal) SetDevice(deviceName string) error {
+	if journal == nil {
+		return nil
+	}
+
+	journal.DeviceName = deviceName
+	return journal.Save()
+}
+
+// Finish removes journal, the operation needs no resume
+func (journal *Journal) Finish() error {
+	if journal == nil {
+		return nil
+	}
+
+	return journal.store.Remove(journal.Volume)
+}
+
+// Fail keeps journal of a failed operation for its next call to resume
+func (journal *Journal) Fail(err error) error {
+	if journal == nil {
+		return nil
+	}
+
+	journal.State = StateFailed
+	journal.Error = err.Error()
+	return journal.Save()
+}
+
+// Pending returns the last step which is planned or failed and not done again later, nil when all steps are done
+func (journal *Journal) Pending() *Step {
+	if journal == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for i := len(journal.Steps) - 1; i >= 0; i-- {
+		step := journal.Steps[i]
+		if !seen[step.Name] && step.Status != StepDone {
+			return step
+		}
+		seen[step.Name] = true
+	}
+
+	return nil
+}
+
+// Done reports whether the last step of name is done
+func (journal *Journal) Done(name string) bool {
+	step := journal.lastStep(name)
+	return step != nil && step.Status == StepDone
+}
+
+// Started reports whether step of name is planned at least once
+func (journal *Journal) Started(name string) bool {
+	return journal.lastStep(name) != nil
+}
+
+// Unresolved reports whether step of name is started and no step of resolvedBy is done after it.
+// a step which resolves itself, e.g. a retried format, is resolved when it is done
+func (journal *Journal) Unresolved(name string, resolvedBy ...string) bool {
+	if journal == nil {
+		return false
+	}
+
+	for i := len(journal.Steps) - 1; i >= 0; i-- {
+		step := journal.Steps[i]
+		for _, resolver := range resolvedBy {
+			if step.Name == resolver && step.Status == StepDone {
+				return false
+			}
+		}
+		if step.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Interrupted reports whether the last step of name is planned and never ended, e.g. the process is killed in it.
+// a step which failed cleanly is not interrupted
+func (journal *Journal) Interrupted(name string) bool {
+	step := journal.lastStep(name)
+	return step != nil && step.Status == StepPlanned
+}
+
+func (journal *Journal) lastStep(name string) *Step {
+	if journal == nil {
+		return nil
+	}
+
+	for i := len(journal.Steps) - 1; i >= 0; i-- {
+		if journal.Steps[i].Name == name {
+			return journal.Steps[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package journal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func tempStore(t *testing.T) *Store {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Store{Dir: dir}
+}
+
+// run records steps of a call, a step ending with "!" fails and a step ending with "?" is only planned
+func run(t *testing.T, journal *Journal, steps ...string) {
+	for _, name := range steps {
+		var err error
+		switch name[len(name)-1] {
+		case '!':
+			name, err = name[:len(name)-1], errors.New("failed")
+		case '?':
+			if err := journal.Plan(name[:len(name)-1]); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		if err := journal.Plan(name); err != nil {
+			t.Fatal(err)
+		}
+		if err := journal.End(name, err); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// steps of every call of the same operation, the journal of a call is loaded and carried by the next call.
+		// steps of the last call follow the steps it carries
+		calls           [][]string
+		wantSteps       []string
+		wantPending     string
+		wantForceFormat bool
+		wantStaleDevice bool
+	}{
+		{
+			name:            "killed format",
+			calls:           [][]string{{"attach", "format?"}},
+			wantSteps:       []string{"attach", "format"},
+			wantPending:     "format",
+			wantForceFormat: true,
+		},
+		{
+			name:            "killed format is carried by a call failing before format",
+			calls:           [][]string{{"attach", "format?"}, {"login!"}},
+			wantSteps:       []string{"attach", "format", "login"},
+			wantPending:     "login",
+			wantForceFormat: true,
+		},
+		{
+			name:        "failed format is not forced",
+			calls:       [][]string{{"attach", "format!"}},
+			wantSteps:   []string{"attach", "format"},
+			wantPending: "format",
+		},
+		{
+			name:        "format which failed after a killed format is not forced",
+			calls:       [][]string{{"attach", "format?"}, {"format!"}},
+			wantSteps:   []string{"attach", "format", "format"},
+			wantPending: "format",
+		},
+		{
+			name:        "format resolved by next call",
+			calls:       [][]string{{"attach", "format!"}, {"attach", "format"}},
+			wantSteps:   []string{"attach", "format", "attach", "format"},
+			wantPending: "",
+		},
+		{
+			name:            "stale device is carried",
+			calls:           [][]string{{"metaDetach"}, {"login!"}, {"login", "findVm!"}},
+			wantSteps:       []string{"metaDetach", "login", "login", "findVm"},
+			wantPending:     "findVm",
+			wantStaleDevice: true,
+		},
+		{
+			name:      "stale device resolved by reattach",
+			calls:     [][]string{{"metaDetach", "metaReattach"}},
+			wantSteps: []string{"metaDetach", "metaReattach"},
+		},
+		{
+			name:      "stale device resolved by removal in next call",
+			calls:     [][]string{{"metaDetach"}, {"removeStaleDevice", "mount!"}},
+			wantSteps: []string{"metaDetach", "removeStaleDevice", "mount"},
+			// mount failed after the stale device is removed
+			wantPending: "mount",
+		},
+		{
+			name:            "detached again after the stale device is removed",
+			calls:           [][]string{{"metaDetach"}, {"removeStaleDevice", "metaDetach"}},
+			wantSteps:       []string{"metaDetach", "removeStaleDevice", "metaDetach"},
+			wantStaleDevice: true,
+		},
+	}
+
+	for _, test := range tests {
+		store := tempStore(t)
+
+		var previous *Journal
+		for _, steps := range test.calls {
+			var err error
+			previous, err = store.Load("pv1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			journal, err := store.Begin("pv1", "mount", "/mnt/pv1", previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			run(t, journal, steps...)
+			if err := journal.Fail(errors.New("call failed")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		journal, err := store.Load("pv1")
+		if err != nil || journal == nil {
+			t.Fatalf("%s: load journal %v, %v", test.name, journal, err)
+		}
+
+		var steps []string
+		for _, step := range journal.Steps {
+			steps = append(steps, step.Name)
+		}
+		if !equal(steps, test.wantSteps) {
+			t.Errorf("%s: steps %v, want %v", test.name, steps, test.wantSteps)
+		}
+
+		pending := ""
+		if step := journal.Pending(); step != nil {
+			pending = step.Name
+		}
+		if pending != test.wantPending {
+			t.Errorf("%s: pending step %q, want %q", test.name, pending, test.wantPending)
+		}
+
+		if forceFormat := journal.Interrupted("format"); forceFormat != test.wantForceFormat {
+			t.Errorf("%s: format interrupted %v, want %v", test.name, forceFormat, test.wantForceFormat)
+		}
+		if staleDevice := journal.Unresolved("metaDetach", "metaReattach", "removeStaleDevice"); staleDevice != test.wantStaleDevice {
+			t.Errorf("%s: stale device unresolved %v, want %v", test.name, staleDevice, test.wantStaleDevice)
+		}
+		if journal.State != StateFailed || journal.Error != "call failed" {
+			t.Errorf("%s: state %s, error %q", test.name, journal.State, journal.Error)
+		}
+
+		os.RemoveAll(store.Dir)
+	}
+}
+
+func TestBeginOtherOperation(t *testing.T) {
+	store := tempStore(t)
+	defer os.RemoveAll(store.Dir)
+
+	mount, err := store.Begin("pv1", "mount", "/mnt/pv1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, mount, "attach", "format?")
+	mount.SetDisk("urn:vcloud:disk:1", "pv1", "https://vcd/api/disk/1")
+	mount.SetDevice("sdb")
+
+	previous, err := store.Load("pv1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous.State != StateRunning {
+		t.Errorf("state of killed call %s, want %s", previous.State, StateRunning)
+	}
+
+	unmount, err := store.Begin("pv1", "unmount", "/mnt/pv1", previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unmount.Steps) != 0 {
+		t.Errorf("steps of other operation are carried: %v", unmount.Steps)
+	}
+	if unmount.DiskId != "urn:vcloud:disk:1" || unmount.DeviceName != "sdb" {
+		t.Errorf("disk %s and device %s are not carried", unmount.DiskId, unmount.DeviceName)
+	}
+
+	if err := unmount.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if journal, err := store.Load("pv1"); journal != nil || err != nil {
+		t.Errorf("finished journal is loaded: %v, %v", journal, err)
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var store *Store
+	journal, err := store.Begin("pv1", "mount", "/mnt/pv1", nil)
+	if journal != nil || err != nil {
+		t.Fatalf("Begin() = %v, %v", journal, err)
+	}
+
+	// nil journal records nothing
+	if err := journal.Plan("attach"); err != nil {
+		t.Error(err)
+	}
+	if err := journal.Fail(errors.New("failed")); err != nil {
+		t.Error(err)
+	}
+	if journal.Pending() != nil || journal.Unresolved("attach") {
+		t.Error("nil journal has steps")
+	}
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
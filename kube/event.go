@@ -0,0 +1,177 @@
+package kube
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Event types
+const (
+	EventTypeNormal  = "Normal"
+	EventTypeWarning = "Warning"
+)
+
+// Event reasons of vcdfv
+const (
+	ReasonDiskCreated       = "DiskCreated"
+	ReasonDiskDetached      = "DiskDetached"
+	ReasonDiskFormatted     = "DiskFormatted"
+	ReasonDiskMetaRewritten = "DiskMetaRewritten"
+	ReasonFsckRepaired      = "FsckRepaired"
+	ReasonSlowAttach        = "SlowAttach"
+	ReasonMountFailed       = "MountFailed"
+)
+
+// events of cluster scoped objects, e.g. PV, are in this namespace
+const defaultNamespace = "default"
+
+// ObjectReference is the object an event is about
+type ObjectReference struct {
+	ApiVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	Uid        string `json:"uid,omitempty"`
+}
+
+type ObjectMeta struct {
+	GenerateName string `json:"generateName"`
+	Namespace    string `json:"namespace"`
+}
+
+type EventSource struct {
+	Component string `json:"component"`
+	Host      string `json:"host,omitempty"`
+}
+
+// Event is a core/v1 Event, timestamps are RFC3339
+type Event struct {
+	ApiVersion     string          `json:"apiVersion"`
+	Kind           string          `json:"kind"`
+	Metadata       ObjectMeta      `json:"metadata"`
+	InvolvedObject ObjectReference `json:"involvedObject"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Type           string          `json:"type"`
+	Count          int             `json:"count"`
+	FirstTimestamp string          `json:"firstTimestamp"`
+	LastTimestamp  string          `json:"lastTimestamp"`
+	Source         EventSource     `json:"source"`
+}
+
+// EventSink receives events, Client posts them to API server
+type EventSink interface {
+	Emit(event *Event) error
+}
+
+// Client posts events to API server of a kubeconfig
+type Client struct {
+	config *Config
+	http   *http.Client
+}
+
+// NewClient returns client of current context of kubeconfig file, every request is limited by timeout
+func NewClient(kubeconfigPath string, timeout time.Duration) (*Client, error) {
+	config, err := LoadConfig(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		config: config,
+		http:   config.httpClient(timeout),
+	}, nil
+}
+
+func (client *Client) Emit(event *Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	eventUrl := fmt.Sprintf("%s/api/v1/namespaces/%s/events", client.config.Server, url.PathEscape(event.Metadata.Namespace))
+	req, err := http.NewRequest(http.MethodPost, eventUrl, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if client.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.config.Token)
+	}
+
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("post event: unexpected status %s: %s", resp.Status, string(body)))
+	}
+
+	return nil
+}
+
+// Recorder emits events of an operation on every object of it, e.g. pod and PV.
+// nil *Recorder is valid and emits nothing
+type Recorder struct {
+	Sink      EventSink
+	Component string
+	Host      string
+	Objects   []ObjectReference
+	// called with error of sink, events are best effort and never fail the operation
+	OnError func(err error)
+}
+
+func (recorder *Recorder) Normal(reason string, message string) {
+	recorder.emit(EventTypeNormal, reason, message)
+}
+
+func (recorder *Recorder) Warning(reason string, message string) {
+	recorder.emit(EventTypeWarning, reason, message)
+}
+
+func (recorder *Recorder) emit(eventType string, reason string, message string) {
+	if recorder == nil || recorder.Sink == nil {
+		return
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, object := range recorder.Objects {
+		namespace := object.Namespace
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+
+		err := recorder.Sink.Emit(&Event{
+			ApiVersion: "v1",
+			Kind:       "Event",
+			Metadata: ObjectMeta{
+				GenerateName: object.Name + ".",
+				Namespace:    namespace,
+			},
+			InvolvedObject: object,
+			Reason:         reason,
+			Message:        message,
+			Type:           eventType,
+			Count:          1,
+			FirstTimestamp: now,
+			LastTimestamp:  now,
+			Source: EventSource{
+				Component: recorder.Component,
+				Host:      recorder.Host,
+			},
+		})
+		if err != nil && recorder.OnError != nil {
+			recorder.OnError(err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package kube
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeSink keeps events, it fails every emit when err is set
+type fakeSink struct {
+	events []*Event
+	err    error
+}
+
+func (sink *fakeSink) Emit(event *Event) error {
+	if sink.err != nil {
+		return sink.err
+	}
+
+	sink.events = append(sink.events, event)
+	return nil
+}
+
+var (
+	testPod = ObjectReference{ApiVersion: "v1", Kind: "Pod", Namespace: "app", Name: "web-0", Uid: "uid-1"}
+	testPv  = ObjectReference{ApiVersion: "v1", Kind: "PersistentVolume", Name: "pv1"}
+)
+
+func TestRecorder(t *testing.T) {
+	sink := &fakeSink{}
+	recorder := &Recorder{Sink: sink, Component: "vcdfv", Host: "node1", Objects: []ObjectReference{testPod, testPv}}
+
+	recorder.Normal(ReasonDiskCreated, "disk pv1 of 10Gi is created, 10737418240 bytes are provisioned")
+	recorder.Normal(ReasonDiskFormatted, "device sdb of disk pv1 is formatted ext4")
+	recorder.Warning(ReasonSlowAttach, "attach of disk pv1 to VM node1 took 2m0s")
+
+	want := []struct {
+		object    ObjectReference
+		namespace string
+		eventType string
+		reason    string
+	}{
+		{testPod, "app", EventTypeNormal, ReasonDiskCreated},
+		{testPv, "default", EventTypeNormal, ReasonDiskCreated},
+		{testPod, "app", EventTypeNormal, ReasonDiskFormatted},
+		{testPv, "default", EventTypeNormal, ReasonDiskFormatted},
+		{testPod, "app", EventTypeWarning, ReasonSlowAttach},
+		{testPv, "default", EventTypeWarning, ReasonSlowAttach},
+	}
+
+	if len(sink.events) != len(want) {
+		t.Fatalf("%d events, want %d", len(sink.events), len(want))
+	}
+	for i, event := range sink.events {
+		if event.InvolvedObject != want[i].object || event.Metadata.Namespace != want[i].namespace ||
+			event.Type != want[i].eventType || event.Reason != want[i].reason {
+			t.Errorf("event %d: %+v, want %+v", i, event, want[i])
+		}
+		if event.Metadata.GenerateName != want[i].object.Name+"." {
+			t.Errorf("event %d: generateName %s", i, event.Metadata.GenerateName)
+		}
+		if event.Kind != "Event" || event.Count != 1 || event.Source.Component != "vcdfv" || event.Source.Host != "node1" {
+			t.Errorf("event %d: %+v", i, event)
+		}
+		if _, err := time.Parse(time.RFC3339, event.FirstTimestamp); err != nil {
+			t.Errorf("event %d: firstTimestamp %s", i, event.FirstTimestamp)
+		}
+	}
+}
+
+func TestRecorderError(t *testing.T) {
+	var errs []error
+	recorder := &Recorder{
+		Sink:    &fakeSink{err: errors.New("forbidden")},
+		Objects: []ObjectReference{testPod, testPv},
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	}
+
+	recorder.Warning(ReasonMountFailed, "mount failed")
+	if len(errs) != 2 {
+		t.Errorf("%d errors, want one per object", len(errs))
+	}
+
+	// nil recorder emits nothing
+	var nilRecorder *Recorder
+	nilRecorder.Normal(ReasonDiskCreated, "disk is created")
+}
+
+func TestClientEmit(t *testing.T) {
+	var posted Event
+	var path, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, authorization = r.URL.Path, r.Header.Get("Authorization")
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &posted)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := &Client{config: &Config{Server: server.URL, Token: "token"}, http: server.Client()}
+	recorder := &Recorder{Sink: client, Objects: []ObjectReference{testPod}}
+	recorder.Normal(ReasonDiskCreated, "disk is created")
+
+	if path != "/api/v1/namespaces/app/events" || authorization != "Bearer token" {
+		t.Errorf("posted to %s with authorization %q", path, authorization)
+	}
+	if posted.Reason != ReasonDiskCreated || posted.InvolvedObject != testPod {
+		t.Errorf("posted event %+v", posted)
+	}
+}
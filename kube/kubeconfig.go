@@ -0,0 +1,178 @@
+package kube
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// kubeconfig is the part of kubeconfig file which the client uses
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTlsVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// Config is the API server and credential of the current context of a kubeconfig file
+type Config struct {
+	Server    string
+	Token     string
+	TlsConfig *tls.Config
+}
+
+// LoadConfig reads current context of kubeconfig file, files referenced by it are relative to its dir
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var kc kubeconfig
+	if err := yaml.Unmarshal(b, &kc); err != nil {
+		return nil, fmt.Errorf("parse kubeconfig %s: %s", path, err.Error())
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	// the only context is used when current-context is not set
+	contextName := kc.CurrentContext
+	if contextName == "" && len(kc.Contexts) == 1 {
+		contextName = kc.Contexts[0].Name
+	}
+
+	var clusterName, userName string
+	found := false
+	for _, context := range kc.Contexts {
+		if context.Name == contextName {
+			clusterName, userName, found = context.Context.Cluster, context.Context.User, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig %s", contextName, path)
+	}
+
+	config := &Config{TlsConfig: &tls.Config{}}
+
+	found = false
+	for _, cluster := range kc.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		found = true
+
+		config.Server = strings.TrimSuffix(cluster.Cluster.Server, "/")
+		config.TlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTlsVerify
+
+		caData, err := fileOrData(resolve(cluster.Cluster.CertificateAuthority), cluster.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("certificate authority: %s", err.Error())
+		}
+		if caData != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caData) {
+				return nil, errors.New("certificate authority has no certificate")
+			}
+			config.TlsConfig.RootCAs = pool
+		}
+		break
+	}
+	if !found || config.Server == "" {
+		return nil, fmt.Errorf("server of cluster %q not found in kubeconfig %s", clusterName, path)
+	}
+
+	for _, user := range kc.Users {
+		if user.Name != userName {
+			continue
+		}
+
+		config.Token = user.User.Token
+		if config.Token == "" && user.User.TokenFile != "" {
+			token, err := ioutil.ReadFile(resolve(user.User.TokenFile))
+			if err != nil {
+				return nil, err
+			}
+			config.Token = strings.TrimSpace(string(token))
+		}
+
+		certData, err := fileOrData(resolve(user.User.ClientCertificate), user.User.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %s", err.Error())
+		}
+		keyData, err := fileOrData(resolve(user.User.ClientKey), user.User.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("client key: %s", err.Error())
+		}
+		if certData != nil && keyData != nil {
+			cert, err := tls.X509KeyPair(certData, keyData)
+			if err != nil {
+				return nil, err
+			}
+			config.TlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		break
+	}
+
+	return config, nil
+}
+
+// fileOrData returns base64 decoded data, content of file when data is empty, nil when both are empty
+func fileOrData(file string, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+
+	return nil, nil
+}
+
+func (config *Config) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: config.TlsConfig,
+		},
+	}
+}
@@ -0,0 +1,204 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LevelError = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+type Fields map[string]interface{}
+
+type Config struct {
+	// log file path, empty disables logging
+	File string
+	// error, warn, info or debug, default info
+	Level string
+	// rotate when file is larger than MaxSizeMB, default 10
+	MaxSizeMB int
+	// rotated files to keep, default 5
+	MaxBackups int
+}
+
+// Logger writes JSON lines, every line has the fields of the logger.
+// nil *Logger is valid and discards everything, stdout is never used because it is reserved for FlexVolume result
+type Logger struct {
+	writer io.Writer
+	mutex  *sync.Mutex
+	level  int
+	fields Fields
+}
+
+func New(config *Config) (*Logger, error) {
+	if config.File == "" {
+		return nil, nil
+	}
+
+	level, err := ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := newRotatingFile(config.File, config.MaxSizeMB, config.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Logger{
+		writer: writer,
+		mutex:  &sync.Mutex{},
+		level:  level,
+		fields: Fields{},
+	}, nil
+}
+
+func ParseLevel(level string) (int, error) {
+	if level == "" {
+		return LevelInfo, nil
+	}
+
+	for i, name := range levelNames {
+		if strings.EqualFold(name, level) {
+			return i, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("unknown log level: %s, expect: %s", level, strings.Join(levelNames, ",")))
+}
+
+// NewOperationId returns a random id which correlates all lines of an invocation
+func NewOperationId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// With returns a logger which adds fields to every line
+func (logger *Logger) With(fields Fields) *Logger {
+	if logger == nil {
+		return nil
+	}
+
+	merged := Fields{}
+	for key, value := range logger.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	return &Logger{
+		writer: logger.writer,
+		mutex:  logger.mutex,
+		level:  logger.level,
+		fields: merged,
+	}
+}
+
+func (logger *Logger) Debug(message string, fields Fields) {
+	logger.log(LevelDebug, message, fields)
+}
+
+func (logger *Logger) Info(message string, fields Fields) {
+	logger.log(LevelInfo, message, fields)
+}
+
+func (logger *Logger) Warn(message string, fields Fields) {
+	logger.log(LevelWarn, message, fields)
+}
+
+func (logger *Logger) Error(message string, err error, fields Fields) {
+	if err != nil {
+		fields = mergeFields(fields, Fields{"error": err.Error()})
+	}
+	logger.log(LevelError, message, fields)
+}
+
+func (logger *Logger) log(level int, message string, fields Fields) {
+	if logger == nil || level > logger.level {
+		return
+	}
+
+	line := Fields{}
+	for key, value := range logger.fields {
+		line[key] = value
+	}
+	for key, value := range fields {
+		line[key] = value
+	}
+	line["time"] = time.Now().Format(time.RFC3339Nano)
+	line["level"] = levelNames[level]
+	line["msg"] = message
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		b, _ = json.Marshal(Fields{"time": line["time"], "level": levelNames[LevelError], "msg": "marshal log line: " + err.Error()})
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.writer.Write(append(b, '\n'))
+}
+
+// Step logs start and end of a step with its duration
+type Step struct {
+	logger *Logger
+	name   string
+	start  time.Time
+}
+
+func (logger *Logger) Step(name string) *Step {
+	logger.Debug("step start", Fields{"step": name})
+
+	return &Step{
+		logger: logger,
+		name:   name,
+		start:  time.Now(),
+	}
+}
+
+// End logs the result of step and returns its duration
+func (step *Step) End(err error) time.Duration {
+	duration := time.Since(step.start)
+	fields := Fields{
+		"step":       step.name,
+		"durationMs": duration.Nanoseconds() / int64(time.Millisecond),
+	}
+
+	if err != nil {
+		step.logger.Error("step failed", err, fields)
+	} else {
+		step.logger.Info("step done", fields)
+	}
+
+	return duration
+}
+
+func mergeFields(a Fields, b Fields) Fields {
+	merged := Fields{}
+	for key, value := range a {
+		merged[key] = value
+	}
+	for key, value := range b {
+		merged[key] = value
+	}
+
+	return merged
+}
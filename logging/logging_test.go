@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// stdout is the FlexVolume result, logger must never write to it
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	path := filepath.Join(dir, "vcdfv.log")
+	logger, err := New(&Config{File: path, Level: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operationLogger := logger.With(Fields{"operationId": "op-1", "operation": "mount"})
+	operationLogger.Debug("below level", nil)
+	operationLogger.Info("disk found", Fields{"disk": "pv1"})
+	operationLogger.Warn("multi\nline", nil)
+	operationLogger.Error("attach failed", errors.New("busy"), Fields{"disk": "pv1"})
+	operationLogger.Step("attachDisk").End(nil)
+	logger.Info("without operation", nil)
+
+	writer.Close()
+	if b, _ := ioutil.ReadAll(reader); len(b) > 0 {
+		t.Errorf("stdout %q, want nothing", b)
+	}
+
+	want := []struct {
+		level       string
+		msg         string
+		operationId string
+		fields      Fields
+	}{
+		{"info", "disk found", "op-1", Fields{"disk": "pv1"}},
+		{"warn", "multi\nline", "op-1", nil},
+		{"error", "attach failed", "op-1", Fields{"disk": "pv1", "error": "busy"}},
+		{"info", "step done", "op-1", Fields{"step": "attachDisk"}},
+		{"info", "without operation", "", nil},
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("line %q is not a JSON object: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != len(want) {
+		t.Fatalf("%d lines, want %d: %v", len(lines), len(want), lines)
+	}
+	for i, line := range lines {
+		if line["level"] != want[i].level || line["msg"] != want[i].msg {
+			t.Errorf("line %d: %v, want %s %q", i, line, want[i].level, want[i].msg)
+		}
+		if operationId, _ := line["operationId"].(string); operationId != want[i].operationId {
+			t.Errorf("line %d: operationId %q, want %q", i, operationId, want[i].operationId)
+		}
+		if _, ok := line["time"]; !ok {
+			t.Errorf("line %d has no time", i)
+		}
+		for key, value := range want[i].fields {
+			if line[key] != value {
+				t.Errorf("line %d: %s = %v, want %v", i, key, line[key], value)
+			}
+		}
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	logger, err := New(&Config{})
+	if err != nil || logger != nil {
+		t.Fatalf("New() = %v, %v, want nil logger without file", logger, err)
+	}
+
+	// nil logger discards everything
+	logger.With(Fields{"operationId": "op-1"}).Info("discarded", nil)
+	logger.Step("step").End(errors.New("failed"))
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		level   string
+		want    int
+		wantErr bool
+	}{
+		{level: "", want: LevelInfo},
+		{level: "error", want: LevelError},
+		{level: "WARN", want: LevelWarn},
+		{level: "debug", want: LevelDebug},
+		{level: "trace", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.level)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %d, %v, want %d", tt.level, got, err, tt.want)
+		}
+	}
+}
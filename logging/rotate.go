@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	defaultMaxSizeMB  = 10
+	defaultMaxBackups = 5
+)
+
+// rotatingFile renames file to file.1, file.1 to file.2 and so on when it reaches max size
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxSizeMB int, maxBackups int) (*rotatingFile, error) {
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultMaxSizeMB
+	}
+	if maxBackups <= 0 {
+		maxBackups = defaultMaxBackups
+	}
+
+	rotatingFile := &rotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	if err := rotatingFile.open(); err != nil {
+		return nil, err
+	}
+
+	return rotatingFile, nil
+}
+
+func (rotatingFile *rotatingFile) open() error {
+	file, err := os.OpenFile(rotatingFile.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rotatingFile.file = file
+	rotatingFile.size = info.Size()
+
+	return nil
+}
+
+func (rotatingFile *rotatingFile) Write(b []byte) (int, error) {
+	if rotatingFile.size+int64(len(b)) > rotatingFile.maxSize && rotatingFile.size > 0 {
+		if err := rotatingFile.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rotatingFile.file.Write(b)
+	rotatingFile.size += int64(n)
+
+	return n, err
+}
+
+func (rotatingFile *rotatingFile) rotate() error {
+	if err := rotatingFile.file.Close(); err != nil {
+		return err
+	}
+
+	// drop the oldest and shift others
+	os.Remove(fmt.Sprintf("%s.%d", rotatingFile.path, rotatingFile.maxBackups))
+	for i := rotatingFile.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rotatingFile.path, i), fmt.Sprintf("%s.%d", rotatingFile.path, i+1))
+	}
+
+	if err := os.Rename(rotatingFile.path, rotatingFile.path+".1"); err != nil {
+		return err
+	}
+
+	return rotatingFile.open()
+}
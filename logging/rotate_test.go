@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBackups int
+		writes     []string
+		// content of the file and its backups, file first
+		want []string
+	}{
+		{
+			name:       "below max size",
+			maxBackups: 2,
+			writes:     []string{"aaaa", "bbbb"},
+			want:       []string{"aaaabbbb"},
+		},
+		{
+			name:       "at max size",
+			maxBackups: 2,
+			writes:     []string{"aaaa", "bbbbbb"},
+			want:       []string{"aaaabbbbbb"},
+		},
+		{
+			name:       "rotated above max size",
+			maxBackups: 2,
+			writes:     []string{"aaaaaa", "bbbbbb"},
+			want:       []string{"bbbbbb", "aaaaaa"},
+		},
+		{
+			name:       "write larger than max size to empty file",
+			maxBackups: 2,
+			writes:     []string{"aaaaaaaaaaaa"},
+			want:       []string{"aaaaaaaaaaaa"},
+		},
+		{
+			name:       "keeps max backups",
+			maxBackups: 2,
+			writes:     []string{"aaaaaa", "bbbbbb", "cccccc", "dddddd"},
+			want:       []string{"dddddd", "cccccc", "bbbbbb"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "rotate")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "log", "vcdfv.log")
+			rotatingFile, err := newRotatingFile(path, 1, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rotatingFile.file.Close()
+			rotatingFile.maxSize = 10
+
+			for _, write := range tt.writes {
+				if n, err := rotatingFile.Write([]byte(write)); err != nil || n != len(write) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+
+			var got []string
+			for i := 0; i <= tt.maxBackups+1; i++ {
+				name := path
+				if i > 0 {
+					name = fmt.Sprintf("%s.%d", path, i)
+				}
+				b, err := ioutil.ReadFile(name)
+				if os.IsNotExist(err) {
+					continue
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(b))
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("files %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// size of an existing file counts, e.g. of a previous invocation
+	path := filepath.Join(dir, "vcdfv.log")
+	if err := ioutil.WriteFile(path, []byte("aaaaaaaa"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rotatingFile, err := newRotatingFile(path, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rotatingFile.file.Close()
+	rotatingFile.maxSize = 10
+
+	if _, err := rotatingFile.Write([]byte("bbbb")); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := ioutil.ReadFile(path + ".1"); string(b) != "aaaaaaaa" {
+		t.Errorf("backup %q, want the existing file", b)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "bbbb" {
+		t.Errorf("file %q, want the new write", b)
+	}
+}
@@ -0,0 +1,347 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/nightlyone/lockfile"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Metric names
+const (
+	OperationsTotal          = "vcdfv_operations_total"
+	OperationDurationSeconds = "vcdfv_operation_duration_seconds"
+	PhaseDurationSeconds     = "vcdfv_phase_duration_seconds"
+	LastOperationTimestamp   = "vcdfv_last_operation_timestamp_seconds"
+)
+
+// TextfileName is the file written in the textfile collector dir of node_exporter
+const TextfileName = "vcdfv.prom"
+
+type family struct {
+	metricType string
+	help       string
+}
+
+var families = map[string]family{
+	OperationsTotal:          {typeCounter, "FlexVolume calls by operation, status and error code."},
+	OperationDurationSeconds: {typeHistogram, "Duration of FlexVolume calls."},
+	PhaseDurationSeconds:     {typeHistogram, "Duration of phases of FlexVolume calls, e.g. vCD login, disk lookup, attach, device discovery, format and mount."},
+	LastOperationTimestamp:   {typeGauge, "Unix time of the last FlexVolume call by operation."},
+}
+
+var buckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type Labels map[string]string
+
+// Recorder collects metrics of an invocation, Flush merges them into the textfile.
+// nil *Recorder is valid and records nothing
+type Recorder struct {
+	mutex  sync.Mutex
+	deltas map[string]float64
+	gauges map[string]float64
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		deltas: map[string]float64{},
+		gauges: map[string]float64{},
+	}
+}
+
+// Inc adds 1 to counter
+func (recorder *Recorder) Inc(name string, labels Labels) {
+	if recorder == nil {
+		return
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.deltas[seriesKey(name, labels)]++
+}
+
+// Set sets gauge
+func (recorder *Recorder) Set(name string, labels Labels, value float64) {
+	if recorder == nil {
+		return
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.gauges[seriesKey(name, labels)] = value
+}
+
+// Observe adds a sample to histogram
+func (recorder *Recorder) Observe(name string, labels Labels, duration time.Duration) {
+	if recorder == nil {
+		return
+	}
+
+	seconds := duration.Seconds()
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	// every bucket is written, also the empty ones
+	for _, bucket := range buckets {
+		key := seriesKey(name+"_bucket", withLabel(labels, "le", formatFloat(bucket)))
+		if seconds <= bucket {
+			recorder.deltas[key]++
+		} else {
+			recorder.deltas[key] += 0
+		}
+	}
+	recorder.deltas[seriesKey(name+"_bucket", withLabel(labels, "le", "+Inf"))]++
+	recorder.deltas[seriesKey(name+"_sum", labels)] += seconds
+	recorder.deltas[seriesKey(name+"_count", labels)]++
+}
+
+// Flush merges recorded metrics into dir/TextfileName, the file is replaced atomically so the collector never reads a partial file
+func (recorder *Recorder) Flush(dir string) error {
+	if recorder == nil || dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	// serialize writers of other invocations
+	lock, err := lockfile.New(filepath.Join(absDir, "."+TextfileName+".lck"))
+	if err != nil {
+		return err
+	}
+	if err = tryLock(lock, 20, 50*time.Millisecond); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	path := filepath.Join(absDir, TextfileName)
+	series, err := readSeries(path)
+	if err != nil {
+		return err
+	}
+
+	recorder.mutex.Lock()
+	for key, value := range recorder.deltas {
+		series[key] += value
+	}
+	for key, value := range recorder.gauges {
+		series[key] = value
+	}
+	recorder.deltas = map[string]float64{}
+	recorder.gauges = map[string]float64{}
+	recorder.mutex.Unlock()
+
+	tmpFile, err := ioutil.TempFile(absDir, "."+TextfileName+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	writer := bufio.NewWriter(tmpFile)
+	writeSeries(writer, series)
+	if err = writer.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+func tryLock(lock lockfile.Lockfile, attempts int, interval time.Duration) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = lock.TryLock(); err == nil {
+			return nil
+		}
+		time.Sleep(interval)
+	}
+
+	return errors.New("lock metrics file: " + err.Error())
+}
+
+// readSeries reads series of known families, other lines are dropped
+func readSeries(path string) (map[string]float64, error) {
+	series := map[string]float64{}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return series, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+
+		key := line[:i]
+		if _, ok := familyOf(key); !ok {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			continue
+		}
+
+		series[key] = value
+	}
+
+	return series, nil
+}
+
+func writeSeries(writer *bufio.Writer, series map[string]float64) {
+	byFamily := map[string][]string{}
+	for key := range series {
+		name, _ := familyOf(key)
+		byFamily[name] = append(byFamily[name], key)
+	}
+
+	names := make([]string, 0, len(byFamily))
+	for name := range byFamily {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		keys := byFamily[name]
+		sort.Slice(keys, func(i, j int) bool {
+			return lessSeries(keys[i], keys[j])
+		})
+
+		fmt.Fprintf(writer, "# HELP %s %s\n", name, families[name].help)
+		fmt.Fprintf(writer, "# TYPE %s %s\n", name, families[name].metricType)
+		for _, key := range keys {
+			fmt.Fprintf(writer, "%s %s\n", key, formatFloat(series[key]))
+		}
+	}
+}
+
+// familyOf returns family name of series key, histogram series have _bucket, _sum or _count suffix
+func familyOf(key string) (string, bool) {
+	name := key
+	if i := strings.Index(key, "{"); i >= 0 {
+		name = key[:i]
+	}
+
+	if _, ok := families[name]; ok {
+		return name, true
+	}
+
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base := strings.TrimSuffix(name, suffix)
+		if f, ok := families[base]; ok && base != name && f.metricType == typeHistogram {
+			return base, true
+		}
+	}
+
+	return "", false
+}
+
+var leLabelRegexp = regexp.MustCompile(`,?le="([^"]*)"`)
+
+// lessSeries sorts series by key, buckets of the same series are sorted by numeric le
+func lessSeries(a string, b string) bool {
+	aWithoutLe := leLabelRegexp.ReplaceAllString(a, "")
+	bWithoutLe := leLabelRegexp.ReplaceAllString(b, "")
+	if aWithoutLe != bWithoutLe {
+		return aWithoutLe < bWithoutLe
+	}
+
+	return parseLe(a) < parseLe(b)
+}
+
+func parseLe(key string) float64 {
+	match := leLabelRegexp.FindStringSubmatch(key)
+	if match == nil {
+		return 0
+	}
+
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return math.Inf(1)
+	}
+
+	return value
+}
+
+func seriesKey(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, escapeLabelValue(labels[key])))
+	}
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func withLabel(labels Labels, key string, value string) Labels {
+	merged := Labels{}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	merged[key] = value
+
+	return merged
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return strings.Replace(value, `"`, `\"`, -1)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlush(t *testing.T) {
+	mount := Labels{"operation": "mount"}
+	succeeded := Labels{"operation": "mount", "status": "Success", "code": ""}
+
+	tests := []struct {
+		name string
+		// content of the textfile before the flushes, empty means no file
+		existing  string
+		recorders []func(recorder *Recorder)
+		want      []string
+		notWant   []string
+	}{
+		{
+			name: "counters add up",
+			recorders: []func(recorder *Recorder){
+				func(recorder *Recorder) { recorder.Inc(OperationsTotal, succeeded) },
+				func(recorder *Recorder) {
+					recorder.Inc(OperationsTotal, succeeded)
+					recorder.Inc(OperationsTotal, succeeded)
+				},
+			},
+			want: []string{
+				"# TYPE vcdfv_operations_total counter",
+				`vcdfv_operations_total{code="",operation="mount",status="Success"} 3`,
+			},
+		},
+		{
+			name: "histogram buckets add up",
+			recorders: []func(recorder *Recorder){
+				func(recorder *Recorder) { recorder.Observe(OperationDurationSeconds, mount, 500*time.Millisecond) },
+				func(recorder *Recorder) { recorder.Observe(OperationDurationSeconds, mount, 2*time.Second) },
+			},
+			want: []string{
+				"# TYPE vcdfv_operation_duration_seconds histogram",
+				`vcdfv_operation_duration_seconds_bucket{le="0.25",operation="mount"} 0`,
+				`vcdfv_operation_duration_seconds_bucket{le="0.5",operation="mount"} 1`,
+				`vcdfv_operation_duration_seconds_bucket{le="1",operation="mount"} 1`,
+				`vcdfv_operation_duration_seconds_bucket{le="2.5",operation="mount"} 2`,
+				`vcdfv_operation_duration_seconds_bucket{le="+Inf",operation="mount"} 2`,
+				`vcdfv_operation_duration_seconds_sum{operation="mount"} 2.5`,
+				`vcdfv_operation_duration_seconds_count{operation="mount"} 2`,
+			},
+		},
+		{
+			name: "gauge is set by the last flush",
+			recorders: []func(recorder *Recorder){
+				func(recorder *Recorder) { recorder.Set(LastOperationTimestamp, mount, 100) },
+				func(recorder *Recorder) { recorder.Set(LastOperationTimestamp, mount, 200) },
+			},
+			want:    []string{`vcdfv_last_operation_timestamp_seconds{operation="mount"} 200`},
+			notWant: []string{`vcdfv_last_operation_timestamp_seconds{operation="mount"} 100`},
+		},
+		{
+			name: "existing series are merged, unknown families are dropped",
+			existing: "# TYPE vcdfv_operations_total counter\n" +
+				`vcdfv_operations_total{code="",operation="mount",status="Success"} 5` + "\n" +
+				"# TYPE node_unknown_total counter\n" +
+				"node_unknown_total 7\n",
+			recorders: []func(recorder *Recorder){
+				func(recorder *Recorder) { recorder.Inc(OperationsTotal, succeeded) },
+				func(recorder *Recorder) { recorder.Inc(OperationsTotal, succeeded) },
+			},
+			want:    []string{`vcdfv_operations_total{code="",operation="mount",status="Success"} 7`},
+			notWant: []string{"node_unknown_total"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "metrics")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, TextfileName)
+			if tt.existing != "" {
+				if err = ioutil.WriteFile(path, []byte(tt.existing), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, record := range tt.recorders {
+				recorder := NewRecorder()
+				record(recorder)
+				if err = recorder.Flush(dir); err != nil {
+					t.Fatalf("Flush() error = %v", err)
+				}
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(string(b), "\n")
+			for _, want := range tt.want {
+				if !containsLine(lines, want) {
+					t.Errorf("textfile misses %q, got:\n%s", want, b)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(string(b), notWant) {
+					t.Errorf("textfile has %q, got:\n%s", notWant, b)
+				}
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0644 {
+				t.Errorf("textfile mode = %v, want %v", info.Mode().Perm(), os.FileMode(0644))
+			}
+
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range files {
+				if strings.Contains(file.Name(), ".tmp-") {
+					t.Errorf("temp file %s is left", file.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestFlushNilRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var recorder *Recorder
+	recorder.Inc(OperationsTotal, nil)
+	if err = recorder.Flush(dir); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, TextfileName)); !os.IsNotExist(err) {
+		t.Errorf("textfile is written by nil recorder, err = %v", err)
+	}
+}
+
+func containsLine(lines []string, want string) bool {
+	for _, line := range lines {
+		if line == want {
+			return true
+		}
+	}
+
+	return false
+}
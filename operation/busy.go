@@ -0,0 +1,169 @@
+package operation
+
+import (
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/ty2/vcdfv/logging"
+	"github.com/ty2/vcdfv/vmdiskop"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// interval of checks whether holders exited or a lazily unmounted filesystem is released
+const busyPollInterval = 500 * time.Millisecond
+
+// unmount unmounts mount dir, a busy mount is handled by the busy policy of config. blockDevice may be nil when it
+// is already removed. an error means the disk is still used and must not be detached
+func (unmount *Unmount) unmount(blockDevice *vmdiskop.BlockDevice) error {
+	// already unmounted, a lazily unmounted filesystem of a previous call may still be used
+	if _, err := vmdiskop.FindMountInfo(unmount.MountDir); err != nil {
+		return unmount.checkReleased(blockDevice)
+	}
+
+	holders, err := vmdiskop.Holders(unmount.MountDir)
+	if err != nil {
+		return errcode.Annotate(errcode.UnmountFailed, err, "find processes using mount")
+	}
+
+	if len(holders) == 0 {
+		err := vmdiskop.Unmount(unmount.MountDir)
+		if err == nil {
+			return nil
+		} else if err != syscall.EBUSY {
+			return errcode.Wrap(errcode.UnmountFailed, err, "unmount")
+		}
+		// busy without an open file, e.g. a mount under mount dir
+	}
+
+	policy := UnmountBusyPolicy(unmount.VcdfvConfig)
+	unmount.Logger.Warn("mount is busy", logging.Fields{"policy": policy, "holders": holders})
+
+	switch policy {
+	case UnmountBusyPolicyLazy:
+		return unmount.unmountLazy(blockDevice, holders)
+	case UnmountBusyPolicyKill:
+		return unmount.unmountKill(holders)
+	}
+
+	return busyError(unmount.MountDir, holders, "")
+}
+
+// unmountLazy detaches mount and waits until its filesystem is released, a mount which is still used after
+// the deadline fails with the disk attached. the next call waits again
+func (unmount *Unmount) unmountLazy(blockDevice *vmdiskop.BlockDevice, holders []*vmdiskop.Holder) error {
+	if err := vmdiskop.UnmountLazy(unmount.MountDir); err != nil {
+		return errcode.Wrap(errcode.UnmountFailed, err, "lazy unmount")
+	}
+
+	if blockDevice == nil {
+		return busyError(unmount.MountDir, holders, "lazily unmounted, its device is unknown so its release is not known")
+	}
+
+	deadline := time.Now().Add(UnmountLazyDeadline(unmount.VcdfvConfig))
+	for {
+		err := unmount.checkReleased(blockDevice)
+		if err == nil || !errcode.Is(err, errcode.Busy) || time.Now().After(deadline) {
+			return err
+		}
+
+		if err := unmount.sleep(busyPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// unmountKill sends SIGTERM to holders and SIGKILL to holders which are left after the grace period, then unmounts
+func (unmount *Unmount) unmountKill(holders []*vmdiskop.Holder) error {
+	unmount.Logger.Warn("terminate processes using mount", logging.Fields{"pids": pids(holders)})
+	if err := vmdiskop.Signal(holders, syscall.SIGTERM); err != nil {
+		return errcode.Wrap(errcode.UnmountFailed, err, "terminate processes using mount")
+	}
+
+	deadline := time.Now().Add(UnmountKillGracePeriod(unmount.VcdfvConfig))
+	for len(holders) > 0 && time.Now().Before(deadline) {
+		if err := unmount.sleep(busyPollInterval); err != nil {
+			return err
+		}
+
+		var err error
+		holders, err = vmdiskop.Holders(unmount.MountDir)
+		if err != nil {
+			return errcode.Annotate(errcode.UnmountFailed, err, "find processes using mount")
+		}
+	}
+
+	if len(holders) > 0 {
+		unmount.Logger.Warn("kill processes using mount", logging.Fields{"pids": pids(holders)})
+		if err := vmdiskop.Signal(holders, syscall.SIGKILL); err != nil {
+			return errcode.Wrap(errcode.UnmountFailed, err, "kill processes using mount")
+		}
+		// killed processes release their files when they exit
+		if err := unmount.sleep(busyPollInterval); err != nil {
+			return err
+		}
+	}
+
+	if err := vmdiskop.Unmount(unmount.MountDir); err != nil {
+		if err == syscall.EBUSY {
+			holders, _ = vmdiskop.Holders(unmount.MountDir)
+			return busyError(unmount.MountDir, holders, "processes are killed")
+		}
+		return errcode.Wrap(errcode.UnmountFailed, err, "unmount")
+	}
+
+	return nil
+}
+
+// checkReleased returns a Busy error when filesystem of blockDevice is still mounted, e.g. lazily unmounted and used
+func (unmount *Unmount) checkReleased(blockDevice *vmdiskop.BlockDevice) error {
+	if blockDevice == nil {
+		return nil
+	}
+
+	inUse, err := vmdiskop.DeviceInUse(blockDevice)
+	if err != nil {
+		return errcode.Annotate(errcode.DeviceFailed, err, "check device is released")
+	}
+	if inUse {
+		return errcode.Newf(errcode.Busy, "device %s of %s is still used, e.g. by processes of a lazy unmount", blockDevice.Name, unmount.MountDir)
+	}
+
+	return nil
+}
+
+// sleep waits for d, it returns early when the call is canceled
+func (unmount *Unmount) sleep(d time.Duration) error {
+	ctx := contextOrBackground(unmount.Context)
+	select {
+	case <-ctx.Done():
+		return errcode.Wrap(errcode.Canceled, ctx.Err(), "unmount")
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func busyError(mountDir string, holders []*vmdiskop.Holder, detail string) error {
+	message := "mount " + mountDir + " is busy"
+	if len(holders) > 0 {
+		commands := make([]string, len(holders))
+		for i, holder := range holders {
+			commands[i] = strconv.Itoa(holder.Pid) + "(" + holder.Command + ")"
+		}
+		message += ", used by pids: " + strings.Join(commands, ",")
+	}
+	if detail != "" {
+		message += ", " + detail
+	}
+
+	return errcode.New(errcode.Busy, message)
+}
+
+func pids(holders []*vmdiskop.Holder) []int {
+	pids := make([]int, len(holders))
+	for i, holder := range holders {
+		pids[i] = holder.Pid
+	}
+
+	return pids
+}
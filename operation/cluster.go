@@ -0,0 +1,56 @@
+package operation
+
+import (
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/ty2/vcdfv/vcd"
+)
+
+// DiskName returns VCD disk name of pv or volume name
+func DiskName(vcdfvConfig *config.Vcdfv, pvOrVolumeName string) string {
+	return vcdfvConfig.DiskNamePrefix + pvOrVolumeName
+}
+
+// DiskOwner returns clusterId of disk meta, empty when disk is not owned, e.g. disk is created before clusterId is configured
+func DiskOwner(disk *vcd.VdcDisk) string {
+	if disk.Meta == nil {
+		return ""
+	}
+
+	return disk.Meta.ClusterId
+}
+
+// CheckOwnership returns ForeignDisk error when disk is owned by another cluster and foreign disks are not allowed
+func CheckOwnership(vcdfvConfig *config.Vcdfv, disk *vcd.VdcDisk) error {
+	owner := DiskOwner(disk)
+	if vcdfvConfig.ClusterId == "" || owner == "" || owner == vcdfvConfig.ClusterId || vcdfvConfig.AllowForeignDisks {
+		return nil
+	}
+
+	return errcode.Newf(errcode.ForeignDisk, "disk %s is owned by cluster %s, this cluster is %s", disk.Name, owner, vcdfvConfig.ClusterId)
+}
+
+// FindClusterDisk finds disk by disk name, disks owned by other clusters are skipped so clusters sharing a VDC
+// may use the same disk name. it is NotFound when only other clusters own a disk of the name, mount creates one
+func FindClusterDisk(vdc *vcd.Vdc, vcdfvConfig *config.Vcdfv, diskName string) (*vcd.VdcDisk, error) {
+	disks, err := vdc.FindDisksByDiskName(diskName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ownedDisks []*vcd.VdcDisk
+	for _, disk := range disks {
+		if CheckOwnership(vcdfvConfig, disk) != nil {
+			continue
+		}
+		ownedDisks = append(ownedDisks, disk)
+	}
+
+	if len(ownedDisks) > 1 {
+		return nil, errcode.Newf(errcode.Duplicate, "duplicate disk found, %s", diskName)
+	} else if len(ownedDisks) == 0 {
+		return nil, errcode.Newf(errcode.NotFound, "disk %s not found, %d disks of the name are owned by other clusters", diskName, len(disks))
+	}
+
+	return ownedDisks[0], nil
+}
@@ -1,12 +1,18 @@
 package operation
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/ty2/vcdfv/journal"
+	"github.com/ty2/vcdfv/kube"
+	"github.com/ty2/vcdfv/logging"
+	"github.com/ty2/vcdfv/metrics"
 	"github.com/ty2/vcdfv/vcd"
 	"github.com/ty2/vcdfv/vmdiskop"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,147 +21,512 @@ type Mount struct {
 	MountDir    string
 	Options     *Options
 	VcdfvConfig *config.Vcdfv
-	vdc         *vcd.Vdc
+	// cancels vCD operations, e.g. when the process is terminated
+	Context context.Context
+	Logger  *logging.Logger
+	Metrics *metrics.Recorder
+	// nil disables journal
+	Journals *journal.Store
+	// nil disables Kubernetes events
+	Events *kube.Recorder
+	// resolve and print plan of mount, neither vCD nor the host is changed
+	DryRun  bool
+	vdc     *vcd.Vdc
+	journal *journal.Journal
+	// pending journal of a killed or failed invocation for this volume
+	previous *journal.Journal
+	// format again, mkfs of previous invocation did not finish
+	forceFormat bool
+	// device of disk may be stale, previous invocation did not reattach the disk detached for meta update
+	staleDevice bool
+	// disk is created by this invocation
+	createdDisk bool
+	// partition number of option partition, 0 when partition is not chosen by number
+	partitionNumber int
+	// compensating actions of completed steps, run when a later step fails
+	rollback rollback
 }
 
 func (mount *Mount) Exec() (*ExecResult, error) {
 	var err error
 
 	if mount.MountDir == "" {
-		err = errors.New("mount dir is empty")
+		err = errcode.New(errcode.InvalidArgument, "mount dir is empty")
 		return (&StatusFailure{Error: err}).Exec()
 	}
 
 	if mount.Options.PvOrVolumeName == "" {
-		err = errors.New("disk name is empty")
+		err = errcode.New(errcode.InvalidArgument, "disk name is empty")
 		return (&StatusFailure{Error: err}).Exec()
 	}
 
 	if mount.Options.DiskInitialSize == "" {
-		err = errors.New("disk initial size is empty")
+		err = errcode.New(errcode.InvalidArgument, "disk initial size is empty")
 		return (&StatusFailure{Error: err}).Exec()
 	}
 
-	// Ext4 label limit
-	// The maximum length of the volume label is 16 bytes.
-	if len(mount.Options.PvOrVolumeName) > 16 {
-		err = errors.New("pv or volume name len must not > 16")
+	if mount.Options.VolumeMode != "" && mount.Options.VolumeMode != VolumeModeFilesystem && !mount.Options.BlockMode() {
+		err = errcode.Newf(errcode.InvalidArgument, "unknown volume mode: %s", mount.Options.VolumeMode)
 		return (&StatusFailure{Error: err}).Exec()
 	}
 
+	if mount.Options.Partition != "" {
+		mount.partitionNumber, err = strconv.Atoi(mount.Options.Partition)
+		if err != nil || mount.partitionNumber < 1 {
+			err = errcode.Newf(errcode.InvalidArgument, "partition must be a number from 1, got: %s", mount.Options.Partition)
+			return (&StatusFailure{Error: err}).Exec()
+		}
+	}
+
+	if mount.DryRun {
+		return mount.plan()
+	}
+
+	// load journal before it is replaced by the journal of this invocation
+	mount.previous, err = mount.Journals.Load(mount.Options.PvOrVolumeName)
+	warnJournal(mount.Logger, err)
+	mount.journal, err = mount.Journals.Begin(mount.Options.PvOrVolumeName, "mount", mount.MountDir, mount.previous)
+	warnJournal(mount.Logger, err)
+	mount.resume()
+
 	// init VDC
-	mount.vdc, err = VdcClient(mount.VcdfvConfig)
+	step := mount.phase("vdcLogin")
+	mount.vdc, err = VdcClient(mount.VcdfvConfig, mount.Options)
+	step.end(err)
 	if err != nil {
-		return (&StatusFailure{Error: errors.New("vdc client: " + err.Error())}).Exec()
+		return mount.fail(errcode.Annotate(errcode.VcdApiFailed, err, "vdc client"))
 	}
+	mount.vdc = mount.vdc.WithContext(contextOrBackground(mount.Context)).
+		WithAudit(Auditor(mount.VcdfvConfig, mount.Options.PvOrVolumeName, mount.Logger))
 
 	// find this VM in VDC
+	step = mount.phase("findVm")
 	vm, err := FindVm(mount.vdc, mount.VcdfvConfig.VcdVdcVApp)
+	step.end(err)
 	if err != nil {
-		return (&StatusFailure{Error: errors.New("find VM: " + err.Error())}).Exec()
+		return mount.fail(errcode.Annotate(errcode.NotFound, err, "find VM"))
 	}
+	warnJournal(mount.Logger, mount.journal.SetVm(vm.Name))
 
 	var diskForMount *vcd.VdcDisk
+	var mountedBlockDevice *vmdiskop.BlockDevice
 	// find exists disk
-	foundDisk, err := mount.vdc.FindDiskByDiskName(mount.Options.PvOrVolumeName)
+	step = mount.phase("findDisk")
+	foundDisk, err := FindClusterDisk(mount.vdc, mount.VcdfvConfig, mount.diskName())
+	step.end(ignoreNotFound(err))
 	if err != nil {
-		// error other than disk is not created
-		if err.Error() != "not found" {
-			return (&StatusFailure{Error: errors.New("find disk by disk name foundDisk: " + err.Error())}).Exec()
+		// error other than disk is not created, e.g. duplicate disks or a failed query
+		if !errcode.Is(err, errcode.NotFound) {
+			return mount.fail(errcode.Annotate(errcode.VcdApiFailed, err, "find disk by disk name foundDisk"))
 		}
 	} else if foundDisk != nil {
-		// if disk is attached to VM
-		if foundDisk.AttachedVm != nil {
+		// disk is attached to this VM, e.g. kubelet retries a mount, reuse it without detach and attach
+		if foundDisk.AttachedTo(vm.Name) {
+			step = mount.phase("findAttachedDevice")
+			mountedBlockDevice, err = mount.findAttachedDevice(foundDisk)
+			step.end(err)
+			if err != nil {
+				return mount.fail(errcode.Annotate(errcode.DeviceFailed, err, "find attached device"))
+			}
+		}
+
+		// shared disk is attached to this VM as well, a disk which is not shared is only read by one VM
+		attachedElsewhere := foundDisk.AttachedVm != nil && !foundDisk.AttachedTo(vm.Name)
+		if mount.Options.Shared() && attachedElsewhere && !foundDisk.Shared() {
+			err := errcode.Newf(errcode.AttachedElsewhere, "disk %s is attached to VM %s and its sharing type is not %s", foundDisk.Name, foundDisk.AttachedVm.Name, vcd.DiskSharingTypeShared)
+			return mount.fail(err)
+		}
+
+		// if disk is attached to VM and its device is unknown, a shared disk is only detached from this VM
+		if foundDisk.AttachedVm != nil && mountedBlockDevice == nil && !(mount.Options.Shared() && attachedElsewhere) {
 			// detach disk
-			if err := mount.detachDisk(foundDisk, vm); err != nil {
-				return (&StatusFailure{Error: errors.New("found disk, detach disk: " + err.Error())}).Exec()
+			step = mount.phase("detachDisk")
+			err := mount.detachDisk(foundDisk, vm)
+			step.end(err)
+			if err != nil {
+				return mount.fail(errcode.Annotate(errcode.DetachFailed, err, "found disk, detach disk"))
 			}
+			mount.Events.Normal(kube.ReasonDiskDetached, fmt.Sprintf("disk %s is detached from VM %s", foundDisk.Name, foundDisk.AttachedVm.Name))
 		}
 
 		diskForMount = foundDisk
 	}
 
+	// shared disk is created and filled by its owner
+	if diskForMount == nil && mount.Options.Shared() {
+		err = errcode.Newf(errcode.NotFound, "shared disk %s not found", mount.diskName())
+		return mount.fail(err)
+	}
+
 	// if no disk is found in VDC, create new disk
 	if diskForMount == nil {
+		step = mount.phase("createDisk")
 		diskForMount, err = mount.createDisk()
+		step.end(err)
 		if err != nil {
-			err = errors.New(fmt.Sprintf("create disk: %s", err))
-			return (&StatusFailure{Error: err}).Exec()
+			// vCD created the disk but its task failed or it is not found after, it has no data and is always deleted
+			if diskForMount != nil {
+				warnJournal(mount.Logger, mount.journal.SetDisk(diskForMount.Id, diskForMount.Name, diskForMount.Href))
+				mount.rollback.add("deleteDisk", mount.deleteDiskRollback(diskForMount))
+			}
+			return mount.fail(errcode.Annotate(errcode.CreateFailed, err, "create disk"))
+		}
+		mount.createdDisk = true
+		mount.diskCreatedEvent(diskForMount)
+
+		// disk created by this invocation has no data, delete it when asked to leave nothing behind
+		if mount.VcdfvConfig.DeleteCreatedDiskOnFailure {
+			mount.rollback.add("deleteDisk", mount.deleteDiskRollback(diskForMount))
 		}
 	}
 
-	// get block devices info for compare later
-	beforeMountBlockDevices, err := vmdiskop.BlockDevices()
-	if err != nil {
-		return (&StatusFailure{Error: errors.New("before block devices: " + err.Error())}).Exec()
+	warnJournal(mount.Logger, mount.journal.SetDisk(diskForMount.Id, diskForMount.Name, diskForMount.Href))
+
+	// filesystem UUIDs of disk before this invocation rewrites disk meta
+	fsUuids := expectedFsUuids(diskForMount)
+
+	// attach disk when it is not attached to this VM
+	if mountedBlockDevice == nil {
+		if mount.staleDevice {
+			step = mount.phase(phaseRemoveStaleDevice)
+			err = mount.removeStaleDevice(diskForMount)
+			step.end(err)
+			if err != nil {
+				return mount.fail(errcode.Annotate(errcode.DeviceFailed, err, "remove stale device"))
+			}
+		}
+
+		// get block devices info for compare later
+		beforeMountBlockDevices, err := vmdiskop.BlockDevices()
+		if err != nil {
+			return mount.fail(errcode.Annotate(errcode.DeviceFailed, err, "before block devices"))
+		}
+
+		// check disk is attached, filesystem UUID is the disk UUID
+		diskUuid := vcd.DiskUuid(diskForMount.Id)
+		// assume the disk not attached to the VM, filesystem may be in a partition
+		attachedDevice := vmdiskop.Find(beforeMountBlockDevices, func(blockDevice *vmdiskop.BlockDevice) bool {
+			return diskUuid != "" && strings.EqualFold(blockDevice.Uuid, diskUuid)
+		})
+		if attachedDevice != nil {
+			err := errcode.New(errcode.AlreadyAttached, "disk is already attached")
+			return mount.fail(err)
+		}
+
+		// detach on failure of any later step, a failed attach may still have attached the disk.
+		// its device is only known after attach, the action is replaced then
+		mount.rollback.add("detachDisk", mount.detachDiskRollback(diskForMount, nil, vm))
+
+		// attach disk
+		step = mount.phase("attachDisk")
+		attachStartedAt := time.Now()
+		err = mount.vdc.AttachDisk(vm, diskForMount, -1, -1)
+		step.end(err)
+		if err != nil {
+			return mount.fail(errcode.Annotate(errcode.AttachFailed, err, "attach disk"))
+		}
+		mount.attachedEvent(diskForMount, vm, time.Since(attachStartedAt))
+
+		// get block devices after attached
+		step = mount.phase("discoverDevice")
+		blockDevices, err := vmdiskop.BlockDevices()
+		if err != nil {
+			step.end(err)
+			return mount.fail(errcode.Annotate(errcode.DeviceFailed, err, "list block devices"))
+		}
+
+		// found attached disk in block device list
+		mountedBlockDevice, err = mount.findMountedDevice(beforeMountBlockDevices, blockDevices)
+		step.end(err)
+		if err != nil {
+			return mount.fail(errcode.Annotate(errcode.DeviceFailed, err, "find mounted device"))
+		} else if mountedBlockDevice == nil {
+			// fail to mount
+			err := errcode.New(errcode.DeviceNotFound, "cannot found new device")
+			return mount.fail(err)
+		}
+		mount.rollback.replace("detachDisk", mount.detachDiskRollback(diskForMount, mountedBlockDevice, vm))
 	}
 
-	// check disk is attached
-	for _, blockDevice := range beforeMountBlockDevices {
-		// assume the disk not attached to the VM
-		if blockDevice.Label == mount.Options.PvOrVolumeName {
-			err := errors.New("disk is already attached")
-			return (&StatusFailure{Error: err}).Exec()
+	warnJournal(mount.Logger, mount.journal.SetDevice(mountedBlockDevice.Name))
+
+	// holders of a shared disk are its reference count
+	if mount.Options.Shared() {
+		step = mount.phase("addHolder")
+		err = mount.vdc.AddDiskHolder(diskForMount, vm.Name)
+		step.end(err)
+		if err != nil {
+			return mount.fail(errcode.Annotate(errcode.MetaFailed, err, "add holder"))
 		}
+
+		heldDisk := diskForMount
+		mount.rollback.add("removeHolder", func() error {
+			return mount.vdc.RemoveDiskHolder(heldDisk, vm.Name)
+		})
 	}
 
-	// attach disk
-	err = mount.vdc.AttachDisk(vm, diskForMount, -1, -1)
+	// partition a new disk, a disk with filesystem or partition table is never partitioned
+	if !mount.Options.Shared() && mount.Options.CreatePartition == "true" && mountedBlockDevice.FsType == "" && len(vmdiskop.Partitions(mountedBlockDevice)) == 0 {
+		step = mount.phase("createPartition")
+		mountedBlockDevice, err = mount.createPartition(diskForMount, mountedBlockDevice)
+		step.end(err)
+		if err != nil {
+			return mount.fail(errcode.Annotate(errcode.DeviceFailed, err, "create partition"))
+		}
+	}
+
+	// partition or the disk itself is mounted
+	targetBlockDevice, err := mount.targetDevice(mountedBlockDevice)
 	if err != nil {
-		return (&StatusFailure{Error: errors.New("attach disk: " + err.Error())}).Exec()
+		return mount.fail(errcode.Annotate(errcode.DeviceFailed, err, "find partition"))
 	}
 
-	// get block devices after attached
-	blockDevices, err := vmdiskop.BlockDevices()
+	// already mounted by previous call
+	if mount.mounted(targetBlockDevice) {
+		mount.Logger.Info("already mounted", nil)
+		return mount.result(diskForMount, targetBlockDevice)
+	}
+
+	// Kubernetes identity of disk is for chargeback and triage, it never fails the mount. a shared disk is not updated,
+	// a repeated call of a mounted volume does not rewrite it
+	if !mount.Options.Shared() {
+		step = mount.phase("setIdentity")
+		step.end(mount.vdc.SetDiskIdentity(diskForMount, mount.identity()))
+	}
+
+	// other VMs may read a shared disk, it is never formatted
+	if mount.Options.Shared() && !mount.Options.BlockMode() && !vmdiskop.IsFormatted(targetBlockDevice) {
+		err = errcode.Newf(errcode.FormatFailed, "shared disk %s is not formatted", diskForMount.Name)
+		return mount.fail(err)
+	}
+
+	// if disk is not format then format it, a block volume is never formatted
+	formatted := false
+	if !mount.Options.Shared() && !mount.Options.BlockMode() && (mount.forceFormat || !vmdiskop.IsFormatted(targetBlockDevice)) {
+		if err := mount.checkUnformatted(diskForMount, targetBlockDevice); err != nil {
+			return mount.fail(err)
+		}
+
+		step = mount.phase(phaseFormatDisk)
+		err = mount.formatDisk(diskForMount, targetBlockDevice)
+		step.end(err)
+		if err != nil {
+			return mount.fail(errcode.Annotate(errcode.FormatFailed, err, "format disk"))
+		}
+		mount.diskFormattedEvent(diskForMount, targetBlockDevice)
+		formatted = true
+	}
+
+	// never mount filesystem of another disk, it is verified before disk meta records it.
+	// filesystem of a block volume belongs to the workload
+	var filesystem *vmdiskop.BlockDevice
+	if !mount.Options.BlockMode() {
+		step = mount.phase("verifyFilesystem")
+		filesystem, err = mount.verifyFilesystem(fsUuids, diskForMount, targetBlockDevice)
+		step.end(err)
+		if err != nil {
+			return mount.fail(err)
+		}
+	}
+
+	// set disk meta, disk meta of a shared disk is kept because it must be detached for update
+	if !mount.Options.Shared() {
+		step = mount.phase("setDiskMeta")
+		mountedBlockDevice, err = mount.setDiskMeta(diskForMount, mountedBlockDevice, vm, filesystem)
+		step.end(err)
+		if err != nil {
+			return mount.fail(errcode.Annotate(errcode.MetaFailed, err, "set disk meta"))
+		}
+
+		// device is renamed when disk is reattached for meta
+		targetBlockDevice, err = mount.targetDevice(mountedBlockDevice)
+		if err != nil {
+			return mount.fail(errcode.Annotate(errcode.DeviceFailed, err, "find partition after set disk meta"))
+		}
+	}
+
+	// check filesystem which is not formatted by this call, e.g. of a node which crashed. a shared disk may be
+	// mounted by other VMs, it is never checked
+	if !mount.Options.Shared() && !mount.Options.BlockMode() && !formatted {
+		step = mount.phase("fsck")
+		err = mount.checkFilesystem(diskForMount, targetBlockDevice)
+		step.end(err)
+		if err != nil {
+			return mount.fail(err)
+		}
+	}
+
+	// mount disk, or bind its device node for a block volume. shared disk is always read only
+	step = mount.phase("mount")
+	if mount.Options.BlockMode() && mount.Options.Shared() {
+		err = vmdiskop.BindDevice(targetBlockDevice, mount.MountDir, "ro")
+	} else if mount.Options.BlockMode() {
+		err = vmdiskop.BindDevice(targetBlockDevice, mount.MountDir, mount.Options.Readwrite)
+	} else if mount.Options.Shared() {
+		err = vmdiskop.MountSharedReadOnly(targetBlockDevice, mount.MountDir, mount.Options.FsType)
+	} else {
+		err = vmdiskop.Mount(targetBlockDevice, mount.MountDir, mount.Options.FsType, mount.Options.Readwrite)
+	}
+	step.end(err)
 	if err != nil {
-		return (&StatusFailure{Error: errors.New("list block devices: " + err.Error())}).Exec()
+		return mount.fail(errcode.Annotate(errcode.MountFailed, err, "mount"))
 	}
 
-	// found attached disk in block device list
-	mountedBlockDevice, err := mount.findMountedDevice(beforeMountBlockDevices, blockDevices)
-	if mountedBlockDevice == nil {
-		// fail to mount
-		err := errors.New("cannot found new device")
-		return (&StatusFailure{Error: err}).Exec()
-	} else if err != nil {
-		err := errors.New("find mounted device: " + err.Error())
-		return (&StatusFailure{Error: err}).Exec()
+	// output
+	return mount.result(diskForMount, targetBlockDevice)
+}
+
+// fail rolls back completed steps and reports the error with results of the rollback.
+// a journal is kept as failed when rollback fails or it has steps to resume, so the next invocation resumes from it
+func (mount *Mount) fail(err error) (*ExecResult, error) {
+	// rollback still runs when the context of this invocation is canceled
+	if mount.vdc != nil {
+		mount.vdc = mount.vdc.WithContext(context.Background())
+	}
+
+	mount.Events.Warning(kube.ReasonMountFailed, err.Error())
+
+	results := mount.rollback.run(mount.phase)
+	if forceFormat, staleDevice := resumeState(mount.journal); rollbackSucceeded(results) && !forceFormat && !staleDevice {
+		warnJournal(mount.Logger, mount.journal.Finish())
+	} else {
+		warnJournal(mount.Logger, mount.journal.Fail(err))
 	}
 
-	// if disk is not format then format it
-	if !vmdiskop.IsFormatted(mountedBlockDevice) {
-		if err = mount.formatDisk(diskForMount, mountedBlockDevice); err != nil {
-			return (&StatusFailure{Error: errors.New("format disk error:" + err.Error())}).Exec()
+	return (&StatusFailure{Error: err, Rollback: results}).Exec()
+}
+
+func (mount *Mount) diskCreatedEvent(disk *vcd.VdcDisk) {
+	mount.Events.Normal(kube.ReasonDiskCreated, fmt.Sprintf("disk %s of %s is created, %d bytes are provisioned", disk.Name, mount.Options.DiskInitialSize, disk.Size))
+}
+
+// attachedEvent posts SlowAttach when attach took longer than kubeEventSlowAttach of config
+func (mount *Mount) attachedEvent(disk *vcd.VdcDisk, vm *vcd.VAppVm, attachDuration time.Duration) {
+	if attachDuration > SlowAttach(mount.VcdfvConfig) {
+		mount.Events.Warning(kube.ReasonSlowAttach, fmt.Sprintf("attach of disk %s to VM %s took %s", disk.Name, vm.Name, attachDuration.Round(time.Second)))
+	}
+}
+
+func (mount *Mount) diskFormattedEvent(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice) {
+	mount.Events.Normal(kube.ReasonDiskFormatted, fmt.Sprintf("device %s of disk %s is formatted %s", blockDevice.Name, disk.Name, mount.Options.FsType))
+}
+
+func (mount *Mount) fsckRepairedEvent(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice) {
+	mount.Events.Warning(kube.ReasonFsckRepaired, fmt.Sprintf("fsck repaired filesystem of device %s of disk %s", blockDevice.Name, disk.Name))
+}
+
+// detachDiskRollback returns rollback of attach of disk to vm, blockDevice is nil when its device is not found
+func (mount *Mount) detachDiskRollback(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice, vm *vcd.VAppVm) func() error {
+	return func() error {
+		return mount.detachAttachedDisk(disk, blockDevice, vm)
+	}
+}
+
+// deleteDiskRollback returns rollback of create of disk
+func (mount *Mount) deleteDiskRollback(disk *vcd.VdcDisk) func() error {
+	return func() error {
+		return mount.vdc.DeleteDisk(disk)
+	}
+}
+
+// detachAttachedDisk removes device of disk and detaches disk when it is attached to this VM
+func (mount *Mount) detachAttachedDisk(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice, vm *vcd.VAppVm) error {
+	if blockDevice != nil {
+		if err := vmdiskop.RemoveSCSIDevice(blockDevice); err != nil {
+			return errcode.Annotate(errcode.DeviceFailed, err, "remove SCSI device")
 		}
+	} else if err := mount.removeStaleDevice(disk); err != nil {
+		return errcode.Annotate(errcode.DeviceFailed, err, "remove device")
 	}
 
-	// set disk meta
-	err = mount.setDiskMeta(diskForMount, mountedBlockDevice, vm)
+	// attached state of disk is changed since it is found
+	disk, err := mount.vdc.FindDiskById(disk.Id)
 	if err != nil {
-		err := errors.New("set disk meta: " + err.Error())
-		return (&StatusFailure{Error: err}).Exec()
+		return errcode.Annotate(errcode.VcdApiFailed, err, "find disk by id")
+	}
+
+	if !disk.AttachedTo(vm.Name) {
+		return nil
 	}
 
-	// mount disk
-	err = vmdiskop.Mount(mountedBlockDevice, mount.MountDir, mount.Options.FsType, mount.Options.Readwrite)
+	err = mount.vdc.DetachDisk(vm, disk)
 	if err != nil {
-		return (&StatusFailure{Error: errors.New("mount: " + err.Error())}).Exec()
+		return errcode.Annotate(errcode.DetachFailed, err, "detach disk")
 	}
 
-	// output
+	return nil
+}
+
+func (mount *Mount) result(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice) (*ExecResult, error) {
+	// nothing to resume
+	warnJournal(mount.Logger, mount.journal.Finish())
+
 	return (&StatusSuccess{JsonMessageStruct: struct {
 		DiskId       string `json:"diskId"`
 		DiskName     string `json:"diskName"`
 		VmDeviceName string `json:"vmDeviceName"`
 		MountPoint   string `json:"mountPoint"`
+		// provisioned size of disk in bytes
+		DiskSize int `json:"diskSize"`
 	}{
-		DiskId:       diskForMount.Id,
-		DiskName:     diskForMount.Name,
-		VmDeviceName: mountedBlockDevice.Name,
+		DiskId:       disk.Id,
+		DiskName:     disk.Name,
+		DiskSize:     disk.Size,
+		VmDeviceName: blockDevice.Name,
 		MountPoint:   mount.MountDir,
 	}}).Exec()
 }
 
+// findAttachedDevice finds device of disk attached to this VM by filesystem UUID or label of disk meta,
+// the UUID and label derived from disk are used when disk meta has none. nil when device is not found
+func (mount *Mount) findAttachedDevice(disk *vcd.VdcDisk) (*vmdiskop.BlockDevice, error) {
+	blockDevices, err := vmdiskop.BlockDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	return attachedDevice(disk, blockDevices), nil
+}
+
+// attachedDevice returns device of disk in blockDevices, nil when it is not found
+func attachedDevice(disk *vcd.VdcDisk, blockDevices []*vmdiskop.BlockDevice) *vmdiskop.BlockDevice {
+	fsUuids := []string{vcd.DiskUuid(disk.Id)}
+	fsLabels := []string{vmdiskop.Ext4Label(disk.Name)}
+	if disk.Meta != nil {
+		fsUuids = append(fsUuids, disk.Meta.FsUuid)
+		fsLabels = append(fsLabels, disk.Meta.FsLabel)
+	}
+
+	// filesystem may be in a partition, the disk of it is returned
+	uuidDevice := vmdiskop.Find(blockDevices, func(blockDevice *vmdiskop.BlockDevice) bool {
+		for _, fsUuid := range fsUuids {
+			if fsUuid != "" && strings.EqualFold(blockDevice.Uuid, fsUuid) {
+				return true
+			}
+		}
+		return false
+	})
+	if uuidDevice != nil {
+		return uuidDevice.Disk()
+	}
+
+	// label is not unique, only trust it when device name also matches meta
+	if disk.Meta != nil {
+		for _, blockDevice := range blockDevices {
+			if blockDevice.Name != disk.Meta.DeviceName || blockDevice.Label == "" {
+				continue
+			}
+			for _, fsLabel := range fsLabels {
+				if blockDevice.Label == fsLabel {
+					return blockDevice
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 func (mount *Mount) findMountedDevice(beforeMountedBlockDevices []*vmdiskop.BlockDevice, afterMountedBlockDevices []*vmdiskop.BlockDevice) (*vmdiskop.BlockDevice, error) {
 	// get all before block device names for compare
 	beforeBlockDeviceNames := map[string]interface{}{}
@@ -168,7 +539,7 @@ func (mount *Mount) findMountedDevice(beforeMountedBlockDevices []*vmdiskop.Bloc
 	for _, blockDevice := range afterMountedBlockDevices {
 		if _, ok := beforeBlockDeviceNames[blockDevice.Name]; !ok {
 			if mountedBlockDevice != nil {
-				return nil, errors.New(fmt.Sprintf("multiple new device is found: %s, %s", mountedBlockDevice.Name, blockDevice.Name))
+				return nil, errcode.Newf(errcode.DeviceFailed, "multiple new device is found: %s, %s", mountedBlockDevice.Name, blockDevice.Name)
 			}
 			// new device here
 			mountedBlockDevice = blockDevice
@@ -178,119 +549,197 @@ func (mount *Mount) findMountedDevice(beforeMountedBlockDevices []*vmdiskop.Bloc
 	return mountedBlockDevice, nil
 }
 
+// createDisk creates disk of the volume. on error the disk is returned when vCD created it, e.g. its task failed
 func (mount *Mount) createDisk() (*vcd.VdcDisk, error) {
-	// convert DiskInitialSize string to byte size
-	size, err := SizeStringToByteUnit(mount.Options.DiskInitialSize)
+	// convert DiskInitialSize quantity to byte size which vCD allocates
+	size, err := DiskSize(mount.VcdfvConfig, mount.Options.DiskInitialSize)
+	if err != nil {
+		return nil, errcode.Annotate(errcode.InvalidArgument, err, "disk size")
+	}
+
+	// record owner on creation, the disk is never left without owner
+	now := time.Now()
+	description, err := json.Marshal(&vcd.VdcDiskMeta{
+		ClusterId: mount.VcdfvConfig.ClusterId,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
 	if err != nil {
-		return nil, errors.New("size string to byte unit: " + err.Error())
+		return nil, err
 	}
 
 	// create disk
 	disk, err := mount.vdc.CreateDisk(&vcd.VdcDisk{
-		Name: mount.Options.PvOrVolumeName,
-		Size: size,
+		Name:        mount.diskName(),
+		Size:        size,
+		Description: string(description),
 	})
 	if err != nil {
-		return nil, errors.New("create disk: " + err.Error())
+		return diskIfCreated(disk), errcode.Annotate(errcode.CreateFailed, err, "create disk")
 	}
 
 	// find new disk to get new disk id
-	disk, err = mount.vdc.FindDiskByDiskName(mount.Options.PvOrVolumeName)
+	foundDisk, err := FindClusterDisk(mount.vdc, mount.VcdfvConfig, mount.diskName())
 	if err != nil {
-		return nil, errors.New("find disk by disk name diskForMount: " + err.Error())
+		return diskIfCreated(disk), errcode.Annotate(errcode.VcdApiFailed, err, "find disk by disk name diskForMount")
 	}
 
-	return disk, nil
+	return foundDisk, nil
+}
+
+// diskIfCreated returns disk when vCD created it, it has href of vCD then
+func diskIfCreated(disk *vcd.VdcDisk) *vcd.VdcDisk {
+	if disk == nil || disk.Href == "" {
+		return nil
+	}
+
+	return disk
 }
 
 func (mount *Mount) formatDisk(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice) error {
 	if mount.Options.FsType != "ext4" {
-		return errors.New(fmt.Sprintf("only support file format ext4, got: %s", mount.Options.FsType))
+		return errcode.Newf(errcode.InvalidArgument, "only support file format ext4, got: %s", mount.Options.FsType)
 	}
 
-	// get disk UUID
-	diskIdArr := strings.Split(disk.Id, ":")
-	uuid := diskIdArr[len(diskIdArr)-1]
+	// use disk UUID as filesystem UUID, unmount finds the disk by it
+	uuid := vcd.DiskUuid(disk.Id)
+	if uuid == "" {
+		return errcode.New(errcode.FormatFailed, "format device to ext4 UUID is invalid: "+disk.Id)
+	}
 
-	// check whether UUID is valid
-	if !regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-4[a-fA-F0-9]{3}-[8|9|aA|bB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$").MatchString(uuid) {
-		return errors.New("format device to ext4 UUID is invalid: " + disk.Id)
+	// format disk, disk name may be longer than ext4 label
+	output, err := vmdiskop.FormatDeviceToExt4(blockDevice, vmdiskop.Ext4Label(disk.Name), uuid, time.Minute)
+	if err != nil {
+		return errcode.Annotate(errcode.FormatFailed, err, fmt.Sprintf("format device to ext4, %s", output))
 	}
 
-	// format disk
-	output, err := vmdiskop.FormatDeviceToExt4(blockDevice, disk.Name, uuid, time.Minute)
+	return nil
+}
+
+// checkFilesystem repairs errors of filesystem of device, a filesystem with errors which fsck cannot repair fails the mount
+func (mount *Mount) checkFilesystem(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice) error {
+	repaired, output, err := vmdiskop.CheckFilesystem(blockDevice, fsckTimeout)
 	if err != nil {
-		return errors.New(fmt.Sprintf("format device to ext4: %s, %s", err.Error(), output))
+		return errcode.Wrap(errcode.FsckFailed, err, fmt.Sprintf("fsck device %s, %s", blockDevice.Name, output))
+	}
+
+	if repaired {
+		mount.Logger.Warn("fsck repaired filesystem", logging.Fields{"disk": disk.Name, "device": blockDevice.Name, "output": output})
+		mount.fsckRepairedEvent(disk, blockDevice)
 	}
 
 	return nil
 }
 
-func (mount *Mount) setDiskMeta(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice, vm *vcd.VAppVm) error {
+// setDiskMeta records VM, device and filesystem of disk in its meta. filesystem is the verified filesystem of disk,
+// nil for a block volume, meta never records a filesystem UUID which is not verified
+func (mount *Mount) setDiskMeta(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice, vm *vcd.VAppVm, filesystem *vmdiskop.BlockDevice) (*vmdiskop.BlockDevice, error) {
 	// set disk meta
 	// 1. must detach disk before update disk info
 	// 2. update disk info
 	// 3. attach disk back
 	// 4. refresh blk list
 
+	// a block volume has no filesystem of vcdfv, the workload may create its own
+	fsLabel, fsUuid := "", ""
+	if filesystem != nil {
+		fsLabel, fsUuid = filesystem.Label, filesystem.Uuid
+		if fsLabel == "" {
+			fsLabel = vmdiskop.Ext4Label(disk.Name)
+		}
+	}
+
+	// keep owner, foreign disks are only mounted when allowForeignDisks
+	clusterId := DiskOwner(disk)
+	if clusterId == "" {
+		clusterId = mount.VcdfvConfig.ClusterId
+	}
+
 	// speed up process.
 	// if old meta is same as new meta, no need update and exit
-	if disk.Meta != nil && disk.Meta.VmName == vm.Name && disk.Meta.DeviceName == blockDevice.Name {
-		return nil
+	if disk.Meta != nil && disk.Meta.VmName == vm.Name && disk.Meta.DeviceName == blockDevice.Name &&
+		disk.Meta.FsLabel == fsLabel && disk.Meta.FsUuid == fsUuid && disk.Meta.ClusterId == clusterId {
+		return blockDevice, nil
 	}
 
+	// every step is journaled, a killed process leaves the disk detached with a stale device
+	step := mount.phase(phaseMetaDetach)
 	err := mount.vdc.DetachDisk(vm, disk)
+	step.end(err)
 	if err != nil {
-		return errors.New(fmt.Sprintf("detach disk: %s", err.Error()))
+		return nil, errcode.Annotate(errcode.DetachFailed, err, "detach disk")
 	}
 
+	step = mount.phase("metaUpdate")
 	disk, err = mount.vdc.SetDiskMeta(disk, &vcd.VdcDiskMeta{
 		VmName:     vm.Name,
 		DeviceName: blockDevice.Name,
+		FsLabel:    fsLabel,
+		FsUuid:     fsUuid,
+		ClusterId:  clusterId,
 	})
+	step.end(err)
 	if err != nil {
-		return errors.New(fmt.Sprintf("%s", err.Error()))
+		return nil, errcode.Annotate(errcode.MetaFailed, err, "set disk meta")
 	}
+	mount.Events.Normal(kube.ReasonDiskMetaRewritten, fmt.Sprintf("meta of disk %s is rewritten, device %s of VM %s", disk.Name, blockDevice.Name, vm.Name))
 
+	step = mount.phase("metaRemoveScsiDevice")
 	err = vmdiskop.RemoveSCSIDevice(blockDevice)
+	step.end(err)
 	if err != nil {
-		return errors.New(fmt.Sprintf("sremove SCSI Device: %s", err.Error()))
+		return nil, errcode.Annotate(errcode.DeviceFailed, err, "remove SCSI Device")
 	}
 
 	blockDevices, err := vmdiskop.BlockDevices()
 	if err != nil {
-		return errors.New(fmt.Sprintf("list block devices: %s", err.Error()))
+		return nil, errcode.Annotate(errcode.DeviceFailed, err, "list block devices")
 	}
 
+	step = mount.phase(phaseMetaReattach)
 	err = mount.vdc.AttachDisk(vm, disk, -1, -1)
+	step.end(err)
 	if err != nil {
-		return errors.New(fmt.Sprintf("attach disk: %s", err.Error()))
+		return nil, errcode.Annotate(errcode.AttachFailed, err, "attach disk")
 	}
 
 	afterScannedBlockDevices, err := vmdiskop.BlockDevices()
 	if err != nil {
-		return errors.New(fmt.Sprintf("list block devices 2: %s", err.Error()))
+		return nil, errcode.Annotate(errcode.DeviceFailed, err, "list block devices 2")
 	}
 
 	afterScannedBlockDevice, err := mount.findMountedDevice(blockDevices, afterScannedBlockDevices)
 	if err != nil {
 		// not found or other error
-		return errors.New(fmt.Sprintf("after scanned block device: %s", err.Error()))
+		return nil, errcode.Annotate(errcode.DeviceFailed, err, "after scanned block device")
+	} else if afterScannedBlockDevice == nil {
+		return nil, errcode.New(errcode.DeviceNotFound, "after scanned block device: cannot found new device")
 	}
+	warnJournal(mount.Logger, mount.journal.SetDevice(afterScannedBlockDevice.Name))
+	mount.rollback.replace("detachDisk", mount.detachDiskRollback(disk, afterScannedBlockDevice, vm))
 
-	// assume disk is formatted
-	// make sure before detach and after attach disk is the same
-	if afterScannedBlockDevice.Label != blockDevice.Label {
-		// not the same, set disk meta again
-		return mount.setDiskMeta(disk, afterScannedBlockDevice, vm)
+	// the reattached device must have the verified filesystem, a device of another disk is never recorded
+	if filesystem != nil {
+		afterTargetBlockDevice, err := mount.targetDevice(afterScannedBlockDevice)
+		if err != nil {
+			return nil, errcode.Annotate(errcode.DeviceFailed, err, "find partition of reattached device")
+		}
+		if !strings.EqualFold(afterTargetBlockDevice.Uuid, filesystem.Uuid) {
+			return nil, errcode.Newf(errcode.FsUuidMismatch, "filesystem UUID %q of reattached device %s is not of disk %s, expect: %s", afterTargetBlockDevice.Uuid, afterTargetBlockDevice.Name, disk.Name, filesystem.Uuid)
+		}
 	}
 
-	return nil
+	// device is renamed by reattach, set disk meta again
+	if afterScannedBlockDevice.Name != blockDevice.Name {
+		return mount.setDiskMeta(disk, afterScannedBlockDevice, vm, filesystem)
+	}
+
+	return afterScannedBlockDevice, nil
 }
 
 func (mount *Mount) detachDisk(disk *vcd.VdcDisk, vm *vcd.VAppVm) error {
 	// if disk is attached to this VM
-	if vm.Name == disk.AttachedVm.Name {
+	if disk.AttachedTo(vm.Name) {
 		// TODO Detach disk in VM by HCTL
 		if disk.Meta != nil {
 			vmdiskop.RemoveSCSIDevice(&vmdiskop.BlockDevice{
@@ -302,8 +751,203 @@ func (mount *Mount) detachDisk(disk *vcd.VdcDisk, vm *vcd.VAppVm) error {
 	// detach disk
 	err := mount.vdc.DetachDisk(vm, disk)
 	if err != nil {
-		return errors.New(fmt.Sprintf("disk is attached to VM %s and cannot detach disk %s from the VM", disk.AttachedVm.Name, disk.Name))
+		if disk.AttachedTo(vm.Name) {
+			return errcode.Annotate(errcode.DetachFailed, err, fmt.Sprintf("cannot detach disk %s from this VM", disk.Name))
+		}
+		return errcode.Wrap(errcode.AttachedElsewhere, err, fmt.Sprintf("disk is attached to VM %s and cannot detach disk %s from the VM", disk.AttachedVm.Name, disk.Name))
+	}
+
+	return nil
+}
+
+// identity returns Kubernetes objects of the volume passed by kubelet
+func (mount *Mount) identity() *vcd.DiskIdentity {
+	return &vcd.DiskIdentity{
+		Pv:             mount.Options.PvOrVolumeName,
+		Namespace:      mount.Options.PodNamespace,
+		Pod:            mount.Options.PodName,
+		PodUid:         mount.Options.PodUid,
+		ServiceAccount: mount.Options.ServiceAccountName,
+	}
+}
+
+// diskName returns VCD disk name of the volume, with prefix of config
+func (mount *Mount) diskName() string {
+	return DiskName(mount.VcdfvConfig, mount.Options.PvOrVolumeName)
+}
+
+// resume sets up this invocation by the steps previous invocation did not finish
+func (mount *Mount) resume() {
+	previous := mount.previous
+	if previous == nil {
+		return
+	}
+
+	fields := logging.Fields{"previousOperation": previous.Operation, "previousMountDir": previous.MountDir}
+	if pending := previous.Pending(); pending != nil {
+		fields["pendingStep"] = pending.Name
+		fields["pendingStatus"] = pending.Status
+	}
+	mount.Logger.Warn("resume pending journal", fields)
+
+	mount.forceFormat, mount.staleDevice = resumeState(previous)
+}
+
+// resumeState returns what journal of mount leaves for the next mount. a killed mkfs leaves a partial filesystem
+// which may look formatted, the disk was empty before. a format which failed cleanly is not forced, the disk is
+// checked and unformattedDiskPolicy applies again. a disk detached for meta update may leave a stale device
+func resumeState(mountJournal *journal.Journal) (forceFormat bool, staleDevice bool) {
+	if mountJournal == nil || mountJournal.Operation != "mount" {
+		return false, false
+	}
+
+	forceFormat = mountJournal.Interrupted(phaseFormatDisk)
+	staleDevice = mountJournal.Unresolved(phaseMetaDetach, phaseMetaReattach, phaseRemoveStaleDevice)
+	return forceFormat, staleDevice
+}
+
+// removeStaleDevice removes device of disk which is not attached to this VM, it is left by a killed meta update
+func (mount *Mount) removeStaleDevice(disk *vcd.VdcDisk) error {
+	diskUuid := vcd.DiskUuid(disk.Id)
+	if diskUuid == "" {
+		return nil
+	}
+
+	blockDevices, err := vmdiskop.BlockDevices()
+	if err != nil {
+		return err
+	}
+
+	for _, blockDevice := range blockDevices {
+		// filesystem may be in a partition of the disk
+		staleDevice := vmdiskop.Find([]*vmdiskop.BlockDevice{blockDevice}, func(device *vmdiskop.BlockDevice) bool {
+			return strings.EqualFold(device.Uuid, diskUuid)
+		})
+		if staleDevice != nil {
+			mount.Logger.Warn("remove stale device", logging.Fields{"device": blockDevice.Name})
+			if err := vmdiskop.RemoveSCSIDevice(blockDevice); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
+
+// targetDevice returns partition chosen by options, the only partition or the disk when it is not partitioned
+func (mount *Mount) targetDevice(blockDevice *vmdiskop.BlockDevice) (*vmdiskop.BlockDevice, error) {
+	if mount.partitionNumber > 0 || mount.Options.PartitionLabel != "" {
+		return vmdiskop.FindPartition(blockDevice, mount.partitionNumber, mount.Options.PartitionLabel)
+	}
+
+	partitions := vmdiskop.Partitions(blockDevice)
+	switch {
+	case len(partitions) == 1:
+		return partitions[0], nil
+	case len(partitions) > 1:
+		return nil, errcode.Newf(errcode.InvalidArgument, "device %s has %d partitions, choose one by option partition or partitionLabel", blockDevice.Name, len(partitions))
+	case blockDevice.PtType != "":
+		return nil, errcode.Newf(errcode.DeviceNotFound, "partition table of device %s has no partition", blockDevice.Name)
+	}
+
+	return blockDevice, nil
+}
+
+// createPartition creates a single GPT partition named by the ext4 label of disk, the disk is listed again for it
+func (mount *Mount) createPartition(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice) (*vmdiskop.BlockDevice, error) {
+	output, err := vmdiskop.CreateGptPartition(blockDevice, vmdiskop.Ext4Label(disk.Name), time.Minute)
+	if err != nil {
+		return nil, errcode.Annotate(errcode.DeviceFailed, err, fmt.Sprintf("create GPT partition, %s", output))
+	}
+
+	return vmdiskop.FindDeviceByDeviceName(blockDevice.Name)
+}
+
+// checkUnformatted returns an UnformattedDisk error when unformattedDiskPolicy refuses to format disk. a disk which
+// a killed call of this volume started to format is formatted again
+func (mount *Mount) checkUnformatted(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice) error {
+	if mount.createdDisk || mount.forceFormat {
+		return nil
+	}
+
+	switch UnformattedDiskPolicy(mount.VcdfvConfig) {
+	case UnformattedDiskPolicyRefuse:
+		return errcode.Newf(errcode.UnformattedDisk, "device %s of disk %s is unformatted, unformattedDiskPolicy %s only formats disks created by mount", blockDevice.Name, disk.Name, UnformattedDiskPolicyRefuse)
+	case UnformattedDiskPolicyFormatNew:
+		if disk.Meta != nil && disk.Meta.FsUuid != "" {
+			return errcode.Newf(errcode.UnformattedDisk, "device %s of disk %s is unformatted, disk meta records filesystem %s", blockDevice.Name, disk.Name, disk.Meta.FsUuid)
+		}
+	}
+
+	return nil
+}
+
+// expectedFsUuids returns filesystem UUIDs of disk, the disk UUID which format uses and the UUID of disk meta
+func expectedFsUuids(disk *vcd.VdcDisk) []string {
+	var fsUuids []string
+	if uuid := vcd.DiskUuid(disk.Id); uuid != "" {
+		fsUuids = append(fsUuids, uuid)
+	}
+	if disk.Meta != nil && disk.Meta.FsUuid != "" {
+		fsUuids = append(fsUuids, disk.Meta.FsUuid)
+	}
+
+	return fsUuids
+}
+
+// verifyFilesystem returns device of the filesystem of disk, or a FsUuidMismatch error when filesystem UUID of device
+// is none of fsUuids of disk. device is listed again for the UUID of a filesystem formatted by this invocation.
+// a filesystem which is not created by vcdfv, e.g. of an imported disk, is trusted on its first mount unless it is
+// the filesystem of another disk
+func (mount *Mount) verifyFilesystem(fsUuids []string, disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice) (*vmdiskop.BlockDevice, error) {
+	device, err := vmdiskop.FindDeviceByDeviceName(blockDevice.Name)
+	if err != nil {
+		return nil, errcode.Annotate(errcode.DeviceFailed, err, "list device for filesystem UUID")
+	}
+
+	for _, fsUuid := range fsUuids {
+		if strings.EqualFold(device.Uuid, fsUuid) {
+			return device, nil
+		}
+	}
+
+	mismatch := errcode.Newf(errcode.FsUuidMismatch, "filesystem UUID %q of device %s is not of disk %s, expect: %s", device.Uuid, device.Name, disk.Name, strings.Join(fsUuids, ","))
+	if device.Uuid == "" || (disk.Meta != nil && disk.Meta.FsUuid != "") {
+		return nil, mismatch
+	}
+
+	// a UUID which is no disk id cannot be of another disk. only a disk which does not exist is not found,
+	// any other error may hide the other disk and fails the mount
+	if vcd.DiskUuid(device.Uuid) != "" {
+		otherDisk, err := mount.vdc.FindDiskById(device.Uuid)
+		if err == nil {
+			return nil, errcode.Wrap(errcode.FsUuidMismatch, mismatch, "it is the filesystem of disk "+otherDisk.Name)
+		} else if !errcode.Is(err, errcode.NotFound) {
+			return nil, errcode.Annotate(errcode.VcdApiFailed, err, "find disk by filesystem UUID")
+		}
+	}
+
+	mount.Logger.Warn("filesystem is not created by vcdfv, its UUID is recorded in disk meta", logging.Fields{"disk": disk.Name, "device": device.Name, "fsUuid": device.Uuid})
+	return device, nil
+}
+
+// mounted reports whether filesystem of device is mounted at mount dir, the device node is bound at it for a block
+// volume. mountinfo is read, lsblk lists only one mount point of a device with several mounts
+func (mount *Mount) mounted(blockDevice *vmdiskop.BlockDevice) bool {
+	mountInfo, err := vmdiskop.FindMountInfo(mount.MountDir)
+	return err == nil && mountOf(mountInfo, blockDevice, mount.Options.BlockMode())
+}
+
+// mountOf reports whether mount is of filesystem of device, or of its device node when blockMode
+func mountOf(mountInfo *vmdiskop.MountInfo, blockDevice *vmdiskop.BlockDevice, blockMode bool) bool {
+	if blockMode {
+		return mountInfo.BoundDeviceName() == blockDevice.Name
+	}
+
+	// a subdirectory of the filesystem bound at mount dir is not the volume
+	return mountInfo.BoundDeviceName() == "" && mountInfo.Root == "/" && mountInfo.MajMin == blockDevice.MajMin
+}
+
+func (mount *Mount) phase(name string) *phase {
+	return startPhase(mount.Logger, mount.Metrics, mount.journal, "mount", name)
+}
@@ -0,0 +1,83 @@
+package operation
+
+import (
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/kube"
+	"github.com/ty2/vcdfv/vcd"
+	"github.com/ty2/vcdfv/vmdiskop"
+	"testing"
+	"time"
+)
+
+// eventSink keeps emitted events
+type eventSink struct {
+	events []*kube.Event
+}
+
+func (sink *eventSink) Emit(event *kube.Event) error {
+	sink.events = append(sink.events, event)
+	return nil
+}
+
+func TestMountEvents(t *testing.T) {
+	sink := &eventSink{}
+	mount := &Mount{
+		Options:     &Options{FsType: "ext4", DiskInitialSize: "10Gi"},
+		VcdfvConfig: &config.Vcdfv{KubeEventSlowAttach: time.Minute},
+		Events:      &kube.Recorder{Sink: sink, Objects: []kube.ObjectReference{{Kind: "PersistentVolume", Name: "pv1"}}},
+	}
+	disk := &vcd.VdcDisk{Name: "pv1", Size: 10737418240}
+	vm := &vcd.VAppVm{Name: "node1"}
+
+	mount.diskCreatedEvent(disk)
+	mount.attachedEvent(disk, vm, 10*time.Second)
+	mount.attachedEvent(disk, vm, 90*time.Second)
+	mount.diskFormattedEvent(disk, &vmdiskop.BlockDevice{Name: "sdb"})
+	mount.fsckRepairedEvent(disk, &vmdiskop.BlockDevice{Name: "sdb"})
+
+	want := []struct {
+		eventType string
+		reason    string
+		message   string
+	}{
+		{kube.EventTypeNormal, kube.ReasonDiskCreated, "disk pv1 of 10Gi is created, 10737418240 bytes are provisioned"},
+		{kube.EventTypeWarning, kube.ReasonSlowAttach, "attach of disk pv1 to VM node1 took 1m30s"},
+		{kube.EventTypeNormal, kube.ReasonDiskFormatted, "device sdb of disk pv1 is formatted ext4"},
+		{kube.EventTypeWarning, kube.ReasonFsckRepaired, "fsck repaired filesystem of device sdb of disk pv1"},
+	}
+
+	if len(sink.events) != len(want) {
+		t.Fatalf("%d events, want %d: %+v", len(sink.events), len(want), sink.events)
+	}
+	for i, event := range sink.events {
+		if event.Type != want[i].eventType || event.Reason != want[i].reason || event.Message != want[i].message {
+			t.Errorf("event %d: %s %s %q, want %+v", i, event.Type, event.Reason, event.Message, want[i])
+		}
+	}
+}
+
+func TestMountOf(t *testing.T) {
+	sdb := &vmdiskop.BlockDevice{Name: "sdb", MajMin: "8:16"}
+	tests := []struct {
+		name      string
+		mountInfo vmdiskop.MountInfo
+		blockMode bool
+		want      bool
+	}{
+		{name: "filesystem", mountInfo: vmdiskop.MountInfo{MajMin: "8:16", Root: "/", FsType: "ext4"}, want: true},
+		{name: "filesystem of another device", mountInfo: vmdiskop.MountInfo{MajMin: "8:32", Root: "/", FsType: "ext4"}},
+		{name: "subdirectory of filesystem", mountInfo: vmdiskop.MountInfo{MajMin: "8:16", Root: "/data", FsType: "ext4"}},
+		{name: "device node of filesystem mode", mountInfo: vmdiskop.MountInfo{MajMin: "0:5", Root: "/sdb", FsType: "devtmpfs"}},
+		{name: "device node", mountInfo: vmdiskop.MountInfo{MajMin: "0:5", Root: "/sdb", FsType: "devtmpfs"}, blockMode: true, want: true},
+		{name: "device node of another device", mountInfo: vmdiskop.MountInfo{MajMin: "0:5", Root: "/sdc", FsType: "devtmpfs"}, blockMode: true},
+		{name: "filesystem of block mode", mountInfo: vmdiskop.MountInfo{MajMin: "8:16", Root: "/", FsType: "ext4"}, blockMode: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mountOf(&tt.mountInfo, sdb, tt.blockMode); got != tt.want {
+				t.Errorf("mountOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
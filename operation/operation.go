@@ -2,6 +2,12 @@
 
 package operation
 
+import (
+	"encoding/json"
+	"github.com/ty2/vcdfv/credential"
+	"strings"
+)
+
 type Operation interface {
 	Exec() (*ExecResult, error)
 }
@@ -15,6 +21,7 @@ const (
 type ExecResult struct {
 	Status       string            `json:"status"`
 	Message      string            `json:"message"`
+	Code         string            `json:"code,omitempty"` // error code of failure, see errcode
 	Capabilities *ExecCapabilities `json:"capabilities,omitempty"`
 }
 
@@ -22,11 +29,72 @@ type ExecCapabilities struct {
 	Attach bool `json:"attach"`
 }
 
+// Volume modes of option volumeMode
+const (
+	VolumeModeFilesystem = "filesystem"
+	VolumeModeBlock      = "block"
+)
+
 type Options struct {
 	FsType         string `json:"kubernetes.io/fsType"`
 	Readwrite      string `json:"kubernetes.io/readwrite"`
 	FsGroup        string `json:"kubernetes.io/fsGroup"`
 	PvOrVolumeName string `json:"kubernetes.io/pvOrVolumeName"`
+	// pod which mounts the volume, recorded as identity of disk
+	PodName            string `json:"kubernetes.io/pod.name"`
+	PodNamespace       string `json:"kubernetes.io/pod.namespace"`
+	PodUid             string `json:"kubernetes.io/pod.uid"`
+	ServiceAccountName string `json:"kubernetes.io/serviceAccount.name"`
 	// additional options
 	DiskInitialSize string `json:"diskInitialSize"`
+	// filesystem (default) or block, a block volume is the raw device bound at mount dir without format
+	VolumeMode string `json:"volumeMode"`
+	// partition to mount by number or by partition or filesystem label, the only partition is mounted by default
+	Partition      string `json:"partition"`
+	PartitionLabel string `json:"partitionLabel"`
+	// "true" creates a single GPT partition on a disk without filesystem and partition table
+	CreatePartition string `json:"createPartition"`
+	// "true" mounts an existing disk read only on many nodes, the disk is never detached from other VMs,
+	// formatted or updated. vCD attaches a disk of sharing type DiskSharing to many VMs
+	SharedReadOnly string `json:"sharedReadOnly"`
+	// kubernetes.io/secret/* options of the volume's secretRef, values are base64 encoded
+	Secrets map[string]string `json:"-"`
+}
+
+func (options *Options) UnmarshalJSON(b []byte) error {
+	// alias has no UnmarshalJSON method, avoid recursion
+	type optionsAlias Options
+	alias := (*optionsAlias)(options)
+	if err := json.Unmarshal(b, alias); err != nil {
+		return err
+	}
+
+	// collect secret options
+	var rawOptions map[string]interface{}
+	if err := json.Unmarshal(b, &rawOptions); err != nil {
+		return err
+	}
+
+	for key, value := range rawOptions {
+		if !strings.HasPrefix(key, credential.SecretOptionPrefix) {
+			continue
+		}
+
+		if str, ok := value.(string); ok {
+			if options.Secrets == nil {
+				options.Secrets = map[string]string{}
+			}
+			options.Secrets[key] = str
+		}
+	}
+
+	return nil
+}
+
+func (options *Options) BlockMode() bool {
+	return options.VolumeMode == VolumeModeBlock
+}
+
+func (options *Options) Shared() bool {
+	return options.SharedReadOnly == "true"
 }
@@ -0,0 +1,73 @@
+package operation
+
+import (
+	"github.com/ty2/vcdfv/journal"
+	"github.com/ty2/vcdfv/logging"
+	"github.com/ty2/vcdfv/metrics"
+)
+
+const (
+	phaseStatusSuccess = "success"
+	phaseStatusFailure = "failure"
+)
+
+// phases checked by resume of journal
+const (
+	phaseFormatDisk   = "formatDisk"
+	phaseMetaDetach   = "metaDetach"
+	phaseMetaReattach = "metaReattach"
+	// resolves a stale device of metaDetach
+	phaseRemoveStaleDevice = "removeStaleDevice"
+)
+
+// phase is a timed step of an operation, its result goes to the operation log, metrics and journal
+type phase struct {
+	step      *logging.Step
+	logger    *logging.Logger
+	recorder  *metrics.Recorder
+	journal   *journal.Journal
+	operation string
+	name      string
+}
+
+func startPhase(logger *logging.Logger, recorder *metrics.Recorder, operationJournal *journal.Journal, operation string, name string) *phase {
+	// journal is best effort, operation is not failed by it
+	if err := operationJournal.Plan(name); err != nil {
+		logger.Warn("journal plan", logging.Fields{"step": name, "error": err.Error()})
+	}
+
+	return &phase{
+		step:      logger.Step(name),
+		logger:    logger,
+		recorder:  recorder,
+		journal:   operationJournal,
+		operation: operation,
+		name:      name,
+	}
+}
+
+func (phase *phase) end(err error) {
+	duration := phase.step.End(err)
+
+	if journalErr := phase.journal.End(phase.name, err); journalErr != nil {
+		phase.logger.Warn("journal end", logging.Fields{"step": phase.name, "error": journalErr.Error()})
+	}
+
+	status := phaseStatusSuccess
+	if err != nil {
+		status = phaseStatusFailure
+	}
+
+	phase.recorder.Observe(metrics.PhaseDurationSeconds, metrics.Labels{
+		"operation": phase.operation,
+		"phase":     phase.name,
+		"status":    status,
+	}, duration)
+}
+
+// warnJournal logs journal error, journal is best effort and never fails the operation
+func warnJournal(logger *logging.Logger, err error) {
+	if err != nil {
+		logger.Warn("journal", logging.Fields{"error": err.Error()})
+	}
+}
@@ -0,0 +1,311 @@
+package operation
+
+import (
+	"fmt"
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/ty2/vcdfv/vcd"
+	"github.com/ty2/vcdfv/vmdiskop"
+	"path/filepath"
+	"strings"
+)
+
+// Plan is what mount or unmount would do, it is the message of a dry run
+type Plan struct {
+	Operation string      `json:"operation"`
+	DryRun    bool        `json:"dryRun"`
+	Volume    string      `json:"volume"`
+	MountDir  string      `json:"mountDir"`
+	Vm        string      `json:"vm"`
+	Disk      *PlanDisk   `json:"disk"`
+	Device    string      `json:"device,omitempty"`
+	Steps     []*PlanStep `json:"steps"`
+}
+
+// PlanDisk is the disk resolved in VDC, Exists is false when mount would create it
+type PlanDisk struct {
+	Name        string   `json:"name"`
+	Id          string   `json:"id,omitempty"`
+	Href        string   `json:"href,omitempty"`
+	Exists      bool     `json:"exists"`
+	Size        int      `json:"size"`
+	AttachedVms []string `json:"attachedVms,omitempty"`
+	Shared      bool     `json:"shared"`
+	Owner       string   `json:"owner,omitempty"`
+}
+
+// PlanStep is a step in order, Condition is set when it depends on what a previous step finds
+type PlanStep struct {
+	Action     string `json:"action"`
+	Disk       string `json:"disk,omitempty"`
+	Vm         string `json:"vm,omitempty"`
+	Device     string `json:"device,omitempty"`
+	FsType     string `json:"fsType,omitempty"`
+	Label      string `json:"label,omitempty"`
+	Uuid       string `json:"uuid,omitempty"`
+	MountPoint string `json:"mountPoint,omitempty"`
+	ReadOnly   bool   `json:"readOnly,omitempty"`
+	Size       int    `json:"size,omitempty"`
+	Condition  string `json:"condition,omitempty"`
+}
+
+func (plan *Plan) add(step *PlanStep) {
+	plan.Steps = append(plan.Steps, step)
+}
+
+func (plan *Plan) result() (*ExecResult, error) {
+	return (&StatusSuccess{JsonMessageStruct: plan}).Exec()
+}
+
+func planDisk(disk *vcd.VdcDisk) *PlanDisk {
+	planDisk := &PlanDisk{
+		Name:   disk.Name,
+		Id:     disk.Id,
+		Href:   disk.Href,
+		Exists: true,
+		Size:   disk.Size,
+		Shared: disk.Shared(),
+		Owner:  DiskOwner(disk),
+	}
+	for _, vm := range disk.AttachedVms {
+		planDisk.AttachedVms = append(planDisk.AttachedVms, vm.Name)
+	}
+
+	return planDisk
+}
+
+// plan resolves VM, disk, attachment and block devices of mount and returns the steps Exec would run.
+// nothing is created, attached or written
+func (mount *Mount) plan() (*ExecResult, error) {
+	var err error
+
+	plan := &Plan{
+		Operation: "mount",
+		DryRun:    true,
+		Volume:    mount.Options.PvOrVolumeName,
+		MountDir:  mount.MountDir,
+	}
+
+	// pending journal is only read, a killed mkfs formats the disk again
+	mount.previous, err = mount.Journals.Load(mount.Options.PvOrVolumeName)
+	warnJournal(mount.Logger, err)
+	mount.resume()
+
+	mount.vdc, err = VdcClientReadOnly(mount.VcdfvConfig, mount.Options)
+	if err != nil {
+		return (&StatusFailure{Error: errcode.Annotate(errcode.VcdApiFailed, err, "vdc client")}).Exec()
+	}
+	mount.vdc = mount.vdc.WithContext(contextOrBackground(mount.Context))
+
+	vm, err := FindVm(mount.vdc, mount.VcdfvConfig.VcdVdcVApp)
+	if err != nil {
+		return (&StatusFailure{Error: errcode.Annotate(errcode.NotFound, err, "find VM")}).Exec()
+	}
+	plan.Vm = vm.Name
+
+	disk, err := FindClusterDisk(mount.vdc, mount.VcdfvConfig, mount.diskName())
+	if err != nil && !errcode.Is(err, errcode.NotFound) {
+		return (&StatusFailure{Error: err}).Exec()
+	}
+
+	// SCSI hosts are not rescanned, a dry run never writes to the host
+	blockDevices, err := vmdiskop.ListBlockDevices()
+	if err != nil {
+		return (&StatusFailure{Error: errcode.Annotate(errcode.DeviceFailed, err, "list block devices")}).Exec()
+	}
+
+	diskName := mount.diskName()
+	var blockDevice *vmdiskop.BlockDevice
+	if disk == nil {
+		if mount.Options.Shared() {
+			return (&StatusFailure{Error: errcode.Newf(errcode.NotFound, "shared disk %s not found", diskName)}).Exec()
+		}
+
+		size, err := DiskSize(mount.VcdfvConfig, mount.Options.DiskInitialSize)
+		if err != nil {
+			return (&StatusFailure{Error: errcode.Annotate(errcode.InvalidArgument, err, "disk size")}).Exec()
+		}
+		plan.Disk = &PlanDisk{Name: diskName, Size: size, Owner: mount.VcdfvConfig.ClusterId}
+		plan.add(&PlanStep{Action: "createDisk", Disk: diskName, Size: size})
+	} else {
+		plan.Disk = planDisk(disk)
+
+		if disk.AttachedTo(vm.Name) {
+			blockDevice = attachedDevice(disk, blockDevices)
+		}
+
+		attachedElsewhere := disk.AttachedVm != nil && !disk.AttachedTo(vm.Name)
+		if mount.Options.Shared() && attachedElsewhere && !disk.Shared() {
+			err := errcode.Newf(errcode.AttachedElsewhere, "disk %s is attached to VM %s and its sharing type is not %s", disk.Name, disk.AttachedVm.Name, vcd.DiskSharingTypeShared)
+			return (&StatusFailure{Error: err}).Exec()
+		}
+
+		if disk.AttachedVm != nil && blockDevice == nil && !(mount.Options.Shared() && attachedElsewhere) {
+			plan.add(&PlanStep{Action: "detachDisk", Disk: disk.Name, Vm: disk.AttachedVm.Name})
+		}
+	}
+
+	// device of a disk which is not attached yet is only known after attach
+	if blockDevice == nil {
+		plan.add(&PlanStep{Action: "attachDisk", Disk: diskName, Vm: vm.Name})
+	} else {
+		plan.Device = blockDevice.Name
+	}
+
+	if mount.Options.Shared() {
+		plan.add(&PlanStep{Action: "addHolder", Disk: diskName, Vm: vm.Name})
+	}
+
+	deviceName := plan.Device
+	if mount.Options.BlockMode() {
+		if blockDevice != nil {
+			targetBlockDevice, err := mount.targetDevice(blockDevice)
+			if err != nil {
+				return (&StatusFailure{Error: errcode.Annotate(errcode.DeviceFailed, err, "find partition")}).Exec()
+			}
+			if mount.mounted(targetBlockDevice) {
+				return plan.result()
+			}
+			deviceName = targetBlockDevice.Name
+		}
+		if !mount.Options.Shared() {
+			plan.add(&PlanStep{Action: "setIdentity", Disk: diskName})
+			plan.add(&PlanStep{Action: "setDiskMeta", Disk: diskName, Vm: vm.Name, Condition: "if disk meta changes, disk is detached and reattached"})
+		}
+		plan.add(&PlanStep{Action: "bindDevice", Device: deviceName, MountPoint: mount.MountDir, ReadOnly: mount.readOnly()})
+		return plan.result()
+	}
+
+	label, uuid := vmdiskop.Ext4Label(diskName), ""
+	if disk != nil {
+		uuid = vcd.DiskUuid(disk.Id)
+	}
+
+	// only a device of an attached disk tells whether it is partitioned, formatted or mounted
+	if blockDevice == nil {
+		if !mount.Options.Shared() {
+			if mount.Options.CreatePartition == "true" {
+				plan.add(&PlanStep{Action: "createPartition", Label: label, Condition: "if disk has no filesystem or partition table"})
+			}
+			plan.add(&PlanStep{Action: "setIdentity", Disk: diskName})
+			plan.add(&PlanStep{Action: "formatDisk", FsType: mount.Options.FsType, Label: label, Uuid: uuid, Condition: "if unformatted"})
+		}
+		plan.add(&PlanStep{Action: "verifyFilesystem", Uuid: uuid})
+		if !mount.Options.Shared() {
+			plan.add(&PlanStep{Action: "setDiskMeta", Disk: diskName, Vm: vm.Name, Condition: "if disk meta changes, disk is detached and reattached"})
+			plan.add(&PlanStep{Action: "fsck", Condition: "if disk is already formatted"})
+		}
+		plan.add(&PlanStep{Action: "mount", FsType: mount.Options.FsType, MountPoint: mount.MountDir, ReadOnly: mount.readOnly()})
+		return plan.result()
+	}
+
+	if !mount.Options.Shared() && mount.Options.CreatePartition == "true" && blockDevice.FsType == "" && len(vmdiskop.Partitions(blockDevice)) == 0 {
+		plan.add(&PlanStep{Action: "createPartition", Device: blockDevice.Name, Label: label})
+		plan.add(&PlanStep{Action: "setIdentity", Disk: diskName})
+		// partition is only listed after it is created
+		plan.add(&PlanStep{Action: "formatDisk", FsType: mount.Options.FsType, Label: label, Uuid: uuid})
+		plan.add(&PlanStep{Action: "verifyFilesystem", Uuid: uuid})
+		plan.add(&PlanStep{Action: "setDiskMeta", Disk: diskName, Vm: vm.Name, Condition: "if disk meta changes, disk is detached and reattached"})
+		plan.add(&PlanStep{Action: "mount", FsType: mount.Options.FsType, MountPoint: mount.MountDir})
+		return plan.result()
+	}
+
+	targetBlockDevice, err := mount.targetDevice(blockDevice)
+	if err != nil {
+		return (&StatusFailure{Error: errcode.Annotate(errcode.DeviceFailed, err, "find partition")}).Exec()
+	}
+
+	// nothing to do, Exec returns success
+	if mount.mounted(targetBlockDevice) {
+		return plan.result()
+	}
+
+	if !mount.Options.Shared() {
+		plan.add(&PlanStep{Action: "setIdentity", Disk: diskName})
+	}
+
+	if mount.Options.Shared() && !vmdiskop.IsFormatted(targetBlockDevice) {
+		return (&StatusFailure{Error: errcode.Newf(errcode.FormatFailed, "shared disk %s is not formatted", diskName)}).Exec()
+	}
+
+	if !mount.Options.Shared() && (mount.forceFormat || !vmdiskop.IsFormatted(targetBlockDevice)) {
+		if err := mount.checkUnformatted(disk, targetBlockDevice); err != nil {
+			return (&StatusFailure{Error: err}).Exec()
+		}
+		plan.add(&PlanStep{Action: "formatDisk", Device: targetBlockDevice.Name, FsType: mount.Options.FsType, Label: label, Uuid: uuid})
+	}
+
+	plan.add(&PlanStep{Action: "verifyFilesystem", Device: targetBlockDevice.Name, Uuid: strings.Join(expectedFsUuids(disk), ",")})
+
+	if !mount.Options.Shared() {
+		plan.add(&PlanStep{Action: "setDiskMeta", Disk: diskName, Vm: vm.Name, Device: blockDevice.Name, Condition: "if disk meta changes, disk is detached and reattached"})
+		if vmdiskop.IsFormatted(targetBlockDevice) && !mount.forceFormat {
+			plan.add(&PlanStep{Action: "fsck", Device: targetBlockDevice.Name})
+		}
+	}
+	plan.add(&PlanStep{Action: "mount", Device: targetBlockDevice.Name, FsType: mount.Options.FsType, MountPoint: mount.MountDir, ReadOnly: mount.readOnly()})
+
+	return plan.result()
+}
+
+// readOnly reports whether volume is mounted read only, a shared disk always is
+func (mount *Mount) readOnly() bool {
+	return mount.Options.Shared() || mount.Options.Readwrite == "ro"
+}
+
+// plan resolves VM, disk and device of unmount and returns the steps Exec would run, pending journal is only read
+func (unmount *Unmount) plan() (*ExecResult, error) {
+	var err error
+
+	plan := &Plan{
+		Operation: "unmount",
+		DryRun:    true,
+		Volume:    filepath.Base(unmount.MountDir),
+		MountDir:  unmount.MountDir,
+	}
+
+	unmount.previous, err = unmount.Journals.Load(plan.Volume)
+	warnJournal(unmount.Logger, err)
+
+	unmount.vdc, err = VdcClientReadOnly(unmount.VcdfvConfig, nil)
+	if err != nil {
+		return (&StatusFailure{Error: errcode.Annotate(errcode.VcdApiFailed, err, "vdc client")}).Exec()
+	}
+	unmount.vdc = unmount.vdc.WithContext(contextOrBackground(unmount.Context))
+
+	vm, err := FindVm(unmount.vdc, unmount.VcdfvConfig.VcdVdcVApp)
+	if err != nil {
+		return (&StatusFailure{Error: errcode.Annotate(errcode.NotFound, err, "find VM")}).Exec()
+	}
+	plan.Vm = vm.Name
+
+	blockDevice, disk, err := unmount.findDiskAndDevice(vm)
+	if err != nil {
+		return (&StatusFailure{Error: err}).Exec()
+	}
+	plan.Disk = planDisk(disk)
+
+	if err := CheckOwnership(unmount.VcdfvConfig, disk); err != nil {
+		return (&StatusFailure{Error: err}).Exec()
+	}
+
+	unmountStep := &PlanStep{Action: "unmount", MountPoint: unmount.MountDir}
+	if holders, err := vmdiskop.Holders(unmount.MountDir); err == nil && len(holders) > 0 {
+		unmountStep.Condition = fmt.Sprintf("mount is used by pids %v, unmountBusyPolicy is %s", pids(holders), UnmountBusyPolicy(unmount.VcdfvConfig))
+	}
+	plan.add(unmountStep)
+
+	if blockDevice != nil {
+		plan.Device = blockDevice.Name
+		plan.add(&PlanStep{Action: "removeScsiDevice", Device: blockDevice.Disk().Name})
+	}
+
+	if disk.AttachedTo(vm.Name) {
+		plan.add(&PlanStep{Action: "detachDisk", Disk: disk.Name, Vm: vm.Name})
+	}
+
+	if unmount.holdsDisk(disk, vm) {
+		plan.add(&PlanStep{Action: "removeHolder", Disk: disk.Name, Vm: vm.Name})
+	}
+
+	return plan.result()
+}
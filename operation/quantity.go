@@ -0,0 +1,150 @@
+package operation
+
+import (
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/errcode"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// vCD allocates disks in MiB
+const diskSizeGranularity = 1024 * 1024
+
+// quantity of Kubernetes resource.Quantity: sign, number, then binary or decimal SI suffix or decimal exponent
+var quantityRegexp = regexp.MustCompile(`^([+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+))(Ki|Mi|Gi|Ti|Pi|Ei|n|u|m|k|M|G|T|P|E|[eE][+-]?[0-9]+)?$`)
+
+// legacy sizes of vcdfv before quantities, e.g. 10g, lowercase m is MiB and not milli
+var legacySizeRegexp = regexp.MustCompile(`^([0-9]+(?:\.[0-9]*)?)([mg])$`)
+
+var quantitySuffixes = map[string]*big.Rat{
+	"Ki": new(big.Rat).SetInt64(1 << 10),
+	"Mi": new(big.Rat).SetInt64(1 << 20),
+	"Gi": new(big.Rat).SetInt64(1 << 30),
+	"Ti": new(big.Rat).SetInt64(1 << 40),
+	"Pi": new(big.Rat).SetInt64(1 << 50),
+	"Ei": new(big.Rat).SetInt64(1 << 60),
+	"n":  big.NewRat(1, 1000000000),
+	"u":  big.NewRat(1, 1000000),
+	"m":  big.NewRat(1, 1000),
+	"":   new(big.Rat).SetInt64(1),
+	"k":  new(big.Rat).SetInt64(1e3),
+	"M":  new(big.Rat).SetInt64(1e6),
+	"G":  new(big.Rat).SetInt64(1e9),
+	"T":  new(big.Rat).SetInt64(1e12),
+	"P":  new(big.Rat).SetInt64(1e15),
+	"E":  new(big.Rat).SetInt64(1e18),
+}
+
+// SizeStringToByteUnit parses a Kubernetes quantity, e.g. 10Gi, 500M or 1e9, to bytes rounded up to a whole byte.
+// legacy sizes with lowercase m or g are MiB and GiB
+func SizeStringToByteUnit(str string) (int, error) {
+	str = strings.TrimSpace(str)
+
+	if match := legacySizeRegexp.FindStringSubmatch(str); match != nil {
+		legacySuffix := map[string]string{"m": "Mi", "g": "Gi"}
+		str = match[1] + legacySuffix[match[2]]
+	}
+
+	match := quantityRegexp.FindStringSubmatch(str)
+	if match == nil {
+		return 0, errcode.Newf(errcode.InvalidArgument, "cannot parse size: %s, expect a quantity, e.g. 10Gi", str)
+	}
+
+	size, ok := new(big.Rat).SetString(match[1])
+	if !ok {
+		return 0, errcode.Newf(errcode.InvalidArgument, "cannot parse size: %s", str)
+	}
+
+	suffix := match[2]
+	if multiplier, ok := quantitySuffixes[suffix]; ok {
+		size.Mul(size, multiplier)
+	} else {
+		exponent, err := strconv.Atoi(suffix[1:])
+		if err != nil || exponent > 18 || exponent < -18 {
+			return 0, errcode.Newf(errcode.InvalidArgument, "exponent of size %s is out of range", str)
+		}
+		power := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exponent))), nil)
+		if exponent >= 0 {
+			size.Mul(size, new(big.Rat).SetInt(power))
+		} else {
+			size.Quo(size, new(big.Rat).SetInt(power))
+		}
+	}
+
+	if size.Sign() < 0 {
+		return 0, errcode.Newf(errcode.InvalidArgument, "size must not be negative, got: %s", str)
+	}
+
+	// round up a fraction of a byte
+	bytes := new(big.Int).Quo(size.Num(), size.Denom())
+	if new(big.Rat).SetInt(bytes).Cmp(size) < 0 {
+		bytes.Add(bytes, big.NewInt(1))
+	}
+	if !bytes.IsInt64() || int64(int(bytes.Int64())) != bytes.Int64() {
+		return 0, errcode.Newf(errcode.InvalidArgument, "size %s is too large", str)
+	}
+
+	return int(bytes.Int64()), nil
+}
+
+// DiskSize returns size of a new disk in bytes for quantity str, rounded up to the allocation granularity of vCD.
+// a size below diskMinSize of config is raised to it, a size above diskMaxSize is refused
+func DiskSize(vcdfvConfig *config.Vcdfv, str string) (int, error) {
+	size, err := SizeStringToByteUnit(str)
+	if err != nil {
+		return 0, err
+	}
+
+	minSize, maxSize, err := diskSizeLimits(vcdfvConfig)
+	if err != nil {
+		return 0, err
+	}
+
+	if size < minSize {
+		size = minSize
+	}
+
+	if remainder := size % diskSizeGranularity; remainder != 0 || size == 0 {
+		size += diskSizeGranularity - remainder
+	}
+
+	if maxSize > 0 && size > maxSize {
+		return 0, errcode.Newf(errcode.InvalidArgument, "disk size %s is larger than diskMaxSize %s", str, vcdfvConfig.DiskMaxSize)
+	}
+
+	return size, nil
+}
+
+// diskSizeLimits returns diskMinSize and diskMaxSize of config in bytes, zero when it is not configured
+func diskSizeLimits(vcdfvConfig *config.Vcdfv) (int, int, error) {
+	var minSize, maxSize int
+	var err error
+	if vcdfvConfig.DiskMinSize != "" {
+		minSize, err = SizeStringToByteUnit(vcdfvConfig.DiskMinSize)
+		if err != nil {
+			return 0, 0, errcode.Wrap(errcode.ConfigInvalid, err, "diskMinSize")
+		}
+	}
+	if vcdfvConfig.DiskMaxSize != "" {
+		maxSize, err = SizeStringToByteUnit(vcdfvConfig.DiskMaxSize)
+		if err != nil {
+			return 0, 0, errcode.Wrap(errcode.ConfigInvalid, err, "diskMaxSize")
+		}
+	}
+
+	if maxSize > 0 && minSize > maxSize {
+		return 0, 0, errcode.Newf(errcode.ConfigInvalid, "diskMinSize %s is larger than diskMaxSize %s", vcdfvConfig.DiskMinSize, vcdfvConfig.DiskMaxSize)
+	}
+
+	return minSize, maxSize, nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
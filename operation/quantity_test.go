@@ -0,0 +1,103 @@
+package operation
+
+import (
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/errcode"
+	"testing"
+)
+
+func TestSizeStringToByteUnit(t *testing.T) {
+	tests := []struct {
+		str     string
+		want    int
+		wantErr bool
+	}{
+		{str: "10Gi", want: 10 << 30},
+		{str: "10G", want: 10e9},
+		{str: "500M", want: 500e6},
+		{str: "1e9", want: 1e9},
+		{str: "1E3", want: 1000},
+		{str: "1.5Ki", want: 1536},
+		{str: ".5Ki", want: 512},
+		{str: "+1Mi", want: 1 << 20},
+		{str: " 1Ti ", want: 1 << 40},
+		{str: "1024", want: 1024},
+		{str: "0", want: 0},
+		// a fraction of a byte is rounded up
+		{str: "0.5", want: 1},
+		{str: "1500u", want: 1},
+		{str: "1e-3", want: 1},
+		// legacy sizes, lowercase m and g are MiB and GiB
+		{str: "10g", want: 10 << 30},
+		{str: "500m", want: 500 << 20},
+		{str: "1.5g", want: 3 << 29},
+		{str: "", wantErr: true},
+		{str: "10Gb", wantErr: true},
+		{str: "10 Gi", wantErr: true},
+		{str: "Gi", wantErr: true},
+		{str: "-1Gi", wantErr: true},
+		{str: "1e19", wantErr: true},
+		{str: "1e-19", wantErr: true},
+		{str: "8Ei", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			got, err := SizeStringToByteUnit(tt.str)
+			if tt.wantErr {
+				if !errcode.Is(err, errcode.InvalidArgument) {
+					t.Errorf("SizeStringToByteUnit() error = %v, want %s", err, errcode.InvalidArgument)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SizeStringToByteUnit() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("SizeStringToByteUnit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiskSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   config.Vcdfv
+		str      string
+		want     int
+		wantCode string
+	}{
+		{name: "whole MiB", str: "10Gi", want: 10 << 30},
+		{name: "rounded up to MiB", str: "10G", want: 9537 << 20},
+		{name: "byte above MiB", str: "1048577", want: 2 << 20},
+		{name: "zero is a MiB", str: "0", want: 1 << 20},
+		{name: "raised to min", config: config.Vcdfv{DiskMinSize: "1Gi"}, str: "100Mi", want: 1 << 30},
+		{name: "min rounded up to MiB", config: config.Vcdfv{DiskMinSize: "1G"}, str: "1Mi", want: 954 << 20},
+		{name: "at max", config: config.Vcdfv{DiskMaxSize: "1Ti"}, str: "1Ti", want: 1 << 40},
+		{name: "above max", config: config.Vcdfv{DiskMaxSize: "1Ti"}, str: "2Ti", wantCode: errcode.InvalidArgument},
+		{name: "above max after rounding", config: config.Vcdfv{DiskMaxSize: "1000000"}, str: "1000000", wantCode: errcode.InvalidArgument},
+		{name: "invalid size", str: "10Gb", wantCode: errcode.InvalidArgument},
+		{name: "invalid min", config: config.Vcdfv{DiskMinSize: "1Gb"}, str: "1Gi", wantCode: errcode.ConfigInvalid},
+		{name: "invalid max", config: config.Vcdfv{DiskMaxSize: "-1Gi"}, str: "1Gi", wantCode: errcode.ConfigInvalid},
+		{name: "min above max", config: config.Vcdfv{DiskMinSize: "2Gi", DiskMaxSize: "1Gi"}, str: "1Gi", wantCode: errcode.ConfigInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiskSize(&tt.config, tt.str)
+			if tt.wantCode != "" {
+				if !errcode.Is(err, tt.wantCode) {
+					t.Errorf("DiskSize() error = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DiskSize() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DiskSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package operation
+
+const (
+	rollbackStatusSuccess = "success"
+	rollbackStatusFailure = "failure"
+)
+
+// RollbackResult is the result of a compensating action, reported with the error of the failed operation
+type RollbackResult struct {
+	Action string `json:"action"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// rollback keeps compensating actions of completed steps, they run in reverse order when a later step fails
+type rollback struct {
+	actions []*rollbackAction
+}
+
+type rollbackAction struct {
+	name string
+	fn   func() error
+}
+
+func (rollback *rollback) add(name string, fn func() error) {
+	rollback.actions = append(rollback.actions, &rollbackAction{name: name, fn: fn})
+}
+
+// replace replaces the latest action named name in place, e.g. when the action needs a value found later.
+// nothing is replaced when there is no such action
+func (rollback *rollback) replace(name string, fn func() error) {
+	for i := len(rollback.actions) - 1; i >= 0; i-- {
+		if rollback.actions[i].name == name {
+			rollback.actions[i] = &rollbackAction{name: name, fn: fn}
+			return
+		}
+	}
+}
+
+// run runs every action even if an earlier one fails, each action is a phase of the operation
+func (rollback *rollback) run(startPhase func(name string) *phase) []*RollbackResult {
+	var results []*RollbackResult
+	for i := len(rollback.actions) - 1; i >= 0; i-- {
+		action := rollback.actions[i]
+
+		step := startPhase("rollback." + action.name)
+		err := action.fn()
+		step.end(err)
+
+		result := &RollbackResult{
+			Action: action.name,
+			Status: rollbackStatusSuccess,
+		}
+		if err != nil {
+			result.Status = rollbackStatusFailure
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	rollback.actions = nil
+
+	return results
+}
+
+func rollbackSucceeded(results []*RollbackResult) bool {
+	for _, result := range results {
+		if result.Status != rollbackStatusSuccess {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,87 @@
+package operation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRollback(t *testing.T) {
+	var ran []string
+	action := func(name string, err error) func() error {
+		return func() error {
+			ran = append(ran, name)
+			return err
+		}
+	}
+
+	tests := []struct {
+		name        string
+		build       func(r *rollback)
+		wantRan     []string
+		wantStatus  []string
+		wantSuccess bool
+	}{
+		{
+			name: "reverse order",
+			build: func(r *rollback) {
+				r.add("deleteDisk", action("deleteDisk", nil))
+				r.add("detachDisk", action("detachDisk", nil))
+			},
+			wantRan:     []string{"detachDisk", "deleteDisk"},
+			wantStatus:  []string{rollbackStatusSuccess, rollbackStatusSuccess},
+			wantSuccess: true,
+		},
+		{
+			name: "failed action does not stop later actions",
+			build: func(r *rollback) {
+				r.add("deleteDisk", action("deleteDisk", nil))
+				r.add("detachDisk", action("detachDisk", errors.New("busy")))
+			},
+			wantRan:    []string{"detachDisk", "deleteDisk"},
+			wantStatus: []string{rollbackStatusFailure, rollbackStatusSuccess},
+		},
+		{
+			name: "replace keeps position",
+			build: func(r *rollback) {
+				r.add("detachDisk", action("detachDisk nil device", nil))
+				r.add("removeHolder", action("removeHolder", nil))
+				r.replace("detachDisk", action("detachDisk sdb", nil))
+			},
+			wantRan:     []string{"removeHolder", "detachDisk sdb"},
+			wantStatus:  []string{rollbackStatusSuccess, rollbackStatusSuccess},
+			wantSuccess: true,
+		},
+		{
+			name: "replace of missing action adds nothing",
+			build: func(r *rollback) {
+				r.replace("detachDisk", action("detachDisk", nil))
+			},
+			wantSuccess: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+			r := &rollback{}
+			tt.build(r)
+
+			results := r.run(func(name string) *phase { return startPhase(nil, nil, nil, "mount", name) })
+			if !reflect.DeepEqual(ran, tt.wantRan) {
+				t.Errorf("ran %v, want %v", ran, tt.wantRan)
+			}
+
+			var status []string
+			for _, result := range results {
+				status = append(status, result.Status)
+			}
+			if !reflect.DeepEqual(status, tt.wantStatus) {
+				t.Errorf("status %v, want %v", status, tt.wantStatus)
+			}
+			if rollbackSucceeded(results) != tt.wantSuccess {
+				t.Errorf("rollbackSucceeded() = %v, want %v", rollbackSucceeded(results), tt.wantSuccess)
+			}
+		})
+	}
+}
@@ -3,11 +3,14 @@ package operation
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/ty2/vcdfv/errcode"
 )
 
 type StatusFailure struct {
 	Error       error
 	OutputError error
+	// results of compensating actions of the failed operation
+	Rollback []*RollbackResult
 }
 
 func (operationStatusFailure *StatusFailure) Exec() (*ExecResult, error) {
@@ -19,9 +22,11 @@ func (operationStatusFailure *StatusFailure) Exec() (*ExecResult, error) {
 		outputMessage = operationStatusFailure.OutputError.Error()
 	} else if operationStatusFailure.Error != nil {
 		b, err := json.Marshal(struct {
-			Error string `json:"error"`
+			Error    string            `json:"error"`
+			Rollback []*RollbackResult `json:"rollback,omitempty"`
 		}{
-			Error: operationStatusFailure.Error.Error(),
+			Error:    operationStatusFailure.Error.Error(),
+			Rollback: operationStatusFailure.Rollback,
 		})
 
 		if err != nil {
@@ -32,10 +37,15 @@ func (operationStatusFailure *StatusFailure) Exec() (*ExecResult, error) {
 		outputMessage = string(b)
 	}
 
-	return &ExecResult{
+	result := &ExecResult{
 		Status:  ExecResultStatusFailure,
 		Message: outputMessage,
-	}, operationStatusFailure.Error
+	}
+	if operationStatusFailure.Error != nil {
+		result.Code = errcode.CodeOf(operationStatusFailure.Error)
+	}
+
+	return result, operationStatusFailure.Error
 }
 
 type StatusSuccess struct {
@@ -1,18 +1,34 @@
 package operation
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/ty2/vcdfv/journal"
+	"github.com/ty2/vcdfv/logging"
+	"github.com/ty2/vcdfv/metrics"
 	"github.com/ty2/vcdfv/vcd"
 	"github.com/ty2/vcdfv/vmdiskop"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
 type Unmount struct {
 	MountDir    string
 	VcdfvConfig *config.Vcdfv
-	vdc         *vcd.Vdc
+	// cancels vCD operations, e.g. when the process is terminated
+	Context context.Context
+	Logger  *logging.Logger
+	Metrics *metrics.Recorder
+	// nil disables journal
+	Journals *journal.Store
+	// resolve and print plan of unmount, neither vCD nor the host is changed
+	DryRun  bool
+	vdc     *vcd.Vdc
+	journal *journal.Journal
+	// pending journal of a killed or failed invocation for this volume
+	previous *journal.Journal
 }
 
 func (unmount *Unmount) Exec() (*ExecResult, error) {
@@ -32,55 +48,107 @@ func (unmount *Unmount) Exec() (*ExecResult, error) {
 	}
 
 	if unmount.MountDir == "" {
-		err := errors.New("mount dir is empty")
+		err := errcode.New(errcode.InvalidArgument, "mount dir is empty")
 		return (&StatusFailure{Error: err}).Exec()
 	}
 
+	if unmount.DryRun {
+		return unmount.plan()
+	}
+
+	// kubelet names the mount dir by pv or volume name, same as the journal of mount
+	volume := filepath.Base(unmount.MountDir)
+	unmount.previous, err = unmount.Journals.Load(volume)
+	warnJournal(unmount.Logger, err)
+	unmount.journal, err = unmount.Journals.Begin(volume, "unmount", unmount.MountDir, unmount.previous)
+	warnJournal(unmount.Logger, err)
+
 	// init vdc
-	unmount.vdc, err = VdcClient(unmount.VcdfvConfig)
+	step := unmount.phase("vdcLogin")
+	unmount.vdc, err = VdcClient(unmount.VcdfvConfig, nil)
+	step.end(err)
 	if err != nil {
-		return (&StatusFailure{Error: errors.New("vdc client: " + err.Error())}).Exec()
+		return unmount.fail(errcode.Annotate(errcode.VcdApiFailed, err, "vdc client"))
 	}
+	unmount.vdc = unmount.vdc.WithContext(contextOrBackground(unmount.Context)).
+		WithAudit(Auditor(unmount.VcdfvConfig, volume, unmount.Logger))
 
 	// find this VM is VDC
+	step = unmount.phase("findVm")
 	vm, err := FindVm(unmount.vdc, unmount.VcdfvConfig.VcdVdcVApp)
+	step.end(err)
 	if err != nil {
-		return (&StatusFailure{Error: errors.New("find VM: " + err.Error())}).Exec()
+		return unmount.fail(errcode.Annotate(errcode.NotFound, err, "find VM"))
 	}
+	warnJournal(unmount.Logger, unmount.journal.SetVm(vm.Name))
 
-	// find block device
-	var diskForUnmount *vcd.VdcDisk
-	blockDeviceForUnmount, err := vmdiskop.FindDeviceByMountPoint(unmount.MountDir)
+	// find block device and disk
+	step = unmount.phase("findDisk")
+	blockDeviceForUnmount, diskForUnmount, err := unmount.findDiskAndDevice(vm)
+	step.end(err)
 	if err != nil {
-		mountPointErr := err
-		// block device not found or error, then use disk meta data and mount dir pv name for find device
-		blockDeviceForUnmount, diskForUnmount, err = unmount.findAttachedDiskAndBlockDeviceByMountDirAndVm(vm)
-		if err != nil {
-			return (&StatusFailure{Error: errors.New(fmt.Sprintf("find attach disk and block device by mount dir and vm: %s,%s", err.Error(), mountPointErr.Error()))}).Exec()
-		}
-	} else {
-		diskForUnmount, err = unmount.findAttachedDiskByBlockDeviceInfo(blockDeviceForUnmount)
-		if err != nil {
-			return (&StatusFailure{Error: errors.New("find attached disk by block device info: " + err.Error())}).Exec()
-		}
+		return unmount.fail(err)
 	}
 
-	// unmount (ignore error because if disk was unmounted, it will return error)
-	vmdiskop.Unmount(unmount.MountDir)
+	warnJournal(unmount.Logger, unmount.journal.SetDisk(diskForUnmount.Id, diskForUnmount.Name, diskForUnmount.Href))
 
-	// remove scsi device
-	err = vmdiskop.RemoveSCSIDevice(blockDeviceForUnmount)
+	// never detach disks of other clusters
+	err = CheckOwnership(unmount.VcdfvConfig, diskForUnmount)
 	if err != nil {
-		return (&StatusFailure{Error: errors.New("remove SCSI device: " + err.Error())}).Exec()
+		return unmount.fail(err)
+	}
+
+	// a call which resumes the journal checks the device is released by a lazy unmount
+	if blockDeviceForUnmount != nil {
+		warnJournal(unmount.Logger, unmount.journal.SetDevice(blockDeviceForUnmount.Name))
 	}
 
-	// detach disk in vdc
-	err = unmount.vdc.DetachDisk(vm, diskForUnmount)
+	// unmount, a busy mount is handled by the busy policy. the disk is never detached while it is used
+	step = unmount.phase("unmount")
+	err = unmount.unmount(blockDeviceForUnmount)
+	step.end(err)
 	if err != nil {
-		return (&StatusFailure{Error: errors.New("detach disk: " + err.Error())}).Exec()
+		return unmount.fail(err)
+	}
+
+	// remove scsi device, it is already removed when resuming
+	if blockDeviceForUnmount != nil {
+		step = unmount.phase("removeScsiDevice")
+		err = vmdiskop.RemoveSCSIDevice(blockDeviceForUnmount)
+		step.end(err)
+		if err != nil {
+			return unmount.fail(errcode.Annotate(errcode.DeviceFailed, err, "remove SCSI device"))
+		}
 	}
 
+	// detach disk in vdc, it is already detached when resuming. a shared disk is only detached from this VM
+	if diskForUnmount.AttachedTo(vm.Name) {
+		step = unmount.phase("detachDisk")
+		err = unmount.vdc.DetachDisk(vm, diskForUnmount)
+		step.end(err)
+		if err != nil {
+			return unmount.fail(errcode.Annotate(errcode.DetachFailed, err, "detach disk"))
+		}
+	}
+
+	// this VM no longer holds disk. disk is detached, a holder left behind is only a warning
+	if unmount.holdsDisk(diskForUnmount, vm) {
+		step = unmount.phase("removeHolder")
+		err = unmount.vdc.RemoveDiskHolder(diskForUnmount, vm.Name)
+		step.end(err)
+		if err != nil {
+			unmount.Logger.Warn("remove holder", logging.Fields{"disk": diskForUnmount.Name, "error": err.Error()})
+		}
+	}
+
+	// nothing to resume
+	warnJournal(unmount.Logger, unmount.journal.Finish())
+
 	// output
+	var vmDeviceName, mountPoint string
+	if blockDeviceForUnmount != nil {
+		vmDeviceName, mountPoint = blockDeviceForUnmount.Name, blockDeviceForUnmount.MountPoint
+	}
 	return (&StatusSuccess{JsonMessageStruct: struct {
 		DiskId       string `json:"diskId"`
 		DiskName     string `json:"diskName"`
@@ -89,52 +157,262 @@ func (unmount *Unmount) Exec() (*ExecResult, error) {
 	}{
 		DiskId:       diskForUnmount.Id,
 		DiskName:     diskForUnmount.Name,
-		VmDeviceName: blockDeviceForUnmount.Name,
-		MountPoint:   blockDeviceForUnmount.MountPoint,
+		VmDeviceName: vmDeviceName,
+		MountPoint:   mountPoint,
 	}}).Exec()
 }
 
-func (unmount *Unmount) findAttachedDiskAndBlockDeviceByMountDirAndVm(vm *vcd.VAppVm) (*vmdiskop.BlockDevice, *vcd.VdcDisk, error) {
-	// split mount dir for getting path segment
-	mountDirArr := strings.Split(unmount.MountDir, "/")
+// diagnostics are the failures of each lookup of unmount resolution, they are reported when no lookup finds the disk
+type diagnostics []string
 
-	// the last segment of mount dir path is pv or volume name and assume pv or volume name is disk name
-	diskName := mountDirArr[len(mountDirArr)-1]
+func (diagnostics *diagnostics) add(lookup string, err error) {
+	*diagnostics = append(*diagnostics, lookup+": "+err.Error())
+}
 
-	// find attached disk
-	foundDisk, err := unmount.vdc.FindDiskByDiskName(diskName)
+func (diagnostics diagnostics) String() string {
+	return strings.Join(diagnostics, "; ")
+}
+
+// findDiskAndDevice finds disk and device of mount dir, by the first lookup which finds them:
+//  1. device mounted or bound at mount dir by mountinfo, its disk by filesystem UUID which is the disk id,
+//     then by filesystem label of disk meta. disk of a bound device node is named by mount dir
+//  2. disk of pending journal when nothing is mounted
+//  3. disk named by the kubelet mount dir, its device by filesystem UUID or device name of disk meta
+//
+// device is nil when it is already removed or disk is not attached to this VM
+func (unmount *Unmount) findDiskAndDevice(vm *vcd.VAppVm) (*vmdiskop.BlockDevice, *vcd.VdcDisk, error) {
+	var diagnostics diagnostics
+
+	blockDevice, blockMode, err := unmount.findMountedDevice()
 	if err != nil {
-		return nil, nil, errors.New("find device by mount point, find disk by disk name error: " + err.Error())
+		diagnostics.add("mountinfo", err)
+	} else {
+		disk, err := unmount.findDiskOfDevice(blockDevice, blockMode, vm, &diagnostics)
+		if err != nil {
+			return nil, nil, err
+		} else if disk != nil {
+			return blockDevice, disk, nil
+		}
 	}
 
-	// disk is not attached to this VM
-	if foundDisk.AttachedVm != nil && foundDisk.AttachedVm.Name != vm.Name {
-		return nil, nil, errors.New(fmt.Sprintf("find device by mount point, disk is not attached to this vm, expect: %s, got: %s: ", foundDisk.AttachedVm.Name, vm.Name))
-	} else {
-		return nil, nil, errors.New("disk is not attached to any VM")
+	// not mounted, resume or roll back the operation of pending journal by the disk it recorded
+	if blockDevice == nil && unmount.previous != nil && unmount.previous.DiskId != "" {
+		blockDevice, disk, err := unmount.findByJournal(vm)
+		if err == nil {
+			return blockDevice, disk, nil
+		} else if !errcode.Is(err, errcode.NotFound) {
+			return nil, nil, errcode.Annotate(errcode.VcdApiFailed, err, "find disk of pending journal")
+		}
+		diagnostics.add("pending journal disk "+unmount.previous.DiskId, err)
 	}
 
-	// get meta data
-	meta, err := unmount.vdc.DiskMeta(foundDisk)
+	disk, err := FindClusterDisk(unmount.vdc, unmount.VcdfvConfig, unmount.diskName())
 	if err != nil {
-		return nil, nil, errors.New("find device by mount point, get disk meta error: " + err.Error())
+		if !errcode.Is(err, errcode.NotFound) {
+			return nil, nil, errcode.Annotate(errcode.VcdApiFailed, err, "find disk by mount dir name")
+		}
+		diagnostics.add("mount dir name "+unmount.diskName(), err)
+		return nil, nil, errcode.Newf(errcode.NotFound, "disk of mount dir %s not found, %s", unmount.MountDir, diagnostics)
+	}
+
+	// a device mounted at mount dir which is not of disk is never removed
+	if blockDevice != nil {
+		if err := unmount.verifyDevice(disk, blockDevice, vm); err != nil {
+			return nil, nil, errcode.Newf(errcode.DeviceFailed, "device %s is mounted at %s, it is not device of disk %s: %s, %s", blockDevice.Name, unmount.MountDir, disk.Name, err.Error(), diagnostics)
+		}
+		return blockDevice, disk, nil
 	}
 
-	// find and set block device
-	blockDevice, err := vmdiskop.FindDeviceByDeviceName(meta.DeviceName)
+	// nothing of disk is left on this VM, e.g. kubelet retries an unmount which succeeded
+	if !disk.AttachedTo(vm.Name) {
+		fields := logging.Fields{"disk": disk.Name, "diagnostics": diagnostics.String()}
+		if disk.AttachedVm != nil {
+			fields["attachedVm"] = disk.AttachedVm.Name
+		}
+		unmount.Logger.Warn("disk of mount dir is not attached to this VM", fields)
+		return nil, disk, nil
+	}
+
+	blockDevices, err := unmount.listBlockDevices()
 	if err != nil {
-		return nil, nil, errors.New(fmt.Sprintf("find device by mount point, find device by device name error: %s", err.Error()))
+		return nil, nil, errcode.Annotate(errcode.DeviceFailed, err, "list block devices")
+	}
+
+	blockDevice = attachedDevice(disk, blockDevices)
+	if blockDevice == nil && disk.Meta != nil && disk.Meta.DeviceName != "" && unmount.blockModeDir() {
+		// filesystem of a block volume belongs to the workload, only device name of disk meta identifies its device
+		blockDevice = vmdiskop.Find(blockDevices, func(device *vmdiskop.BlockDevice) bool {
+			return device.Name == disk.Meta.DeviceName
+		})
+	}
+	if blockDevice == nil {
+		unmount.Logger.Warn("device of disk is not found, it is detached without removing its SCSI device", logging.Fields{"disk": disk.Name, "diagnostics": diagnostics.String()})
+	}
+
+	return blockDevice, disk, nil
+}
+
+// findMountedDevice finds device of filesystem mounted at mount dir, or device node bound at it for a block volume
+func (unmount *Unmount) findMountedDevice() (*vmdiskop.BlockDevice, bool, error) {
+	blockDevice, err := vmdiskop.FindDeviceByMountPoint(unmount.MountDir)
+	if err == nil {
+		return blockDevice, false, nil
+	}
+
+	boundDevice, boundErr := vmdiskop.FindDeviceByDeviceNode(unmount.MountDir)
+	if boundErr == nil {
+		return boundDevice, true, nil
 	}
 
-	return blockDevice, foundDisk, nil
+	return nil, false, errcode.Newf(errcode.DeviceNotFound, "%s, no device node is bound: %s", err.Error(), boundErr.Error())
 }
 
-func (unmount *Unmount) findAttachedDiskByBlockDeviceInfo(blockDeviceInfo *vmdiskop.BlockDevice) (*vcd.VdcDisk, error) {
-	// find exists disk
-	foundDisk, err := unmount.vdc.FindDiskByDiskName(blockDeviceInfo.Label)
+// findDiskOfDevice finds disk of a device mounted at mount dir, disk is nil when every lookup fails with diagnostics.
+// filesystem of a block volume belongs to the workload, it does not identify the disk
+func (unmount *Unmount) findDiskOfDevice(blockDevice *vmdiskop.BlockDevice, blockMode bool, vm *vcd.VAppVm, diagnostics *diagnostics) (*vcd.VdcDisk, error) {
+	if blockMode {
+		disk, err := FindClusterDisk(unmount.vdc, unmount.VcdfvConfig, unmount.diskName())
+		if err != nil {
+			if !errcode.Is(err, errcode.NotFound) {
+				return nil, errcode.Annotate(errcode.VcdApiFailed, err, "find disk of bound device by mount dir name")
+			}
+			diagnostics.add("mount dir name "+unmount.diskName(), err)
+			return nil, nil
+		}
+
+		if err := unmount.verifyDevice(disk, blockDevice, vm); err != nil {
+			diagnostics.add("bound device "+blockDevice.Name, err)
+			return nil, nil
+		}
+
+		return disk, nil
+	}
+
+	lookups := []struct {
+		name string
+		find func() (*vcd.VdcDisk, error)
+	}{
+		{"filesystem UUID " + blockDevice.Uuid, func() (*vcd.VdcDisk, error) {
+			if vcd.DiskUuid(blockDevice.Uuid) == "" {
+				return nil, errcode.New(errcode.NotFound, "filesystem UUID is not a disk id")
+			}
+			return unmount.vdc.FindDiskById(blockDevice.Uuid)
+		}},
+		{"filesystem label " + blockDevice.Label + " of disk meta", func() (*vcd.VdcDisk, error) {
+			if blockDevice.Label == "" {
+				return nil, errcode.New(errcode.NotFound, "filesystem has no label")
+			}
+			return unmount.vdc.FindDiskByFsLabel(blockDevice.Label)
+		}},
+	}
+
+	for _, lookup := range lookups {
+		disk, err := lookup.find()
+		if err != nil {
+			if !errcode.Is(err, errcode.NotFound) && !errcode.Is(err, errcode.Duplicate) {
+				return nil, errcode.Annotate(errcode.VcdApiFailed, err, "find disk by "+lookup.name)
+			}
+			diagnostics.add(lookup.name, err)
+			continue
+		}
+
+		if err := unmount.verifyDevice(disk, blockDevice, vm); err != nil {
+			diagnostics.add(lookup.name, err)
+			continue
+		}
+
+		return disk, nil
+	}
+
+	return nil, nil
+}
+
+// verifyDevice checks disk is attached to this VM and device mounted at mount dir is not another device of disk meta
+func (unmount *Unmount) verifyDevice(disk *vcd.VdcDisk, blockDevice *vmdiskop.BlockDevice, vm *vcd.VAppVm) error {
+	if !disk.AttachedTo(vm.Name) {
+		return errcode.Newf(errcode.AttachedElsewhere, "disk %s is not attached to this VM", disk.Name)
+	}
+
+	if disk.Meta != nil && disk.Meta.DeviceName != "" && disk.Meta.DeviceName != blockDevice.Disk().Name {
+		return errcode.Newf(errcode.DeviceFailed, "disk %s is device %s of disk meta, mounted device is %s", disk.Name, disk.Meta.DeviceName, blockDevice.Name)
+	}
+
+	return nil
+}
+
+// listBlockDevices lists block devices, a dry run never rescans SCSI hosts
+func (unmount *Unmount) listBlockDevices() ([]*vmdiskop.BlockDevice, error) {
+	if unmount.DryRun {
+		return vmdiskop.ListBlockDevices()
+	}
+
+	return vmdiskop.BlockDevices()
+}
+
+// blockModeDir reports whether mount dir is a file, device node of a block volume is bound at a file which is left
+// after the device node is unbound
+func (unmount *Unmount) blockModeDir() bool {
+	fileInfo, err := os.Stat(unmount.MountDir)
+	return err == nil && !fileInfo.IsDir()
+}
+
+// diskName returns disk name of the pv or volume name which kubelet names the mount dir by
+func (unmount *Unmount) diskName() string {
+	return DiskName(unmount.VcdfvConfig, filepath.Base(unmount.MountDir))
+}
+
+// findByJournal finds disk recorded by pending journal, its device is nil when it is removed or the device name is
+// taken by another disk. A pending mount is rolled back by detaching its disk, a pending unmount is resumed.
+// disk which is not attached to this VM has nothing to clean up but its holder
+func (unmount *Unmount) findByJournal(vm *vcd.VAppVm) (*vmdiskop.BlockDevice, *vcd.VdcDisk, error) {
+	previous := unmount.previous
+	unmount.Logger.Warn("resume pending journal", logging.Fields{"previousOperation": previous.Operation, "diskId": previous.DiskId})
+
+	disk, err := unmount.vdc.FindDiskById(previous.DiskId)
 	if err != nil {
-		return nil, errors.New("find disk by disk name: " + err.Error())
+		return nil, nil, err
 	}
 
-	return foundDisk, nil
+	if !disk.AttachedTo(vm.Name) || previous.DeviceName == "" {
+		return nil, disk, nil
+	}
+
+	blockDevice, err := vmdiskop.FindDeviceByDeviceName(previous.DeviceName)
+	if err != nil || !strings.EqualFold(blockDevice.Uuid, vcd.DiskUuid(disk.Id)) {
+		return nil, disk, nil
+	}
+
+	return blockDevice, disk, nil
+}
+
+// holdsDisk reports whether vm may hold disk, a shared disk always has holders. a disk which is not shared is
+// only held when it was mounted with shared option, its holders are read
+func (unmount *Unmount) holdsDisk(disk *vcd.VdcDisk, vm *vcd.VAppVm) bool {
+	if disk.Shared() {
+		return true
+	}
+
+	holders, err := unmount.vdc.DiskHolders(disk)
+	if err != nil {
+		unmount.Logger.Warn("disk holders", logging.Fields{"disk": disk.Name, "error": err.Error()})
+		return false
+	}
+
+	return contains(holders, vm.Name)
+}
+
+// fail reports err, the journal is kept as failed when it has a disk so the next call resumes by it,
+// e.g. a busy mount or a failed detach
+func (unmount *Unmount) fail(err error) (*ExecResult, error) {
+	if unmount.journal != nil && unmount.journal.DiskId != "" {
+		warnJournal(unmount.Logger, unmount.journal.Fail(err))
+	} else {
+		warnJournal(unmount.Logger, unmount.journal.Finish())
+	}
+
+	return (&StatusFailure{Error: err}).Exec()
+}
+
+func (unmount *Unmount) phase(name string) *phase {
+	return startPhase(unmount.Logger, unmount.Metrics, unmount.journal, "unmount", name)
 }
@@ -1,47 +1,192 @@
 package operation
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"github.com/ty2/vcdfv/audit"
 	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/credential"
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/ty2/vcdfv/journal"
+	"github.com/ty2/vcdfv/kube"
+	"github.com/ty2/vcdfv/logging"
 	"github.com/ty2/vcdfv/vcd"
 	"os"
-	"strconv"
+	"path"
+	"time"
 )
 
-func VdcClient(vcdfvConfig *config.Vcdfv) (*vcd.Vdc, error) {
+// requests to API server must not delay kubelet much
+const kubeEventTimeout = 5 * time.Second
+
+const defaultKubeEventSlowAttach = time.Minute
+
+// e2fsck -p of a large filesystem with many inodes takes minutes
+const fsckTimeout = 10 * time.Minute
+
+// Policies of mount of an unformatted disk
+const (
+	UnformattedDiskPolicyFormat    = "format"
+	UnformattedDiskPolicyFormatNew = "formatNew"
+	UnformattedDiskPolicyRefuse    = "refuse"
+)
+
+// Policies of unmount of a busy mount
+const (
+	UnmountBusyPolicyFail = "fail"
+	UnmountBusyPolicyLazy = "lazy"
+	UnmountBusyPolicyKill = "kill"
+)
+
+const (
+	defaultUnmountLazyDeadline    = 30 * time.Second
+	defaultUnmountKillGracePeriod = 10 * time.Second
+)
+
+const noNodeCredentialMessage = "unmount and getvolumestats have no FlexVolume secret, configure credential source env, file or config"
+
+// VdcClient logins VDC with the first usable credential of the configured credential sources.
+// options may be nil, e.g. unmount has no options so secret source is skipped
+func VdcClient(vcdfvConfig *config.Vcdfv, options *Options) (*vcd.Vdc, error) {
+	return vdcClient(vcdfvConfig, options, false)
+}
+
+// VdcClientReadOnly is VdcClient which reuses a cached session but never writes the session cache, e.g. for a dry run
+func VdcClientReadOnly(vcdfvConfig *config.Vcdfv, options *Options) (*vcd.Vdc, error) {
+	return vdcClient(vcdfvConfig, options, true)
+}
+
+func vdcClient(vcdfvConfig *config.Vcdfv, options *Options, readOnly bool) (*vcd.Vdc, error) {
+	var sessionCache *vcd.SessionCache
+	if vcdfvConfig.VcdSessionCacheDir != "" {
+		sessionCache = &vcd.SessionCache{
+			Dir:      vcdfvConfig.VcdSessionCacheDir,
+			Ttl:      vcdfvConfig.VcdSessionTtl,
+			ReadOnly: readOnly,
+		}
+	}
+
+	retryPolicy := RetryPolicy(vcdfvConfig)
+
+	vcdCredential, err := Credential(vcdfvConfig, options)
+	if err != nil {
+		if options == nil {
+			err = errcode.Wrap(errcode.AuthFailed, err, noNodeCredentialMessage)
+		}
+		if sessionCache == nil {
+			return nil, err
+		}
+
+		// no credential (e.g. secret only available to mount), a cached session of config user may still be reused
+		vdc, sessionErr := vcd.NewVdc(&vcd.VcdConfig{
+			ApiEndpoint:  vcdfvConfig.VcdApiEndpoint,
+			Insecure:     vcdfvConfig.VcdInsecure,
+			User:         vcdfvConfig.VcdUser,
+			Org:          vcdfvConfig.VcdOrg,
+			Vdc:          vcdfvConfig.VcdVdc,
+			SessionCache: sessionCache,
+			RetryPolicy:  retryPolicy,
+		})
+		if sessionErr != nil {
+			return nil, err
+		}
+
+		return vdc, nil
+	}
+
 	return vcd.NewVdc(&vcd.VcdConfig{
-		ApiEndpoint: vcdfvConfig.VcdApiEndpoint,
-		Insecure:    vcdfvConfig.VcdInsecure,
-		User:        vcdfvConfig.VcdUser,
-		Password:    vcdfvConfig.VcdPassword,
-		Org:         vcdfvConfig.VcdOrg,
-		Vdc:         vcdfvConfig.VcdVdc,
+		ApiEndpoint:  vcdfvConfig.VcdApiEndpoint,
+		Insecure:     vcdfvConfig.VcdInsecure,
+		User:         vcdCredential.User,
+		Password:     vcdCredential.Password,
+		ApiToken:     vcdCredential.ApiToken,
+		RefreshToken: vcdCredential.RefreshToken,
+		Org:          vcdfvConfig.VcdOrg,
+		Vdc:          vcdfvConfig.VcdVdc,
+		SessionCache: sessionCache,
+		RetryPolicy:  retryPolicy,
 	})
 }
 
-func SizeStringToByteUnit(str string) (int, error) {
-	// no unit in the string so it is byte then return byte size
-	if size, err := strconv.ParseInt(str, 10, 0); err == nil {
-		return int(size), nil
+// RetryPolicy overrides default retry policy by config
+func RetryPolicy(vcdfvConfig *config.Vcdfv) *vcd.RetryPolicy {
+	policy := vcd.DefaultRetryPolicy()
+
+	if vcdfvConfig.VcdRetryMaxAttempts > 0 {
+		policy.MaxAttempts = vcdfvConfig.VcdRetryMaxAttempts
+	}
+	if vcdfvConfig.VcdRetryInitialBackoff > 0 {
+		policy.InitialBackoff = vcdfvConfig.VcdRetryInitialBackoff
+	}
+	if vcdfvConfig.VcdRetryMaxBackoff > 0 {
+		policy.MaxBackoff = vcdfvConfig.VcdRetryMaxBackoff
 	}
+	if vcdfvConfig.VcdTaskPollInterval > 0 {
+		policy.TaskPollInterval = vcdfvConfig.VcdTaskPollInterval
+	}
+	if vcdfvConfig.VcdTaskTimeout > 0 {
+		policy.DefaultDeadline = vcdfvConfig.VcdTaskTimeout
+	}
+	policy.Deadlines = vcdfvConfig.VcdTaskTimeouts
 
-	sizeString := str[:len(str)-1]
-	unit := str[len(str)-1:]
+	return policy
+}
 
-	size, err := strconv.ParseFloat(sizeString, 64)
-	if err != nil {
-		return 0, errors.New(fmt.Sprintf("cannot parse size: %s", str))
+// JournalStore returns nil when journalDir is not configured
+func JournalStore(vcdfvConfig *config.Vcdfv) *journal.Store {
+	if vcdfvConfig.JournalDir == "" {
+		return nil
+	}
+
+	return &journal.Store{Dir: vcdfvConfig.JournalDir}
+}
+
+// ignoreNotFound returns nil for not found error, e.g. disk is not created yet
+func ignoreNotFound(err error) error {
+	if errcode.Is(err, errcode.NotFound) {
+		return nil
 	}
 
-	switch unit {
-	case "m": // MB
-		size = size * 1024 * 1024
-	case "g": // GB
-		size = size * 1024 * 1024 * 1024
+	return err
+}
+
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+
+	return ctx
+}
+
+func Credential(vcdfvConfig *config.Vcdfv, options *Options) (*credential.Credential, error) {
+	secretProvider := &credential.SecretProvider{
+		DefaultUser: vcdfvConfig.VcdUser,
+	}
+	if options != nil {
+		secretProvider.Secrets = options.Secrets
+	}
+
+	chain, err := credential.NewChain(
+		vcdfvConfig.VcdCredentialSources,
+		secretProvider,
+		&credential.EnvProvider{
+			DefaultUser: vcdfvConfig.VcdUser,
+		},
+		&credential.FileProvider{
+			User:             vcdfvConfig.VcdUser,
+			PasswordFile:     vcdfvConfig.VcdPasswordFile,
+			ApiTokenFile:     vcdfvConfig.VcdApiTokenFile,
+			RefreshTokenFile: vcdfvConfig.VcdRefreshTokenFile,
+		},
+		&credential.ConfigProvider{
+			User:     vcdfvConfig.VcdUser,
+			Password: vcdfvConfig.VcdPassword,
+		},
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	return int(size), nil
+	return chain.Retrieve()
 }
 
 func FindVm(vdc *vcd.Vdc, vAppName string) (*vcd.VAppVm, error) {
@@ -59,3 +204,134 @@ func FindVm(vdc *vcd.Vdc, vAppName string) (*vcd.VAppVm, error) {
 
 	return vm, nil
 }
+
+// EventRecorder returns recorder of events on pod and PV of options, nil when kubeconfig is not configured
+// or cannot be loaded
+func EventRecorder(vcdfvConfig *config.Vcdfv, options *Options, logger *logging.Logger) *kube.Recorder {
+	if vcdfvConfig.Kubeconfig == "" || options == nil {
+		return nil
+	}
+
+	client, err := kube.NewClient(vcdfvConfig.Kubeconfig, kubeEventTimeout)
+	if err != nil {
+		logger.Warn("kube client", logging.Fields{"error": err.Error()})
+		return nil
+	}
+
+	var objects []kube.ObjectReference
+	if options.PodName != "" {
+		objects = append(objects, kube.ObjectReference{
+			ApiVersion: "v1",
+			Kind:       "Pod",
+			Namespace:  options.PodNamespace,
+			Name:       options.PodName,
+			Uid:        options.PodUid,
+		})
+	}
+	if options.PvOrVolumeName != "" {
+		objects = append(objects, kube.ObjectReference{
+			ApiVersion: "v1",
+			Kind:       "PersistentVolume",
+			Name:       options.PvOrVolumeName,
+		})
+	}
+
+	host, _ := os.Hostname()
+
+	return &kube.Recorder{
+		Sink:      client,
+		Component: "vcdfv",
+		Host:      host,
+		Objects:   objects,
+		OnError: func(err error) {
+			logger.Warn("kube event", logging.Fields{"error": err.Error()})
+		},
+	}
+}
+
+// SlowAttach returns duration of attach which is reported as slow
+func SlowAttach(vcdfvConfig *config.Vcdfv) time.Duration {
+	if vcdfvConfig.KubeEventSlowAttach > 0 {
+		return vcdfvConfig.KubeEventSlowAttach
+	}
+
+	return defaultKubeEventSlowAttach
+}
+
+// UnformattedDiskPolicy returns policy of an unformatted disk, UnformattedDiskPolicyFormat when it is not configured
+func UnformattedDiskPolicy(vcdfvConfig *config.Vcdfv) string {
+	if vcdfvConfig.UnformattedDiskPolicy == "" {
+		return UnformattedDiskPolicyFormat
+	}
+
+	return vcdfvConfig.UnformattedDiskPolicy
+}
+
+// UnmountBusyPolicy returns policy of unmount of a busy mount, UnmountBusyPolicyFail when it is not configured
+func UnmountBusyPolicy(vcdfvConfig *config.Vcdfv) string {
+	if vcdfvConfig.UnmountBusyPolicy == "" {
+		return UnmountBusyPolicyFail
+	}
+
+	return vcdfvConfig.UnmountBusyPolicy
+}
+
+func UnmountLazyDeadline(vcdfvConfig *config.Vcdfv) time.Duration {
+	if vcdfvConfig.UnmountLazyDeadline > 0 {
+		return vcdfvConfig.UnmountLazyDeadline
+	}
+
+	return defaultUnmountLazyDeadline
+}
+
+func UnmountKillGracePeriod(vcdfvConfig *config.Vcdfv) time.Duration {
+	if vcdfvConfig.UnmountKillGracePeriod > 0 {
+		return vcdfvConfig.UnmountKillGracePeriod
+	}
+
+	return defaultUnmountKillGracePeriod
+}
+
+// AuditLog returns audit log of config, nil when it is disabled
+func AuditLog(vcdfvConfig *config.Vcdfv) *audit.Log {
+	if vcdfvConfig.AuditLogFile == "" {
+		return nil
+	}
+
+	return &audit.Log{Path: vcdfvConfig.AuditLogFile}
+}
+
+// Auditor returns audit func which appends mutations of volume to audit log, nil when it is disabled.
+// vCD is already changed when the entry is written, a failed append is logged and does not fail the operation
+func Auditor(vcdfvConfig *config.Vcdfv, volume string, logger *logging.Logger) vcd.AuditFunc {
+	auditLog := AuditLog(vcdfvConfig)
+	if auditLog == nil {
+		return nil
+	}
+
+	node, _ := os.Hostname()
+
+	return func(mutation *vcd.Mutation) {
+		entry := &audit.Entry{
+			Time:       mutation.StartedAt,
+			Node:       node,
+			Volume:     volume,
+			Operation:  mutation.Operation,
+			DiskName:   mutation.DiskName,
+			DiskHref:   mutation.DiskHref,
+			VmName:     mutation.VmName,
+			Result:     audit.ResultSuccess,
+			DurationMs: mutation.Duration.Nanoseconds() / int64(time.Millisecond),
+		}
+		for _, taskHref := range mutation.TaskHrefs {
+			entry.TaskIds = append(entry.TaskIds, path.Base(taskHref))
+		}
+		if mutation.Err != nil {
+			entry.Result, entry.Error = audit.ResultFailure, mutation.Err.Error()
+		}
+
+		if err := auditLog.Append(entry); err != nil {
+			logger.Error("audit", err, logging.Fields{"operation": mutation.Operation, "diskHref": mutation.DiskHref})
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package operation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/credential"
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/ty2/vcdfv/kube"
+	"github.com/ty2/vcdfv/logging"
+	"github.com/ty2/vcdfv/vcd"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Status of a config check
+const (
+	ConfigCheckOk      = "ok"
+	ConfigCheckWarning = "warning"
+	ConfigCheckError   = "error"
+	ConfigCheckSkipped = "skipped"
+)
+
+type ConfigCheck struct {
+	Field   string `json:"field"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type ConfigReport struct {
+	Path   string         `json:"path"`
+	Valid  bool           `json:"valid"`
+	Checks []*ConfigCheck `json:"checks"`
+}
+
+// ValidateConfig checks config fields, with Online it also logins VCD and resolves org, VDC and vApp.
+// Message of the result is the JSON of ConfigReport
+type ValidateConfig struct {
+	Path string
+	// nil when config cannot be loaded
+	VcdfvConfig *config.Vcdfv
+	LoadError   error
+	Online      bool
+}
+
+func (validateConfig *ValidateConfig) Exec() (*ExecResult, error) {
+	report := &ConfigReport{
+		Path: validateConfig.Path,
+	}
+
+	if validateConfig.LoadError != nil {
+		report.add("", ConfigCheckError, validateConfig.LoadError.Error())
+	} else {
+		if err := config.UnknownFields(validateConfig.Path); err != nil {
+			report.add("", ConfigCheckWarning, err.Error())
+		}
+		validateConfig.checkFields(report)
+		if validateConfig.Online {
+			validateConfig.checkOnline(report)
+		}
+	}
+
+	report.Valid = true
+	invalid := 0
+	for _, check := range report.Checks {
+		if check.Status == ConfigCheckError {
+			report.Valid = false
+			invalid++
+		}
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return (&StatusFailure{Error: err}).Exec()
+	}
+
+	if !report.Valid {
+		return &ExecResult{
+			Status:  ExecResultStatusFailure,
+			Code:    errcode.ConfigInvalid,
+			Message: string(b),
+		}, errcode.Newf(errcode.ConfigInvalid, "%d config checks failed", invalid)
+	}
+
+	return &ExecResult{
+		Status:  ExecResultStatusSuccess,
+		Message: string(b),
+	}, nil
+}
+
+func (validateConfig *ValidateConfig) checkFields(report *ConfigReport) {
+	vcdfvConfig := validateConfig.VcdfvConfig
+
+	report.check("vcdApiEndpoint", checkApiEndpoint(vcdfvConfig.VcdApiEndpoint))
+	if vcdfvConfig.VcdInsecure {
+		report.add("vcdInsecure", ConfigCheckWarning, "TLS certificate of VCD is not verified")
+	}
+	report.check("vcdOrg", checkRequired(vcdfvConfig.VcdOrg))
+	report.check("vcdVdc", checkRequired(vcdfvConfig.VcdVdc))
+	report.check("vcdVdcVApp", checkRequired(vcdfvConfig.VcdVdcVApp))
+
+	// credential
+	report.check("vcdPasswordFile", checkReadableFile(vcdfvConfig.VcdPasswordFile))
+	report.check("vcdApiTokenFile", checkReadableFile(vcdfvConfig.VcdApiTokenFile))
+	report.check("vcdRefreshTokenFile", checkReadableFile(vcdfvConfig.VcdRefreshTokenFile))
+	report.check("vcdCredentialSources", checkCredentialSources(vcdfvConfig.VcdCredentialSources))
+	if vcdCredential, err := Credential(vcdfvConfig, nil); err != nil {
+		// secret is only passed by kubelet to mount, a cached session may be expired when the volume is unmounted
+		report.add("credential", ConfigCheckError, err.Error()+", "+noNodeCredentialMessage)
+	} else {
+		report.add("credential", ConfigCheckOk, "source: "+vcdCredential.Source)
+	}
+
+	// session, retry and timeouts
+	report.check("vcdSessionTtl", checkNonNegative(vcdfvConfig.VcdSessionTtl))
+	if vcdfvConfig.VcdRetryMaxAttempts < 0 {
+		report.add("vcdRetryMaxAttempts", ConfigCheckError, "must not be negative")
+	}
+	report.check("vcdRetryInitialBackoff", checkNonNegative(vcdfvConfig.VcdRetryInitialBackoff))
+	report.check("vcdRetryMaxBackoff", checkNonNegative(vcdfvConfig.VcdRetryMaxBackoff))
+	report.check("vcdTaskPollInterval", checkNonNegative(vcdfvConfig.VcdTaskPollInterval))
+	report.check("vcdTaskTimeout", checkNonNegative(vcdfvConfig.VcdTaskTimeout))
+	knownOps := []string{vcd.OpCreateDisk, vcd.OpUpdateDisk, vcd.OpAttachDisk, vcd.OpDetachDisk, vcd.OpDeleteDisk, vcd.OpRead}
+	for op, timeout := range vcdfvConfig.VcdTaskTimeouts {
+		field := "vcdTaskTimeouts." + op
+		if !contains(knownOps, op) {
+			report.add(field, ConfigCheckError, fmt.Sprintf("unknown operation, expect: %s", strings.Join(knownOps, ",")))
+			continue
+		}
+		report.check(field, checkNonNegative(timeout))
+	}
+
+	// disk size
+	if _, _, err := diskSizeLimits(vcdfvConfig); err != nil {
+		report.add("diskMinSize,diskMaxSize", ConfigCheckError, err.Error())
+	}
+
+	unformattedPolicies := []string{UnformattedDiskPolicyFormat, UnformattedDiskPolicyFormatNew, UnformattedDiskPolicyRefuse}
+	if vcdfvConfig.UnformattedDiskPolicy != "" && !contains(unformattedPolicies, vcdfvConfig.UnformattedDiskPolicy) {
+		report.add("unformattedDiskPolicy", ConfigCheckError, fmt.Sprintf("unknown policy, expect: %s", strings.Join(unformattedPolicies, ",")))
+	}
+
+	// logging
+	if _, err := logging.ParseLevel(vcdfvConfig.LogLevel); err != nil {
+		report.add("logLevel", ConfigCheckError, err.Error())
+	}
+	if vcdfvConfig.LogMaxSizeMB < 0 {
+		report.add("logMaxSizeMB", ConfigCheckError, "must not be negative")
+	}
+	if vcdfvConfig.LogMaxBackups < 0 {
+		report.add("logMaxBackups", ConfigCheckError, "must not be negative")
+	}
+
+	// events
+	if vcdfvConfig.Kubeconfig != "" {
+		_, err := kube.LoadConfig(vcdfvConfig.Kubeconfig)
+		report.check("kubeconfig", err)
+	}
+	report.check("kubeEventSlowAttach", checkNonNegative(vcdfvConfig.KubeEventSlowAttach))
+
+	// unmount
+	busyPolicies := []string{UnmountBusyPolicyFail, UnmountBusyPolicyLazy, UnmountBusyPolicyKill}
+	if vcdfvConfig.UnmountBusyPolicy != "" && !contains(busyPolicies, vcdfvConfig.UnmountBusyPolicy) {
+		report.add("unmountBusyPolicy", ConfigCheckError, fmt.Sprintf("unknown policy, expect: %s", strings.Join(busyPolicies, ",")))
+	} else if vcdfvConfig.UnmountBusyPolicy == UnmountBusyPolicyKill {
+		report.add("unmountBusyPolicy", ConfigCheckWarning, "processes which use a volume are killed on unmount")
+	}
+	report.check("unmountLazyDeadline", checkNonNegative(vcdfvConfig.UnmountLazyDeadline))
+	report.check("unmountKillGracePeriod", checkNonNegative(vcdfvConfig.UnmountKillGracePeriod))
+}
+
+func (validateConfig *ValidateConfig) checkOnline(report *ConfigReport) {
+	vcdfvConfig := validateConfig.VcdfvConfig
+
+	fields := map[string]string{
+		vcd.ProbeLogin: "credential",
+		vcd.ProbeOrg:   "vcdOrg",
+		vcd.ProbeVdc:   "vcdVdc",
+		vcd.ProbeVApp:  "vcdVdcVApp",
+	}
+
+	vcdCredential, err := Credential(vcdfvConfig, nil)
+	if err != nil {
+		for _, step := range []string{vcd.ProbeLogin, vcd.ProbeOrg, vcd.ProbeVdc, vcd.ProbeVApp} {
+			report.add(fields[step], ConfigCheckSkipped, step+": no credential outside of a FlexVolume secret")
+		}
+		return
+	}
+
+	results := vcd.Probe(&vcd.VcdConfig{
+		ApiEndpoint:  vcdfvConfig.VcdApiEndpoint,
+		Insecure:     vcdfvConfig.VcdInsecure,
+		User:         vcdCredential.User,
+		Password:     vcdCredential.Password,
+		ApiToken:     vcdCredential.ApiToken,
+		RefreshToken: vcdCredential.RefreshToken,
+		Org:          vcdfvConfig.VcdOrg,
+		Vdc:          vcdfvConfig.VcdVdc,
+	}, vcdfvConfig.VcdVdcVApp)
+
+	for _, result := range results {
+		field := fields[result.Step]
+		switch {
+		case !result.Run:
+			report.add(field, ConfigCheckSkipped, result.Step+": previous check failed")
+		case result.Err != nil:
+			report.add(field, ConfigCheckError, result.Step+": "+result.Err.Error())
+		default:
+			report.add(field, ConfigCheckOk, result.Step+": resolved")
+		}
+	}
+}
+
+func (report *ConfigReport) add(field string, status string, message string) {
+	report.Checks = append(report.Checks, &ConfigCheck{
+		Field:   field,
+		Status:  status,
+		Message: message,
+	})
+}
+
+// check adds error check when err is not nil, ok check otherwise
+func (report *ConfigReport) check(field string, err error) {
+	if err != nil {
+		report.add(field, ConfigCheckError, err.Error())
+		return
+	}
+
+	report.add(field, ConfigCheckOk, "")
+}
+
+func checkRequired(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("required")
+	}
+
+	return nil
+}
+
+func checkApiEndpoint(endpoint string) error {
+	if err := checkRequired(endpoint); err != nil {
+		return err
+	}
+
+	u, err := url.ParseRequestURI(endpoint)
+	if err != nil {
+		return errors.New("invalid URL: " + err.Error())
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return errors.New(fmt.Sprintf("expect http or https scheme, got: %s", u.Scheme))
+	}
+
+	if u.Host == "" {
+		return errors.New("host is empty")
+	}
+
+	if !strings.HasSuffix(strings.TrimSuffix(u.Path, "/"), "/api") {
+		return errors.New(fmt.Sprintf("expect path ends with /api, e.g. https://vcd.example.com/api, got: %s", u.Path))
+	}
+
+	return nil
+}
+
+func checkReadableFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.New("unreadable: " + err.Error())
+	}
+	file.Close()
+
+	return nil
+}
+
+func checkCredentialSources(sources []string) error {
+	for _, source := range sources {
+		if !contains(credential.DefaultSources, source) {
+			return errors.New(fmt.Sprintf("unknown credential source: %s, expect: %s", source, strings.Join(credential.DefaultSources, ",")))
+		}
+	}
+
+	return nil
+}
+
+func checkNonNegative(duration time.Duration) error {
+	if duration < 0 {
+		return errors.New("must not be negative")
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+package operation
+
+import (
+	"context"
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/ty2/vcdfv/vcd"
+	"github.com/ty2/vcdfv/vmdiskop"
+	"path/filepath"
+	"strconv"
+)
+
+// ext4 metadata and journal take a few percent of a disk, a filesystem within this ratio of its disk fills the disk
+const fsOverheadPercent = 10
+
+// VolumeStatsResult is usage of a volume, filesystem stats are empty for a block volume
+type VolumeStatsResult struct {
+	MountPoint string `json:"mountPoint"`
+	Device     string `json:"device"`
+	DiskName   string `json:"diskName"`
+	DiskId     string `json:"diskId"`
+	// size of vCD disk in bytes
+	DiskSize int64 `json:"diskSize"`
+	Block    bool  `json:"block"`
+	vmdiskop.FilesystemStats
+	// filesystem is not grown after disk is expanded
+	FilesystemSmallerThanDisk bool `json:"filesystemSmallerThanDisk"`
+}
+
+// VolumeStats reports capacity and inodes of the volume mounted at mount dir with size of its vCD disk
+type VolumeStats struct {
+	MountDir    string
+	VcdfvConfig *config.Vcdfv
+	// cancels vCD operations, e.g. when the process is terminated
+	Context context.Context
+}
+
+func (volumeStats *VolumeStats) Exec() (*ExecResult, error) {
+	if volumeStats.MountDir == "" {
+		err := errcode.New(errcode.InvalidArgument, "mount dir is empty")
+		return (&StatusFailure{Error: err}).Exec()
+	}
+
+	// device node of a block volume is bound at mount dir
+	blockDevice, err := vmdiskop.FindDeviceByMountPoint(volumeStats.MountDir)
+	block := false
+	if err != nil {
+		if boundDevice, boundErr := vmdiskop.FindDeviceByDeviceNode(volumeStats.MountDir); boundErr == nil {
+			blockDevice, err, block = boundDevice, nil, true
+		}
+	}
+	if err != nil {
+		return (&StatusFailure{Error: errcode.Annotate(errcode.DeviceNotFound, err, "find device by mount point")}).Exec()
+	}
+
+	vdc, err := VdcClient(volumeStats.VcdfvConfig, nil)
+	if err != nil {
+		return (&StatusFailure{Error: errcode.Annotate(errcode.VcdApiFailed, err, "vdc client")}).Exec()
+	}
+	vdc = vdc.WithContext(contextOrBackground(volumeStats.Context))
+
+	disk, err := volumeStats.findDisk(vdc, blockDevice, block)
+	if err != nil {
+		return (&StatusFailure{Error: err}).Exec()
+	}
+
+	result, err := StatVolume(volumeStats.MountDir, blockDevice, disk, block)
+	if err != nil {
+		return (&StatusFailure{Error: err}).Exec()
+	}
+
+	return (&StatusSuccess{JsonMessageStruct: result}).Exec()
+}
+
+// findDisk finds disk by filesystem UUID which is the disk UUID, then by the pv or volume name of mount dir.
+// filesystem of a block volume belongs to the workload, it does not identify the disk
+func (volumeStats *VolumeStats) findDisk(vdc *vcd.Vdc, blockDevice *vmdiskop.BlockDevice, block bool) (*vcd.VdcDisk, error) {
+	if !block && vcd.DiskUuid(blockDevice.Uuid) != "" {
+		disk, err := vdc.FindDiskById(blockDevice.Uuid)
+		if err == nil {
+			return disk, nil
+		} else if !errcode.Is(err, errcode.NotFound) {
+			return nil, errcode.Annotate(errcode.VcdApiFailed, err, "find disk by filesystem UUID")
+		}
+	}
+
+	disk, err := FindClusterDisk(vdc, volumeStats.VcdfvConfig, DiskName(volumeStats.VcdfvConfig, filepath.Base(volumeStats.MountDir)))
+	if err != nil {
+		return nil, errcode.Annotate(errcode.VcdApiFailed, err, "find disk by disk name")
+	}
+
+	return disk, nil
+}
+
+// StatVolume returns stats of volume at mountPoint on blockDevice of disk, capacity of a block volume is its device size
+func StatVolume(mountPoint string, blockDevice *vmdiskop.BlockDevice, disk *vcd.VdcDisk, block bool) (*VolumeStatsResult, error) {
+	result := &VolumeStatsResult{
+		MountPoint: mountPoint,
+		Device:     blockDevice.Name,
+		DiskName:   disk.Name,
+		DiskId:     disk.Id,
+		DiskSize:   int64(disk.Size),
+		Block:      block,
+	}
+
+	if block {
+		size, err := strconv.ParseInt(blockDevice.Size, 10, 64)
+		if err != nil {
+			return nil, errcode.Wrap(errcode.DeviceFailed, err, "device size")
+		}
+		result.Capacity = size
+		return result, nil
+	}
+
+	stats, err := vmdiskop.StatFilesystem(mountPoint)
+	if err != nil {
+		return nil, errcode.Wrap(errcode.DeviceFailed, err, "statfs")
+	}
+	result.FilesystemStats = *stats
+	result.FilesystemSmallerThanDisk = result.DiskSize > 0 && result.Capacity < result.DiskSize*(100-fsOverheadPercent)/100
+
+	return result, nil
+}
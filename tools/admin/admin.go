@@ -0,0 +1,112 @@
+// admin is the CLI for node admins, it reads the config of the driver
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ty2/vcdfv/config"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type command struct {
+	usage string
+	run   func(vcdfvConfig *config.Vcdfv, args []string) error
+}
+
+var commands = map[string]*command{
+	"journals": {
+		usage: "journals [-json]: list pending journals of mount and unmount",
+		run:   listJournals,
+	},
+	"audit": {
+		usage: "audit [-json] [-volume name] [-disk name] [-operation name] [-result success|failure] [-since duration]: query audit log",
+		run:   queryAudit,
+	},
+	"audit-verify": {
+		usage: "audit-verify: verify hash chain of audit log",
+		run:   verifyAudit,
+	},
+	"disks": {
+		usage: "disks [-json] [-pv name] [-namespace name] [-pod name] [-service-account name] [-vm name]: list disks of VDC with Kubernetes identity",
+		run:   listDisks,
+	},
+	"volumes": {
+		usage: "volumes [-json]: capacity, usage and inodes of filesystems of disks mounted on this node, with their total",
+		run:   listVolumes,
+	},
+	"journal-discard": {
+		usage: "journal-discard <volume>: discard pending journal of volume, its next call does not resume",
+		run:   discardJournal,
+	},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	vcdfvConfig, err := config.Load(config.Path())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err = cmd.run(vcdfvConfig, os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: admin <command>")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+func printJson(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// printTable prints rows aligned by tab, the first row is the header
+func printTable(rows [][]string) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+
+	return writer.Flush()
+}
+
+func parseFlags(name string, args []string, define func(flagSet *flag.FlagSet)) (*flag.FlagSet, error) {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	define(flagSet)
+	if err := flagSet.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return flagSet, nil
+}
+
+var errJournalDisabled = errors.New("journalDir is not configured")
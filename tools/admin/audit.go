@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ty2/vcdfv/audit"
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/operation"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errAuditDisabled = errors.New("auditLogFile is not configured")
+
+func verifyAudit(vcdfvConfig *config.Vcdfv, args []string) error {
+	auditLog := operation.AuditLog(vcdfvConfig)
+	if auditLog == nil {
+		return errAuditDisabled
+	}
+
+	count, err := auditLog.Verify()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d entries verified\n", count)
+	return nil
+}
+
+func queryAudit(vcdfvConfig *config.Vcdfv, args []string) error {
+	var asJson bool
+	var volume, diskName, operationName, result string
+	var since time.Duration
+	_, err := parseFlags("audit", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&asJson, "json", false, "print JSON")
+		flagSet.StringVar(&volume, "volume", "", "volume name")
+		flagSet.StringVar(&diskName, "disk", "", "disk name")
+		flagSet.StringVar(&operationName, "operation", "", "operation, e.g. attachDisk")
+		flagSet.StringVar(&result, "result", "", "success or failure")
+		flagSet.DurationVar(&since, "since", 0, "entries of this duration until now, e.g. 24h")
+	})
+	if err != nil {
+		return err
+	}
+
+	auditLog := operation.AuditLog(vcdfvConfig)
+	if auditLog == nil {
+		return errAuditDisabled
+	}
+
+	entries, err := auditLog.Read()
+	if err != nil {
+		return err
+	}
+
+	matched := []*audit.Entry{}
+	for _, entry := range entries {
+		if !matchValue(volume, entry.Volume) || !matchValue(diskName, entry.DiskName) ||
+			!matchValue(operationName, entry.Operation) || !matchValue(result, entry.Result) {
+			continue
+		}
+		if since > 0 && time.Since(entry.Time) > since {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+
+	if asJson {
+		return printJson(matched)
+	}
+
+	rows := [][]string{{"SEQ", "TIME", "NODE", "VOLUME", "OPERATION", "DISK", "VM", "TASKS", "RESULT", "DURATION MS"}}
+	for _, entry := range matched {
+		rows = append(rows, []string{
+			strconv.FormatInt(entry.Seq, 10),
+			entry.Time.Format(time.RFC3339),
+			entry.Node,
+			orDash(entry.Volume),
+			entry.Operation,
+			orDash(entry.DiskName),
+			orDash(entry.VmName),
+			orDash(strings.Join(entry.TaskIds, ",")),
+			entry.Result,
+			strconv.FormatInt(entry.DurationMs, 10),
+		})
+	}
+
+	return printTable(rows)
+}
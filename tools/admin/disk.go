@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/operation"
+	"github.com/ty2/vcdfv/vcd"
+	"strconv"
+)
+
+type diskInfo struct {
+	Name     string            `json:"name"`
+	Id       string            `json:"id"`
+	Size     int               `json:"size"`
+	VmName   string            `json:"vmName,omitempty"`
+	Owner    string            `json:"owner,omitempty"`
+	Identity *vcd.DiskIdentity `json:"identity,omitempty"`
+}
+
+// diskFilter matches disks by identity and attached VM, empty fields match all disks
+type diskFilter struct {
+	pv             string
+	namespace      string
+	pod            string
+	serviceAccount string
+	vm             string
+}
+
+func (filter *diskFilter) match(info *diskInfo) bool {
+	identity := info.Identity
+	if identity == nil {
+		identity = &vcd.DiskIdentity{}
+	}
+
+	return matchValue(filter.pv, identity.Pv) &&
+		matchValue(filter.namespace, identity.Namespace) &&
+		matchValue(filter.pod, identity.Pod) &&
+		matchValue(filter.serviceAccount, identity.ServiceAccount) &&
+		matchValue(filter.vm, info.VmName)
+}
+
+func matchValue(filterValue string, value string) bool {
+	return filterValue == "" || filterValue == value
+}
+
+func listDisks(vcdfvConfig *config.Vcdfv, args []string) error {
+	var asJson bool
+	filter := &diskFilter{}
+	_, err := parseFlags("disks", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&asJson, "json", false, "print JSON")
+		flagSet.StringVar(&filter.pv, "pv", "", "PV name")
+		flagSet.StringVar(&filter.namespace, "namespace", "", "namespace of pod")
+		flagSet.StringVar(&filter.pod, "pod", "", "pod name")
+		flagSet.StringVar(&filter.serviceAccount, "service-account", "", "service account of pod")
+		flagSet.StringVar(&filter.vm, "vm", "", "name of VM which disk is attached to")
+	})
+	if err != nil {
+		return err
+	}
+
+	vdc, err := operation.VdcClient(vcdfvConfig, nil)
+	if err != nil {
+		return err
+	}
+
+	disks, err := vdc.ListDisks()
+	if err != nil {
+		return err
+	}
+
+	infos := []*diskInfo{}
+	for _, disk := range disks {
+		identity, err := vdc.DiskIdentity(disk)
+		if err != nil {
+			return err
+		}
+
+		info := &diskInfo{
+			Name:     disk.Name,
+			Id:       disk.Id,
+			Size:     disk.Size,
+			Owner:    operation.DiskOwner(disk),
+			Identity: identity,
+		}
+		if disk.AttachedVm != nil {
+			info.VmName = disk.AttachedVm.Name
+		}
+
+		if filter.match(info) {
+			infos = append(infos, info)
+		}
+	}
+
+	if asJson {
+		return printJson(infos)
+	}
+
+	rows := [][]string{{"DISK", "SIZE", "VM", "OWNER", "PV", "NAMESPACE", "POD", "SERVICE ACCOUNT"}}
+	for _, info := range infos {
+		identity := info.Identity
+		if identity == nil {
+			identity = &vcd.DiskIdentity{}
+		}
+
+		rows = append(rows, []string{
+			info.Name,
+			strconv.Itoa(info.Size),
+			orDash(info.VmName),
+			orDash(info.Owner),
+			orDash(identity.Pv),
+			orDash(identity.Namespace),
+			orDash(identity.Pod),
+			orDash(identity.ServiceAccount),
+		})
+	}
+
+	return printTable(rows)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/operation"
+	"time"
+)
+
+func listJournals(vcdfvConfig *config.Vcdfv, args []string) error {
+	var asJson bool
+	_, err := parseFlags("journals", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&asJson, "json", false, "print JSON")
+	})
+	if err != nil {
+		return err
+	}
+
+	store := operation.JournalStore(vcdfvConfig)
+	if store == nil {
+		return errJournalDisabled
+	}
+
+	journals, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	if asJson {
+		return printJson(journals)
+	}
+
+	rows := [][]string{{"VOLUME", "OPERATION", "STATE", "PENDING STEP", "STATUS", "DISK", "DEVICE", "MOUNT DIR", "UPDATED"}}
+	for _, journal := range journals {
+		pendingStep, status := "-", "-"
+		if pending := journal.Pending(); pending != nil {
+			pendingStep, status = pending.Name, pending.Status
+		}
+
+		rows = append(rows, []string{
+			journal.Volume,
+			journal.Operation,
+			orDash(journal.State),
+			pendingStep,
+			status,
+			orDash(journal.DiskName),
+			orDash(journal.DeviceName),
+			journal.MountDir,
+			journal.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return printTable(rows)
+}
+
+func discardJournal(vcdfvConfig *config.Vcdfv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expect volume")
+	}
+
+	store := operation.JournalStore(vcdfvConfig)
+	if store == nil {
+		return errJournalDisabled
+	}
+
+	return store.Remove(args[0])
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
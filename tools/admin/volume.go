@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/operation"
+	"github.com/ty2/vcdfv/vcd"
+	"github.com/ty2/vcdfv/vmdiskop"
+	"strconv"
+	"strings"
+)
+
+// volumeTotal is the sum of stats of filesystem volumes of the node
+type volumeTotal struct {
+	Volumes                   int   `json:"volumes"`
+	DiskSize                  int64 `json:"diskSize"`
+	Capacity                  int64 `json:"capacity"`
+	Used                      int64 `json:"used"`
+	Available                 int64 `json:"available"`
+	Inodes                    int64 `json:"inodes"`
+	InodesUsed                int64 `json:"inodesUsed"`
+	FilesystemSmallerThanDisk int   `json:"filesystemSmallerThanDisk"`
+	// volumes whose stats are not read, they are not in the sums
+	Failed int `json:"failed"`
+}
+
+// volumeError is a volume whose stats are not read
+type volumeError struct {
+	DiskName   string `json:"diskName"`
+	Device     string `json:"device"`
+	MountPoint string `json:"mountPoint"`
+	Error      string `json:"error"`
+}
+
+// listVolumes reports usage of filesystems of vcdfv disks mounted on this node, a disk is found by its filesystem UUID
+func listVolumes(vcdfvConfig *config.Vcdfv, args []string) error {
+	var asJson bool
+	_, err := parseFlags("volumes", args, func(flagSet *flag.FlagSet) {
+		flagSet.BoolVar(&asJson, "json", false, "print JSON")
+	})
+	if err != nil {
+		return err
+	}
+
+	vdc, err := operation.VdcClient(vcdfvConfig, nil)
+	if err != nil {
+		return err
+	}
+
+	disks, err := vdc.ListDisks()
+	if err != nil {
+		return err
+	}
+
+	// filesystem UUID is the disk UUID, or recorded in disk meta
+	fsUuidDisks := map[string]*vcd.VdcDisk{}
+	for _, disk := range disks {
+		if uuid := vcd.DiskUuid(disk.Id); uuid != "" {
+			fsUuidDisks[strings.ToLower(uuid)] = disk
+		}
+		if disk.Meta != nil && disk.Meta.FsUuid != "" {
+			fsUuidDisks[strings.ToLower(disk.Meta.FsUuid)] = disk
+		}
+	}
+
+	// read only, SCSI hosts are not rescanned
+	blockDevices, err := vmdiskop.ListBlockDevices()
+	if err != nil {
+		return err
+	}
+
+	// lsblk shows a single mount point of a device, mountinfo has all of them
+	mountInfos, err := vmdiskop.MountInfos()
+	if err != nil {
+		return err
+	}
+
+	results := []*operation.VolumeStatsResult{}
+	volumeErrors := []*volumeError{}
+	vmdiskop.Find(blockDevices, func(blockDevice *vmdiskop.BlockDevice) bool {
+		disk, ok := fsUuidDisks[strings.ToLower(blockDevice.Uuid)]
+		if !ok {
+			return false
+		}
+
+		mountPoint := filesystemMountPoint(mountInfos, blockDevice)
+		if mountPoint == "" {
+			return false
+		}
+
+		// a volume which fails is reported, the others are still summed
+		result, err := operation.StatVolume(mountPoint, blockDevice, disk, false)
+		if err != nil {
+			volumeErrors = append(volumeErrors, &volumeError{
+				DiskName:   disk.Name,
+				Device:     blockDevice.Name,
+				MountPoint: mountPoint,
+				Error:      err.Error(),
+			})
+			return false
+		}
+		results = append(results, result)
+		return false
+	})
+
+	total := &volumeTotal{Failed: len(volumeErrors)}
+	for _, result := range results {
+		total.Volumes++
+		total.DiskSize += result.DiskSize
+		total.Capacity += result.Capacity
+		total.Used += result.Used
+		total.Available += result.Available
+		total.Inodes += result.Inodes
+		total.InodesUsed += result.InodesUsed
+		if result.FilesystemSmallerThanDisk {
+			total.FilesystemSmallerThanDisk++
+		}
+	}
+
+	if asJson {
+		err = printJson(struct {
+			Volumes []*operation.VolumeStatsResult `json:"volumes"`
+			Total   *volumeTotal                   `json:"total"`
+			Errors  []*volumeError                 `json:"errors"`
+		}{results, total, volumeErrors})
+		if err != nil {
+			return err
+		}
+
+		return volumeErrorsError(volumeErrors)
+	}
+
+	rows := [][]string{{"DISK", "DEVICE", "MOUNT POINT", "DISK SIZE", "CAPACITY", "USED", "AVAILABLE", "INODES", "INODES USED", "FS SMALLER"}}
+	for _, result := range results {
+		rows = append(rows, []string{
+			result.DiskName,
+			result.Device,
+			result.MountPoint,
+			formatInt(result.DiskSize),
+			formatInt(result.Capacity),
+			formatInt(result.Used),
+			formatInt(result.Available),
+			formatInt(result.Inodes),
+			formatInt(result.InodesUsed),
+			strconv.FormatBool(result.FilesystemSmallerThanDisk),
+		})
+	}
+	for _, volumeErr := range volumeErrors {
+		rows = append(rows, []string{volumeErr.DiskName, volumeErr.Device, volumeErr.MountPoint, "-", "-", "-", "-", "-", "-", "-"})
+	}
+	totalName := "TOTAL " + strconv.Itoa(total.Volumes)
+	if total.Failed > 0 {
+		totalName += " (" + strconv.Itoa(total.Failed) + " FAILED)"
+	}
+	rows = append(rows, []string{
+		totalName,
+		"-",
+		"-",
+		formatInt(total.DiskSize),
+		formatInt(total.Capacity),
+		formatInt(total.Used),
+		formatInt(total.Available),
+		formatInt(total.Inodes),
+		formatInt(total.InodesUsed),
+		strconv.Itoa(total.FilesystemSmallerThanDisk),
+	})
+
+	if err = printTable(rows); err != nil {
+		return err
+	}
+
+	return volumeErrorsError(volumeErrors)
+}
+
+// filesystemMountPoint returns the first mount point of the filesystem of blockDevice in mount order, empty when it is
+// not mounted. A bind mount of its subdirectory or of its device node is not the filesystem
+func filesystemMountPoint(mountInfos []*vmdiskop.MountInfo, blockDevice *vmdiskop.BlockDevice) string {
+	for _, mountInfo := range mountInfos {
+		if mountInfo.BoundDeviceName() == "" && mountInfo.Root == "/" && mountInfo.MajMin == blockDevice.MajMin {
+			return mountInfo.MountPoint
+		}
+	}
+
+	return ""
+}
+
+// volumeErrorsError is the exit error of volumes, nil when stats of all volumes are read
+func volumeErrorsError(volumeErrors []*volumeError) error {
+	if len(volumeErrors) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(volumeErrors))
+	for _, volumeErr := range volumeErrors {
+		messages = append(messages, volumeErr.DiskName+" at "+volumeErr.MountPoint+": "+volumeErr.Error)
+	}
+
+	return fmt.Errorf("stats of %d volume(s) are not read: %s", len(volumeErrors), strings.Join(messages, "; "))
+}
+
+func formatInt(value int64) string {
+	return strconv.FormatInt(value, 10)
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"github.com/ty2/vcdfv/config"
 	"github.com/ty2/vcdfv/operation"
-	"github.com/ty2/vcdfv/vcd"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"log"
@@ -30,14 +29,7 @@ func init() {
 }
 
 func main() {
-	vdc, err := vcd.NewVdc(&vcd.VcdConfig{
-		ApiEndpoint: vcdfvConfig.VcdApiEndpoint,
-		Insecure:    vcdfvConfig.VcdInsecure,
-		User:        vcdfvConfig.VcdUser,
-		Password:    vcdfvConfig.VcdPassword,
-		Org:         vcdfvConfig.VcdOrg,
-		Vdc:         vcdfvConfig.VcdVdc,
-	})
+	vdc, err := operation.VdcClient(vcdfvConfig, nil)
 
 	if err != nil {
 		panic(err)
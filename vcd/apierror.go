@@ -0,0 +1,103 @@
+package vcd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// vCD error codes, minorErrorCode of an error response or of an error of task
+const (
+	minorErrorCodeNotFound   = "RESOURCE_NOT_FOUND"
+	minorErrorCodeBusyEntity = "BUSY_ENTITY"
+)
+
+// ApiError is an error response of vCD
+type ApiError struct {
+	// HTTP status code of the response
+	StatusCode int
+	// vCD error code, e.g. RESOURCE_NOT_FOUND, empty when it is unknown
+	MinorErrorCode string
+	Message        string
+}
+
+func (e *ApiError) Error() string {
+	if e.MinorErrorCode == "" {
+		return fmt.Sprintf("API Error: %d: %s", e.StatusCode, e.Message)
+	}
+
+	return fmt.Sprintf("API Error: %d: %s (%s)", e.StatusCode, e.Message, e.MinorErrorCode)
+}
+
+type errorXml struct {
+	MinorErrorCode string `xml:"minorErrorCode,attr"`
+	Message        string `xml:"message,attr"`
+}
+
+// newApiError returns error of a response with statusCode, body is an Error element of vCD or any other text
+func newApiError(statusCode int, body []byte) *ApiError {
+	apiErr := &ApiError{StatusCode: statusCode}
+
+	var errorBody errorXml
+	if err := xml.Unmarshal(body, &errorBody); err == nil && errorBody.Message != "" {
+		apiErr.MinorErrorCode = errorBody.MinorErrorCode
+		apiErr.Message = errorBody.Message
+	} else if len(body) > 0 {
+		apiErr.Message = string(body)
+	} else {
+		apiErr.Message = http.StatusText(statusCode)
+	}
+
+	return apiErr
+}
+
+// govcd returns an error response as "API Error: <status code>: <message>", optionally prefixed by its own context
+var govcdApiErrorRegexp = regexp.MustCompile(`(?:^|: )API Error: ([0-9]{3}): `)
+
+// apiErrorOf returns the error response of err or of its causes, nil when err is not an error response of vCD.
+// errors of govcd only have the status code
+func apiErrorOf(err error) *ApiError {
+	chain := errorChain(err)
+	for _, cause := range chain {
+		if apiErr, ok := cause.(*ApiError); ok {
+			return apiErr
+		}
+	}
+
+	// the innermost error, only an error of govcd is matched by its message
+	if len(chain) > 0 {
+		innermost := chain[len(chain)-1]
+		if match := govcdApiErrorRegexp.FindStringSubmatch(innermost.Error()); match != nil {
+			statusCode, _ := strconv.Atoi(match[1])
+			return &ApiError{StatusCode: statusCode, Message: innermost.Error()}
+		}
+	}
+
+	return nil
+}
+
+// isNotFound reports whether vCD answered that the entity does not exist. 403 is not not-found, vCD also answers
+// it for an entity which exists but the user cannot access
+func isNotFound(err error) bool {
+	apiErr := apiErrorOf(err)
+	if apiErr == nil {
+		return false
+	}
+
+	return apiErr.StatusCode == http.StatusNotFound || apiErr.MinorErrorCode == minorErrorCodeNotFound
+}
+
+// govcd answers a vApp which is not in the VDC without a request of its own, only by the message
+var govcdVAppNotFoundRegexp = regexp.MustCompile(`^can't find vApp: `)
+
+// isVAppNotFound reports whether vCD or govcd answered that the vApp does not exist
+func isVAppNotFound(err error) bool {
+	if isNotFound(err) {
+		return true
+	}
+
+	chain := errorChain(err)
+	return len(chain) > 0 && govcdVAppNotFoundRegexp.MatchString(chain[len(chain)-1].Error())
+}
@@ -0,0 +1,76 @@
+package vcd
+
+import (
+	"errors"
+	"github.com/ty2/vcdfv/errcode"
+	"testing"
+)
+
+func TestIsNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"404 of govcd", errors.New("API Error: 404: [ 1234 ] The requested resource was not found"), true},
+		{"404 of govcd with context", errors.New("error retrieving disk: API Error: 404: not found"), true},
+		{"403 of govcd", errors.New("API Error: 403: [ 1234 ] Either you need some or all of the following rights"), false},
+		{"404 response", newApiError(404, []byte(`<Error majorErrorCode="404" minorErrorCode="RESOURCE_NOT_FOUND" message="not found"/>`)), true},
+		{"403 response", newApiError(403, []byte(`<Error majorErrorCode="403" minorErrorCode="ACCESS_TO_RESOURCE_IS_FORBIDDEN" message="forbidden"/>`)), false},
+		{"resource not found code", &ApiError{StatusCode: 400, MinorErrorCode: "RESOURCE_NOT_FOUND"}, true},
+		{"wrapped 404 response", errcode.Annotate(errcode.VcdApiFailed, &ApiError{StatusCode: 404}, "find disk by href"), true},
+		{"404 in href", errors.New("task https://vcd/api/task/4040404-404 error: failed"), false},
+		{"404 in uuid of govcd error", errors.New("API Error: 500: disk 404e4f0a-0000-4000-8000-000000000404 failed"), false},
+		{"forbidden code in message", errors.New("disk access_to_resource_is_forbidden"), false},
+		{"nil cause", errcode.New(errcode.NotFound, "disk not found"), false},
+	}
+
+	for _, test := range tests {
+		if got := isNotFound(test.err); got != test.want {
+			t.Errorf("%s: isNotFound(%q) = %v, want %v", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+func TestFindVAppError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"vApp not in VDC", errors.New("can't find vApp: app"), errcode.NotFound},
+		{"404 of govcd", errors.New("API Error: 404: [ 1234 ] The requested resource was not found"), errcode.NotFound},
+		{"wrapped 404 response", errcode.Annotate(errcode.VcdApiFailed, &ApiError{StatusCode: 404}, "find vApp"), errcode.NotFound},
+		{"403 of govcd", errors.New("API Error: 403: [ 1234 ] Either you need some or all of the following rights"), errcode.VcdApiFailed},
+		{"500 response", &ApiError{StatusCode: 500}, errcode.VcdApiFailed},
+		{"refresh VDC failed", errors.New("error refreshing vdc: API Error: 503: unavailable"), errcode.VcdApiFailed},
+		{"given up retries keep their code", errcode.New(errcode.Busy, "find vApp: gave up after 3 attempt(s)"), errcode.Busy},
+		{"timeout keeps its code", errcode.New(errcode.Timeout, "find vApp: deadline exceeded"), errcode.Timeout},
+	}
+
+	for _, test := range tests {
+		if got := errcode.CodeOf(findVAppError(test.err, "app")); got != test.want {
+			t.Errorf("%s: code of findVAppError(%q) = %s, want %s", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+func TestNewApiError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       ApiError
+	}{
+		{"error body", 400, `<Error xmlns="http://www.vmware.com/vcloud/v1.5" majorErrorCode="400" minorErrorCode="BUSY_ENTITY" message="busy"/>`, ApiError{400, "BUSY_ENTITY", "busy"}},
+		{"text body", 502, "bad gateway", ApiError{502, "", "bad gateway"}},
+		{"empty body", 503, "", ApiError{503, "", "Service Unavailable"}},
+	}
+
+	for _, test := range tests {
+		got := newApiError(test.statusCode, []byte(test.body))
+		if *got != test.want {
+			t.Errorf("%s: newApiError() = %+v, want %+v", test.name, *got, test.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package vcd
+
+import (
+	"context"
+	"time"
+)
+
+// Mutation is a change of vCD made by Vdc, e.g. create, update, attach, detach or delete of a disk
+type Mutation struct {
+	Operation string
+	DiskName  string
+	DiskHref  string
+	VmName    string
+	// hrefs of vCD tasks of all attempts
+	TaskHrefs []string
+	StartedAt time.Time
+	Duration  time.Duration
+	Err       error
+}
+
+// AuditFunc receives every mutation after it is finished, successful or not
+type AuditFunc func(mutation *Mutation)
+
+type taskHrefsKey struct{}
+
+// WithAudit returns a shallow copy of vdc, its mutations are passed to audit
+func (vdc *Vdc) WithAudit(audit AuditFunc) *Vdc {
+	vdcCopy := *vdc
+	vdcCopy.audit = audit
+	return &vdcCopy
+}
+
+// mutate runs fn with retry as mutation, tasks waited by fn are recorded in mutation
+func (vdc *Vdc) mutate(mutation *Mutation, idempotent bool, fn func(ctx context.Context) error) error {
+	mutation.StartedAt = time.Now()
+
+	ctx := context.WithValue(vdc.context(), taskHrefsKey{}, &mutation.TaskHrefs)
+	err := vdc.retryContext(ctx, mutation.Operation, idempotent, fn)
+
+	mutation.Duration = time.Since(mutation.StartedAt)
+	mutation.Err = err
+	if vdc.audit != nil {
+		vdc.audit(mutation)
+	}
+
+	return err
+}
+
+// recordTask adds task href to mutation of ctx
+func recordTask(ctx context.Context, href string) {
+	if taskHrefs, ok := ctx.Value(taskHrefsKey{}).(*[]string); ok && href != "" {
+		for _, taskHref := range *taskHrefs {
+			if taskHref == href {
+				return
+			}
+		}
+		*taskHrefs = append(*taskHrefs, href)
+	}
+}
@@ -0,0 +1,70 @@
+package vcd
+
+// keys of Kubernetes identity in disk metadata, they are searchable in vCD and by admin CLI
+const (
+	identityKeyPv             = "vcdfv.k8s.pv"
+	identityKeyNamespace      = "vcdfv.k8s.namespace"
+	identityKeyPod            = "vcdfv.k8s.pod"
+	identityKeyPodUid         = "vcdfv.k8s.podUid"
+	identityKeyServiceAccount = "vcdfv.k8s.serviceAccount"
+)
+
+// DiskIdentity is the Kubernetes objects which use disk, it is the last pod which mounted disk.
+// FlexVolume passes no PVC, the PV names it
+type DiskIdentity struct {
+	Pv             string `json:"pv,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	Pod            string `json:"pod,omitempty"`
+	PodUid         string `json:"podUid,omitempty"`
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+}
+
+func (identity *DiskIdentity) entries() map[string]string {
+	return map[string]string{
+		identityKeyPv:             identity.Pv,
+		identityKeyNamespace:      identity.Namespace,
+		identityKeyPod:            identity.Pod,
+		identityKeyPodUid:         identity.PodUid,
+		identityKeyServiceAccount: identity.ServiceAccount,
+	}
+}
+
+// IdentityOf returns identity in metadata entries, nil when disk has no identity
+func IdentityOf(entries map[string]string) *DiskIdentity {
+	identity := &DiskIdentity{
+		Pv:             entries[identityKeyPv],
+		Namespace:      entries[identityKeyNamespace],
+		Pod:            entries[identityKeyPod],
+		PodUid:         entries[identityKeyPodUid],
+		ServiceAccount: entries[identityKeyServiceAccount],
+	}
+	if *identity == (DiskIdentity{}) {
+		return nil
+	}
+
+	return identity
+}
+
+// DiskIdentity returns identity of disk, nil when disk has no identity
+func (vdc *Vdc) DiskIdentity(disk *VdcDisk) (*DiskIdentity, error) {
+	entries, err := vdc.DiskMetadata(disk)
+	if err != nil {
+		return nil, err
+	}
+
+	return IdentityOf(entries), nil
+}
+
+// SetDiskIdentity records identity in metadata of disk, a disk which has the identity is not updated
+func (vdc *Vdc) SetDiskIdentity(disk *VdcDisk, identity *DiskIdentity) error {
+	current, err := vdc.DiskIdentity(disk)
+	if err != nil {
+		return err
+	}
+
+	if current != nil && *current == *identity {
+		return nil
+	}
+
+	return vdc.MergeDiskMetadata(disk, identity.entries())
+}
@@ -0,0 +1,185 @@
+package vcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/ty2/vcdfv/errcode"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a VM holding a shared disk has a metadata entry of the disk, every VM only writes its own entry
+// so the VMs never race on a counter
+const diskHolderKeyPrefix = "vcdfv.holder."
+
+const (
+	metadataContentType      = "application/vnd.vmware.vcloud.metadata+xml"
+	metadataValueContentType = "application/vnd.vmware.vcloud.metadata.value+xml"
+)
+
+type metadataXml struct {
+	Entries []*metadataEntryXml `xml:"MetadataEntry"`
+}
+
+type metadataEntryXml struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"TypedValue>Value"`
+}
+
+// DiskMetadata returns metadata entries of disk by key, it is the vCD metadata, not VdcDiskMeta of description
+func (vdc *Vdc) DiskMetadata(disk *VdcDisk) (map[string]string, error) {
+	metadataUrl, err := url.Parse(strings.TrimSuffix(disk.Href, "/") + "/metadata")
+	if err != nil {
+		return nil, errcode.Wrap(errcode.InvalidArgument, err, "parse disk href")
+	}
+
+	var metadata metadataXml
+	err = vdc.readXml(*metadataUrl, nil, &metadata)
+	if err != nil {
+		return nil, errcode.Annotate(errcode.VcdApiFailed, err, "disk metadata")
+	}
+
+	entries := map[string]string{}
+	for _, entry := range metadata.Entries {
+		entries[entry.Key] = entry.Value
+	}
+
+	return entries, nil
+}
+
+// MergeDiskMetadata adds or replaces metadata entries of disk in one task, other entries are kept.
+// unlike description, metadata is updated while disk is attached
+func (vdc *Vdc) MergeDiskMetadata(disk *VdcDisk, entries map[string]string) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var body bytes.Buffer
+	body.WriteString(`<Metadata xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`)
+	for _, key := range keys {
+		body.WriteString("<MetadataEntry><Key>")
+		xml.EscapeText(&body, []byte(key))
+		body.WriteString(`</Key><TypedValue xsi:type="MetadataStringValue"><Value>`)
+		xml.EscapeText(&body, []byte(entries[key]))
+		body.WriteString("</Value></TypedValue></MetadataEntry>")
+	}
+	body.WriteString("</Metadata>")
+
+	mutation := &Mutation{Operation: OpUpdateDisk, DiskName: disk.Name, DiskHref: disk.Href}
+	err := vdc.mutate(mutation, true, func(ctx context.Context) error {
+		return vdc.metadataTask(ctx, http.MethodPost, disk, "", metadataContentType, bytes.NewReader(body.Bytes()))
+	})
+	if err != nil {
+		return errcode.Annotate(errcode.MetaFailed, err, "merge metadata of disk "+disk.Name)
+	}
+
+	return nil
+}
+
+// DiskHolders returns names of VMs which hold disk, sorted
+func (vdc *Vdc) DiskHolders(disk *VdcDisk) ([]string, error) {
+	entries, err := vdc.DiskMetadata(disk)
+	if err != nil {
+		return nil, err
+	}
+
+	var holders []string
+	for key := range entries {
+		if strings.HasPrefix(key, diskHolderKeyPrefix) {
+			holders = append(holders, strings.TrimPrefix(key, diskHolderKeyPrefix))
+		}
+	}
+	sort.Strings(holders)
+
+	return holders, nil
+}
+
+// AddDiskHolder records VM of vmName holds disk, value of the entry is the time it is added
+func (vdc *Vdc) AddDiskHolder(disk *VdcDisk, vmName string) error {
+	body := fmt.Sprintf(`<MetadataValue xmlns="http://www.vmware.com/vcloud/v1.5" `+
+		`xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`+
+		`<TypedValue xsi:type="MetadataStringValue"><Value>%s</Value></TypedValue></MetadataValue>`,
+		time.Now().UTC().Format(time.RFC3339))
+
+	mutation := &Mutation{Operation: OpUpdateDisk, DiskName: disk.Name, DiskHref: disk.Href, VmName: vmName}
+	err := vdc.mutate(mutation, true, func(ctx context.Context) error {
+		return vdc.metadataTask(ctx, http.MethodPut, disk, diskHolderKeyPrefix+vmName, metadataValueContentType, strings.NewReader(body))
+	})
+	if err != nil {
+		return errcode.Annotate(errcode.MetaFailed, err, "add holder "+vmName+" of disk "+disk.Name)
+	}
+
+	return nil
+}
+
+// RemoveDiskHolder removes VM of vmName from holders of disk, a VM which does not hold disk is not an error
+func (vdc *Vdc) RemoveDiskHolder(disk *VdcDisk, vmName string) error {
+	mutation := &Mutation{Operation: OpUpdateDisk, DiskName: disk.Name, DiskHref: disk.Href, VmName: vmName}
+	err := vdc.mutate(mutation, true, func(ctx context.Context) error {
+		err := vdc.metadataTask(ctx, http.MethodDelete, disk, diskHolderKeyPrefix+vmName, "", nil)
+		if err != nil && isNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return errcode.Annotate(errcode.MetaFailed, err, "remove holder "+vmName+" of disk "+disk.Name)
+	}
+
+	return nil
+}
+
+// metadataTask sends request of metadata entry of key, or of all metadata when key is empty, and waits for its task
+func (vdc *Vdc) metadataTask(ctx context.Context, method string, disk *VdcDisk, key string, contentType string, body io.Reader) error {
+	if err := VerifyHref(disk.Href); err != nil {
+		return err
+	}
+
+	metadataHref := strings.TrimSuffix(disk.Href, "/") + "/metadata"
+	if key != "" {
+		metadataHref += "/" + url.PathEscape(key)
+	}
+
+	metadataUrl, err := url.Parse(metadataHref)
+	if err != nil {
+		return err
+	}
+
+	req := vdc.vcdClient.Client.NewRequest(nil, method, *metadataUrl, body)
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := vdc.vcdClient.Client.Http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return newApiError(resp.StatusCode, respBody)
+	}
+
+	task := &xmlTask{vdc: vdc}
+	err = xml.Unmarshal(respBody, &task.task)
+	if err != nil {
+		return err
+	}
+
+	return vdc.waitTask(ctx, task)
+}
@@ -0,0 +1,76 @@
+package vcd
+
+import (
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/vmware/go-vcloud-director/govcd"
+)
+
+// Probe steps, in the order they are run
+const (
+	ProbeLogin = "login"
+	ProbeOrg   = "org"
+	ProbeVdc   = "vdc"
+	ProbeVApp  = "vApp"
+)
+
+type ProbeResult struct {
+	Step string
+	// nil when step succeeded
+	Err error
+	// false when a previous step failed
+	Run bool
+}
+
+// Probe logins VCD and resolves org, VDC and vApp one by one, steps after the first failure are not run.
+// Session cache is never used so the credential is really tested, the session is not logged out because
+// an API token session may be shared with other invocations
+func Probe(config *VcdConfig, vAppName string) []*ProbeResult {
+	results := []*ProbeResult{
+		{Step: ProbeLogin},
+		{Step: ProbeOrg},
+		{Step: ProbeVdc},
+		{Step: ProbeVApp},
+	}
+
+	vdc := &Vdc{config: config}
+	var client *govcd.VCDClient
+	var org govcd.Org
+	var vdcClient govcd.Vdc
+
+	steps := []func() error{
+		func() (err error) {
+			client, err = vdc.connect(config)
+			return err
+		},
+		func() (err error) {
+			org, err = govcd.GetOrgByName(client, config.Org)
+			if err != nil {
+				return errcode.Annotate(errcode.NotFound, err, "get org "+config.Org)
+			}
+			return nil
+		},
+		func() (err error) {
+			vdcClient, err = org.GetVdcByName(config.Vdc)
+			if err != nil {
+				return errcode.Annotate(errcode.NotFound, err, "get VDC "+config.Vdc)
+			}
+			return nil
+		},
+		func() error {
+			_, err := vdcClient.FindVAppByName(vAppName)
+			if err != nil {
+				return findVAppError(err, vAppName)
+			}
+			return nil
+		},
+	}
+
+	for i, step := range steps {
+		results[i].Run = true
+		if results[i].Err = step(); results[i].Err != nil {
+			break
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,219 @@
+package vcd
+
+import (
+	"context"
+	"encoding/xml"
+	"github.com/ty2/vcdfv/errcode"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// records per page of query service, the maximum of VCD is 128
+const queryPageSize = 128
+
+type queryResultRecords struct {
+	Total       int           `xml:"total,attr"`
+	Page        int           `xml:"page,attr"`
+	PageSize    int           `xml:"pageSize,attr"`
+	DiskRecords []*diskRecord `xml:"DiskRecord"`
+}
+
+// diskRecord is a record of the typed query of disk, it has name, description and whether the disk is attached
+// so a lookup is one round trip, the attached VM is only requested for attached disks
+type diskRecord struct {
+	Href        string `xml:"href,attr"`
+	Name        string `xml:"name,attr"`
+	Description string `xml:"description,attr"`
+	SizeB       int64  `xml:"sizeB,attr"`
+	SizeMb      int64  `xml:"sizeMb,attr"`
+	IsAttached  bool   `xml:"isAttached,attr"`
+	SharingType string `xml:"sharingType,attr"`
+}
+
+// diskXml is the disk entity, size is deprecated by sizeMb in newer API versions
+type diskXml struct {
+	Id          string `xml:"id,attr"`
+	Name        string `xml:"name,attr"`
+	Href        string `xml:"href,attr"`
+	Size        int64  `xml:"size,attr"`
+	SizeMb      int64  `xml:"sizeMb,attr"`
+	SharingType string `xml:"sharingType,attr"`
+	Description string `xml:"Description"`
+}
+
+type attachedVms struct {
+	VmReferences []*vmReference `xml:"VmReference"`
+}
+
+type vmReference struct {
+	Href string `xml:"href,attr"`
+	Id   string `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+}
+
+// ListDisks returns all disks of VDC with attached VM and meta, for tooling
+func (vdc *Vdc) ListDisks() ([]*VdcDisk, error) {
+	records, err := vdc.queryDisks("")
+	if err != nil {
+		return nil, err
+	}
+
+	vdcDisks := make([]*VdcDisk, 0, len(records))
+	for _, record := range records {
+		vdcDisk, err := vdc.recordToDisk(record)
+		if err != nil {
+			return nil, err
+		}
+		vdcDisks = append(vdcDisks, vdcDisk)
+	}
+
+	return vdcDisks, nil
+}
+
+// queryDisks returns disk records of this VDC matching filter, all pages are read.
+// filter is a FIQL expression, e.g. name==disk1, empty returns all disks
+func (vdc *Vdc) queryDisks(filter string) ([]*diskRecord, error) {
+	vdcFilter := "vdc==" + queryFilterValue(vdc.client.Vdc.HREF)
+	if filter != "" {
+		filter = filter + ";" + vdcFilter
+	} else {
+		filter = vdcFilter
+	}
+
+	queryUrl := vdc.vcdClient.Client.VCDHREF
+	queryUrl.Path = strings.TrimSuffix(queryUrl.Path, "/") + "/query"
+
+	var records []*diskRecord
+	for page := 1; ; page++ {
+		var result queryResultRecords
+		err := vdc.readXml(queryUrl, map[string]string{
+			"type":     "disk",
+			"format":   "records",
+			"filter":   filter,
+			"page":     strconv.Itoa(page),
+			"pageSize": strconv.Itoa(queryPageSize),
+		}, &result)
+		if err != nil {
+			return nil, errcode.Annotate(errcode.VcdApiFailed, err, "query disks")
+		}
+
+		records = append(records, result.DiskRecords...)
+
+		if len(result.DiskRecords) == 0 || len(records) >= result.Total {
+			break
+		}
+	}
+
+	return records, nil
+}
+
+// recordToDisk converts record to disk, disk meta is the description, attached VM is requested when disk is attached
+func (vdc *Vdc) recordToDisk(record *diskRecord) (*VdcDisk, error) {
+	size := record.SizeB
+	if size == 0 {
+		size = record.SizeMb * 1024 * 1024
+	}
+
+	vdcDisk := &VdcDisk{
+		Name:        record.Name,
+		Href:        record.Href,
+		Size:        int(size),
+		Description: record.Description,
+		SharingType: record.SharingType,
+	}
+
+	// query records have no id, disk href ends with its uuid
+	hrefArr := strings.Split(strings.TrimSuffix(record.Href, "/"), "/")
+	if uuid := DiskUuid(hrefArr[len(hrefArr)-1]); uuid != "" {
+		vdcDisk.Id = "urn:vcloud:disk:" + uuid
+	}
+
+	if diskMeta, err := vdc.DiskMeta(vdcDisk); err == nil {
+		vdcDisk.Meta = diskMeta
+	}
+
+	if !record.IsAttached {
+		return vdcDisk, nil
+	}
+
+	err := vdc.setAttachedVms(vdcDisk)
+	if err != nil {
+		return nil, err
+	}
+
+	return vdcDisk, nil
+}
+
+// setAttachedVms sets VMs which disk is attached to, a disk which is not shared is attached to one VM at most
+func (vdc *Vdc) setAttachedVms(disk *VdcDisk) error {
+	attachedVmUrl, err := url.Parse(strings.TrimSuffix(disk.Href, "/") + "/attachedVms")
+	if err != nil {
+		return errcode.Wrap(errcode.VcdApiFailed, err, "parse disk href")
+	}
+
+	var vms attachedVms
+	err = vdc.readXml(*attachedVmUrl, nil, &vms)
+	if err != nil {
+		return errcode.Annotate(errcode.VcdApiFailed, err, "attached VM")
+	}
+
+	disk.AttachedVms = nil
+	for _, vmReference := range vms.VmReferences {
+		disk.AttachedVms = append(disk.AttachedVms, &DiskAttachedVm{
+			Id:   vmReference.Id,
+			Name: vmReference.Name,
+		})
+	}
+
+	disk.AttachedVm = nil
+	if len(disk.AttachedVms) > 0 {
+		disk.AttachedVm = disk.AttachedVms[0]
+	}
+
+	return nil
+}
+
+// readXml is getXml with retry, a rejected session is logged in again
+func (vdc *Vdc) readXml(reqUrl url.URL, params map[string]string, v interface{}) error {
+	return vdc.read(func(ctx context.Context) error {
+		return vdc.WithContext(ctx).getXml(reqUrl, params, v)
+	})
+}
+
+func (vdc *Vdc) getXml(reqUrl url.URL, params map[string]string, v interface{}) error {
+	req := vdc.vcdClient.Client.NewRequest(params, http.MethodGet, reqUrl, nil)
+	req = req.WithContext(vdc.context())
+
+	resp, err := vdc.vcdClient.Client.Http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return newApiError(resp.StatusCode, body)
+	}
+
+	return xml.Unmarshal(body, v)
+}
+
+// queryFilterValue escapes FIQL reserved characters of value
+func queryFilterValue(value string) string {
+	replacer := strings.NewReplacer(
+		";", "%3B",
+		",", "%2C",
+		"(", "%28",
+		")", "%29",
+		"=", "%3D",
+	)
+
+	return replacer.Replace(value)
+}
@@ -0,0 +1,285 @@
+package vcd
+
+import (
+	"context"
+	"fmt"
+	"github.com/ty2/vcdfv/errcode"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Operation names, used as keys of RetryPolicy.Deadlines
+const (
+	OpCreateDisk = "createDisk"
+	OpUpdateDisk = "updateDisk"
+	OpAttachDisk = "attachDisk"
+	OpDetachDisk = "detachDisk"
+	OpDeleteDisk = "deleteDisk"
+	// lookups and queries of disks and VMs
+	OpRead = "read"
+)
+
+// vCD task status
+const (
+	taskStatusSuccess  = "success"
+	taskStatusError    = "error"
+	taskStatusAborted  = "aborted"
+	taskStatusCanceled = "canceled"
+)
+
+type RetryPolicy struct {
+	// total attempts of an operation, 1 means no retry
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// interval of polling task status
+	TaskPollInterval time.Duration
+	// deadline of an operation including all attempts and task waits
+	DefaultDeadline time.Duration
+	// deadline by operation name, e.g. OpAttachDisk
+	Deadlines map[string]time.Duration
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:      5,
+		InitialBackoff:   2 * time.Second,
+		MaxBackoff:       30 * time.Second,
+		Multiplier:       2,
+		TaskPollInterval: 2 * time.Second,
+		DefaultDeadline:  5 * time.Minute,
+	}
+}
+
+func (policy *RetryPolicy) deadline(operation string) time.Duration {
+	if deadline, ok := policy.Deadlines[operation]; ok && deadline > 0 {
+		return deadline
+	}
+
+	return policy.DefaultDeadline
+}
+
+// TaskError is a vCD task finished without success
+type TaskError struct {
+	Href    string
+	Status  string
+	Message string
+	// vCD error code of the task, e.g. BUSY_ENTITY
+	MinorErrorCode string
+}
+
+func (e *TaskError) Error() string {
+	if e.MinorErrorCode == "" {
+		return fmt.Sprintf("task %s %s: %s", e.Href, e.Status, e.Message)
+	}
+
+	return fmt.Sprintf("task %s %s: %s (%s)", e.Href, e.Status, e.Message, e.MinorErrorCode)
+}
+
+// permanentError stops retry, e.g. a failed task of a non idempotent request
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+type errorClass int
+
+const (
+	// do not retry
+	errorClassFatal errorClass = iota
+	// vCD rejected the request because the entity is busy or modified concurrently
+	errorClassBusy
+	// connection level error, the request may not have reached vCD
+	errorClassTransient
+	// session is gone, login again then retry
+	errorClassUnauthorized
+)
+
+// govcd drops the vCD error code, vCD answers a busy entity with 400 and this message
+var govcdBusyErrorRegexp = regexp.MustCompile(`API Error: 400: .* is busy completing an operation`)
+
+func classifyError(err error) errorClass {
+	if err == nil {
+		return errorClassFatal
+	}
+
+	// errors are classified through errcode and fmt wrapping
+	for _, cause := range errorChain(err) {
+		// an error of a request is an error of the connection, e.g. timeout of the HTTP client, it is classified below
+		if _, ok := cause.(*url.Error); ok {
+			break
+		}
+
+		if _, ok := cause.(*permanentError); ok {
+			return errorClassFatal
+		}
+
+		// deadline or cancellation of this invocation is never retried
+		if cause == context.DeadlineExceeded || cause == context.Canceled {
+			return errorClassFatal
+		}
+
+		if taskErr, ok := cause.(*TaskError); ok {
+			if taskErr.MinorErrorCode == minorErrorCodeBusyEntity {
+				return errorClassBusy
+			}
+			return errorClassFatal
+		}
+	}
+
+	if apiErr := apiErrorOf(err); apiErr != nil {
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized:
+			return errorClassUnauthorized
+		case apiErr.StatusCode == http.StatusConflict || apiErr.MinorErrorCode == minorErrorCodeBusyEntity:
+			return errorClassBusy
+		case apiErr.MinorErrorCode == "" && govcdBusyErrorRegexp.MatchString(apiErr.Message):
+			return errorClassBusy
+		case apiErr.StatusCode == http.StatusBadGateway || apiErr.StatusCode == http.StatusServiceUnavailable ||
+			apiErr.StatusCode == http.StatusGatewayTimeout:
+			return errorClassTransient
+		}
+		return errorClassFatal
+	}
+
+	for _, cause := range errorChain(err) {
+		if isConnectionError(cause) {
+			return errorClassTransient
+		}
+	}
+
+	return errorClassFatal
+}
+
+// errorChain returns err and its causes, outermost first. errcode errors have Cause, wrapping of fmt and
+// url errors have Unwrap
+func errorChain(err error) []error {
+	var chain []error
+	for err != nil {
+		chain = append(chain, err)
+		switch wrapper := err.(type) {
+		case interface{ Cause() error }:
+			err = wrapper.Cause()
+		case interface{ Unwrap() error }:
+			err = wrapper.Unwrap()
+		default:
+			err = nil
+		}
+	}
+
+	return chain
+}
+
+// isConnectionError reports whether err is an error of the connection to vCD, e.g. refused, reset or timed out
+func isConnectionError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	if _, ok := err.(*net.OpError); ok {
+		return true
+	}
+
+	// url.Error is a net.Error, only its cause tells a timeout of the connection
+	if _, ok := err.(*url.Error); ok {
+		return false
+	}
+
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// retry runs fn until it succeeds, returns fatal error, attempts are used up or deadline of the operation is reached.
+// transient errors are only retried when fn is idempotent, a lost response of e.g. create disk must not create it twice
+func (vdc *Vdc) retry(operation string, idempotent bool, fn func(ctx context.Context) error) error {
+	return vdc.retryContext(vdc.context(), operation, idempotent, fn)
+}
+
+// read runs fn of a lookup or query with retry, a read is idempotent
+func (vdc *Vdc) read(fn func(ctx context.Context) error) error {
+	return vdc.retry(OpRead, true, fn)
+}
+
+// retryContext is retry with deadline of the operation derived from parent
+func (vdc *Vdc) retryContext(parent context.Context, operation string, idempotent bool, fn func(ctx context.Context) error) error {
+	policy := vdc.retryPolicy()
+
+	ctx, cancel := context.WithTimeout(parent, policy.deadline(operation))
+	defer cancel()
+
+	backoff := policy.InitialBackoff
+	reloggedIn := false
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		// deadline reached or canceled while running fn
+		if ctx.Err() != nil {
+			return deadlineError(ctx, operation, attempt, err)
+		}
+
+		switch classifyError(err) {
+		case errorClassBusy:
+		case errorClassTransient:
+			if !idempotent {
+				return err
+			}
+		case errorClassUnauthorized:
+			// login once, the session may be expired in vCD
+			if reloggedIn {
+				return err
+			}
+			if !vdc.config.hasCredential() {
+				vdc.dropSession()
+				return errcode.Wrap(errcode.AuthFailed, err, operation+": session is rejected and there is no credential to login again")
+			}
+			if reloginErr := vdc.relogin(); reloginErr != nil {
+				return errcode.Wrap(errcode.AuthFailed, reloginErr, fmt.Sprintf("%s, relogin", err.Error()))
+			}
+			reloggedIn = true
+		default:
+			if permanentErr, ok := err.(*permanentError); ok {
+				return permanentErr.err
+			}
+			return err
+		}
+
+		if attempt >= policy.MaxAttempts {
+			code := errcode.VcdApiFailed
+			if classifyError(err) == errorClassBusy {
+				code = errcode.Busy
+			}
+			return errcode.Wrap(code, err, fmt.Sprintf("%s: gave up after %d attempt(s)", operation, attempt))
+		}
+
+		select {
+		case <-ctx.Done():
+			return deadlineError(ctx, operation, attempt, err)
+		case <-time.After(backoff):
+		}
+
+		backoff = time.Duration(float64(backoff) * policy.Multiplier)
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func deadlineError(ctx context.Context, operation string, attempt int, err error) error {
+	code := errcode.Timeout
+	if ctx.Err() == context.Canceled {
+		code = errcode.Canceled
+	}
+
+	return errcode.Wrap(code, err, fmt.Sprintf("%s: %s after %d attempt(s)", operation, ctx.Err().Error(), attempt))
+}
@@ -0,0 +1,351 @@
+package vcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/vmware/go-vcloud-director/govcd"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorClass
+	}{
+		{"nil", nil, errorClassFatal},
+		{"permanent", &permanentError{err: &ApiError{StatusCode: 503}}, errorClassFatal},
+		{"deadline", context.DeadlineExceeded, errorClassFatal},
+		{"canceled", context.Canceled, errorClassFatal},
+		{"401 response", &ApiError{StatusCode: 401, Message: "unauthorized"}, errorClassUnauthorized},
+		{"401 of govcd", errors.New("API Error: 401: [ 1234 ] This operation is denied."), errorClassUnauthorized},
+		{"busy entity response", &ApiError{StatusCode: 400, MinorErrorCode: "BUSY_ENTITY", Message: "busy"}, errorClassBusy},
+		{"409 response", &ApiError{StatusCode: 409, Message: "modified concurrently"}, errorClassBusy},
+		{"busy of govcd", errors.New(`API Error: 400: [ 1234 ] The entity Disk "d1" is busy completing an operation.`), errorClassBusy},
+		{"busy entity task", &TaskError{Status: "error", MinorErrorCode: "BUSY_ENTITY"}, errorClassBusy},
+		{"annotated busy entity task", errcode.Annotate(errcode.AttachFailed, &TaskError{Status: "error", MinorErrorCode: "BUSY_ENTITY"}, "attach disk"), errorClassBusy},
+		{"fmt wrapped busy entity task", fmt.Errorf("wait task: %w", &TaskError{Status: "error", MinorErrorCode: "BUSY_ENTITY"}), errorClassBusy},
+		{"annotated failed task", errcode.Annotate(errcode.AttachFailed, &TaskError{Status: "error", MinorErrorCode: "INTERNAL_SERVER_ERROR"}, "attach disk"), errorClassFatal},
+		{"fmt wrapped 503 response", fmt.Errorf("query: %w", &ApiError{StatusCode: 503}), errorClassTransient},
+		{"annotated deadline", errcode.Annotate(errcode.Timeout, context.DeadlineExceeded, "wait task"), errorClassFatal},
+		{"client timeout", &url.Error{Op: "Get", URL: "https://vcd/api", Err: fmt.Errorf("client timeout: %w", context.DeadlineExceeded)}, errorClassTransient},
+		{"annotated eof", errcode.Annotate(errcode.VcdApiFailed, &url.Error{Op: "Get", URL: "https://vcd/api", Err: io.EOF}, "get disk"), errorClassTransient},
+		{"failed task", &TaskError{Status: "error", Message: "disk is locked, try again", MinorErrorCode: "INTERNAL_SERVER_ERROR"}, errorClassFatal},
+		{"502 response", &ApiError{StatusCode: 502}, errorClassTransient},
+		{"503 of govcd", errors.New("API Error: 503: unavailable"), errorClassTransient},
+		{"504 response", &ApiError{StatusCode: 504}, errorClassTransient},
+		{"wrapped 503 response", errcode.Annotate(errcode.VcdApiFailed, &ApiError{StatusCode: 503}, "query disks"), errorClassTransient},
+		{"400 response", &ApiError{StatusCode: 400, MinorErrorCode: "BAD_REQUEST", Message: "disk is busy"}, errorClassFatal},
+		{"500 of govcd", errors.New("API Error: 500: internal error"), errorClassFatal},
+		{"eof", &url.Error{Op: "Get", URL: "https://vcd/api/disk/1", Err: io.EOF}, errorClassTransient},
+		{"connection refused", &url.Error{Op: "Get", URL: "https://vcd/api", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, errorClassTransient},
+		// status codes, error codes and words in hrefs, uuids and names are not classified
+		{"401 in href", errors.New("task https://vcd/api/task/401 error"), errorClassFatal},
+		{"503 in uuid", errors.New("disk 503e4f0a-0000-4000-8000-000000000502 failed"), errorClassFatal},
+		{"eof in name", errors.New("disk geoffrey failed"), errorClassFatal},
+		{"locked in name", errors.New("disk unlocked-data failed"), errorClassFatal},
+		{"busy in message of 500", errors.New("API Error: 500: disk busybox is broken"), errorClassFatal},
+	}
+
+	for _, test := range tests {
+		if got := classifyError(test.err); got != test.want {
+			t.Errorf("%s: classifyError(%v) = %d, want %d", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+type fakeResponse struct {
+	statusCode int
+	body       string
+}
+
+// fakeTaskServer is a vCD serving tasks and metadata of disks, a path answers its responses in order and then
+// repeats the last one
+type fakeTaskServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses map[string][]fakeResponse
+	requests  map[string]int
+}
+
+func newFakeTaskServer() *fakeTaskServer {
+	server := &fakeTaskServer{responses: map[string][]fakeResponse{}, requests: map[string]int{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		key := r.Method + " " + r.URL.Path
+		n := server.requests[key]
+		server.requests[key]++
+
+		responses := server.responses[key]
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error majorErrorCode="404" minorErrorCode="RESOURCE_NOT_FOUND" message="not found"/>`)
+			return
+		}
+
+		if n >= len(responses) {
+			n = len(responses) - 1
+		}
+		w.WriteHeader(responses[n].statusCode)
+		fmt.Fprint(w, strings.Replace(responses[n].body, "{{url}}", server.URL, -1))
+	}))
+
+	return server
+}
+
+func (server *fakeTaskServer) on(method string, path string, responses ...fakeResponse) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.responses[method+" "+path] = responses
+}
+
+func (server *fakeTaskServer) count(method string, path string) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.requests[method+" "+path]
+}
+
+func (server *fakeTaskServer) vdc() *Vdc {
+	apiUrl, _ := url.Parse(server.URL + "/api")
+	return &Vdc{
+		vcdClient: &govcd.VCDClient{Client: govcd.Client{VCDHREF: *apiUrl, Http: *server.Client()}},
+		config: &VcdConfig{RetryPolicy: &RetryPolicy{
+			MaxAttempts:      3,
+			InitialBackoff:   time.Millisecond,
+			MaxBackoff:       time.Millisecond,
+			Multiplier:       2,
+			TaskPollInterval: time.Millisecond,
+			DefaultDeadline:  5 * time.Second,
+		}},
+	}
+}
+
+func taskResponse(statusCode int, name string, status string) fakeResponse {
+	return fakeResponse{statusCode, fmt.Sprintf(`<Task href="{{url}}/api/task/%s" status="%s"/>`, name, status)}
+}
+
+func failedTaskResponse(name string, minorErrorCode string) fakeResponse {
+	return fakeResponse{http.StatusOK, fmt.Sprintf(`<Task href="{{url}}/api/task/%s" status="error">`+
+		`<Error majorErrorCode="400" minorErrorCode="%s" message="failed"/></Task>`, name, minorErrorCode)}
+}
+
+func TestWaitTask(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []fakeResponse
+		timeout   time.Duration
+		wantPolls int
+		check     func(err error) bool
+	}{
+		{
+			name:      "success",
+			responses: []fakeResponse{taskResponse(200, "t", "running"), taskResponse(200, "t", "success")},
+			wantPolls: 2,
+			check:     func(err error) bool { return err == nil },
+		},
+		{
+			name:      "error with code",
+			responses: []fakeResponse{taskResponse(200, "t", "queued"), failedTaskResponse("t", "BUSY_ENTITY")},
+			wantPolls: 2,
+			check: func(err error) bool {
+				taskErr, ok := err.(*TaskError)
+				return ok && taskErr.MinorErrorCode == "BUSY_ENTITY" && classifyError(err) == errorClassBusy
+			},
+		},
+		{
+			name:      "refresh unavailable",
+			responses: []fakeResponse{{503, "unavailable"}},
+			wantPolls: 1,
+			check:     func(err error) bool { return classifyError(err) == errorClassTransient },
+		},
+		{
+			name:      "deadline",
+			responses: []fakeResponse{taskResponse(200, "t", "running")},
+			timeout:   20 * time.Millisecond,
+			check:     func(err error) bool { return errcode.Is(err, errcode.Timeout) },
+		},
+	}
+
+	for _, test := range tests {
+		server := newFakeTaskServer()
+		server.on(http.MethodGet, "/api/task/t", test.responses...)
+
+		ctx := context.Background()
+		if test.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, test.timeout)
+			defer cancel()
+		}
+
+		var taskHrefs []string
+		ctx = context.WithValue(ctx, taskHrefsKey{}, &taskHrefs)
+
+		task := &xmlTask{vdc: server.vdc(), task: taskXml{Href: server.URL + "/api/task/t", Status: "running"}}
+		err := server.vdc().waitTask(ctx, task)
+		if !test.check(err) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if polls := server.count(http.MethodGet, "/api/task/t"); test.wantPolls > 0 && polls != test.wantPolls {
+			t.Errorf("%s: %d polls, want %d", test.name, polls, test.wantPolls)
+		}
+		if len(taskHrefs) != 1 || taskHrefs[0] != server.URL+"/api/task/t" {
+			t.Errorf("%s: recorded tasks %v", test.name, taskHrefs)
+		}
+
+		server.Close()
+	}
+}
+
+func TestRetryTask(t *testing.T) {
+	const holderPath = "/api/disk/d1/metadata/vcdfv.holder.vm1"
+
+	tests := []struct {
+		name         string
+		method       string
+		responses    []fakeResponse
+		tasks        map[string][]fakeResponse
+		wantCode     string
+		wantRequests int
+		wantTasks    int
+	}{
+		{
+			name:   "busy task is retried",
+			method: http.MethodPut,
+			responses: []fakeResponse{
+				taskResponse(202, "a", "running"),
+				taskResponse(202, "b", "running"),
+			},
+			tasks: map[string][]fakeResponse{
+				"/api/task/a": {failedTaskResponse("a", "BUSY_ENTITY")},
+				"/api/task/b": {taskResponse(200, "b", "success")},
+			},
+			wantRequests: 2,
+			wantTasks:    2,
+		},
+		{
+			name:         "unavailable is retried",
+			method:       http.MethodPut,
+			responses:    []fakeResponse{{503, ""}, taskResponse(202, "a", "success")},
+			wantRequests: 2,
+			wantTasks:    1,
+		},
+		{
+			name:         "failed task is not retried",
+			method:       http.MethodPut,
+			responses:    []fakeResponse{taskResponse(202, "a", "running")},
+			tasks:        map[string][]fakeResponse{"/api/task/a": {failedTaskResponse("a", "INTERNAL_SERVER_ERROR")}},
+			wantCode:     errcode.MetaFailed,
+			wantRequests: 1,
+			wantTasks:    1,
+		},
+		{
+			name:   "forbidden is not retried",
+			method: http.MethodPut,
+			responses: []fakeResponse{{403, `<Error majorErrorCode="403" ` +
+				`minorErrorCode="ACCESS_TO_RESOURCE_IS_FORBIDDEN" message="forbidden"/>`}},
+			wantCode:     errcode.MetaFailed,
+			wantRequests: 1,
+		},
+		{
+			name:         "busy entity gives up",
+			method:       http.MethodPut,
+			responses:    []fakeResponse{{400, `<Error majorErrorCode="400" minorErrorCode="BUSY_ENTITY" message="busy"/>`}},
+			wantCode:     errcode.Busy,
+			wantRequests: 3,
+		},
+		{
+			name:         "remove holder which is not found",
+			method:       http.MethodDelete,
+			wantRequests: 1,
+		},
+		{
+			name:         "remove holder which is forbidden",
+			method:       http.MethodDelete,
+			responses:    []fakeResponse{{403, `<Error majorErrorCode="403" message="forbidden"/>`}},
+			wantCode:     errcode.MetaFailed,
+			wantRequests: 1,
+		},
+	}
+
+	for _, test := range tests {
+		server := newFakeTaskServer()
+		server.on(test.method, holderPath, test.responses...)
+		for path, responses := range test.tasks {
+			server.on(http.MethodGet, path, responses...)
+		}
+
+		var mutations []*Mutation
+		vdc := server.vdc().WithAudit(func(mutation *Mutation) {
+			mutations = append(mutations, mutation)
+		})
+
+		disk := &VdcDisk{Name: "d1", Href: server.URL + "/api/disk/d1"}
+		var err error
+		if test.method == http.MethodDelete {
+			err = vdc.RemoveDiskHolder(disk, "vm1")
+		} else {
+			err = vdc.AddDiskHolder(disk, "vm1")
+		}
+
+		if test.wantCode == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		} else if test.wantCode != "" && errcode.CodeOf(err) != test.wantCode {
+			t.Errorf("%s: error %v, want code %s", test.name, err, test.wantCode)
+		}
+		if requests := server.count(test.method, holderPath); requests != test.wantRequests {
+			t.Errorf("%s: %d requests, want %d", test.name, requests, test.wantRequests)
+		}
+		if len(mutations) != 1 || len(mutations[0].TaskHrefs) != test.wantTasks {
+			t.Errorf("%s: mutations %+v, want one with %d task(s)", test.name, mutations, test.wantTasks)
+		}
+
+		server.Close()
+	}
+}
+
+func TestReadRetry(t *testing.T) {
+	const diskPath = "/api/disk/d1/metadata"
+
+	tests := []struct {
+		name         string
+		responses    []fakeResponse
+		wantCode     string
+		wantRequests int
+	}{
+		{"ok", []fakeResponse{{200, `<Metadata/>`}}, "", 1},
+		{"unavailable is retried", []fakeResponse{{503, ""}, {200, `<Metadata/>`}}, "", 2},
+		{"not found is not retried", nil, errcode.VcdApiFailed, 1},
+		// session restored without credential cannot login again
+		{"unauthorized without credential", []fakeResponse{{401, ""}}, errcode.AuthFailed, 1},
+	}
+
+	for _, test := range tests {
+		server := newFakeTaskServer()
+		server.on(http.MethodGet, diskPath, test.responses...)
+
+		_, err := server.vdc().DiskMetadata(&VdcDisk{Name: "d1", Href: server.URL + "/api/disk/d1"})
+		if test.wantCode == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		} else if test.wantCode != "" && errcode.CodeOf(err) != test.wantCode {
+			t.Errorf("%s: error %v, want code %s", test.name, err, test.wantCode)
+		}
+		if requests := server.count(http.MethodGet, diskPath); requests != test.wantRequests {
+			t.Errorf("%s: %d requests, want %d", test.name, requests, test.wantRequests)
+		}
+
+		server.Close()
+	}
+}
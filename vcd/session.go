@@ -0,0 +1,157 @@
+package vcd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultSessionTtl is shorter than the default vCD session idle timeout (30 minutes)
+const DefaultSessionTtl = 25 * time.Minute
+
+// SessionCache stores authenticated vCD sessions on disk, so later driver invocations can skip login, org and VDC lookup
+type SessionCache struct {
+	Dir string
+	// session is dropped when it is not used within Ttl
+	Ttl time.Duration
+	// a cached session is reused but the cache is never written, e.g. by a dry run
+	ReadOnly bool
+}
+
+type session struct {
+	Endpoint   string    `json:"endpoint"`
+	User       string    `json:"user"`
+	Org        string    `json:"org"`
+	Vdc        string    `json:"vdc"`
+	AuthHeader string    `json:"authHeader"`
+	Token      string    `json:"token"`
+	ApiVersion string    `json:"apiVersion"`
+	VdcHref    string    `json:"vdcHref"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+func (cache *SessionCache) ttl() time.Duration {
+	if cache.Ttl <= 0 {
+		return DefaultSessionTtl
+	}
+
+	return cache.Ttl
+}
+
+// path of session file, keyed by endpoint, user and org
+func (cache *SessionCache) path(endpoint string, user string, org string) string {
+	sum := sha256.Sum256([]byte(endpoint + "\x00" + user + "\x00" + org))
+	return filepath.Join(cache.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// ensureDir makes sure the cache dir exists and only root (the owner) can access it
+func (cache *SessionCache) ensureDir() error {
+	if cache.Dir == "" {
+		return errors.New("session cache dir is empty")
+	}
+
+	err := os.MkdirAll(cache.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(cache.Dir, 0700)
+}
+
+func (cache *SessionCache) load(endpoint string, user string, org string) (*session, error) {
+	path := cache.path(endpoint, user, org)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// refuse session file which is readable by others
+	if info.Mode().Perm()&0077 != 0 {
+		cache.remove(path)
+		return nil, errors.New(fmt.Sprintf("session file %s has insecure mode %s", path, info.Mode().Perm()))
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s *session
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		cache.remove(path)
+		return nil, err
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		cache.remove(path)
+		return nil, errors.New("session expired")
+	}
+
+	return s, nil
+}
+
+func (cache *SessionCache) save(s *session) error {
+	if cache.ReadOnly {
+		return nil
+	}
+
+	if err := cache.ensureDir(); err != nil {
+		return err
+	}
+
+	s.ExpiresAt = time.Now().Add(cache.ttl())
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	// write to temp file then rename, never leave a partial session file
+	tmpFile, err := ioutil.TempFile(cache.Dir, ".session-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if err = tmpFile.Chmod(0600); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if _, err = tmpFile.Write(b); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), cache.path(s.Endpoint, s.User, s.Org))
+}
+
+func (cache *SessionCache) delete(endpoint string, user string, org string) error {
+	return cache.remove(cache.path(endpoint, user, org))
+}
+
+func (cache *SessionCache) remove(path string) error {
+	if cache.ReadOnly {
+		return nil
+	}
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
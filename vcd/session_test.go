@@ -0,0 +1,61 @@
+package vcd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSessionCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		readOnly  bool
+		expired   bool
+		wantSaved bool
+		wantLoad  bool
+		wantFile  bool
+	}{
+		{name: "saved and loaded", wantSaved: true, wantLoad: true, wantFile: true},
+		{name: "expired session is removed", expired: true, wantSaved: true, wantFile: false},
+		{name: "read only never saves", readOnly: true},
+		{name: "read only keeps expired session", readOnly: true, expired: true, wantSaved: true, wantFile: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "vcdfv-session-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			s := &session{Endpoint: "https://vcd/api", User: "user", Org: "org", Vdc: "vdc", VdcHref: "https://vcd/api/vdc/1"}
+			writer := &SessionCache{Dir: dir}
+			if tt.expired {
+				writer.Ttl = time.Nanosecond
+			}
+			cache := &SessionCache{Dir: dir, ReadOnly: tt.readOnly}
+
+			// a session saved by a writable cache
+			if tt.wantSaved {
+				if err := writer.save(s); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(time.Millisecond)
+			} else if err := cache.save(s); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := cache.load(s.Endpoint, s.User, s.Org)
+			if tt.wantLoad != (err == nil) {
+				t.Errorf("load() = %v, %v, want loaded %v", loaded, err, tt.wantLoad)
+			}
+
+			_, err = os.Stat(cache.path(s.Endpoint, s.User, s.Org))
+			if tt.wantFile != (err == nil) {
+				t.Errorf("session file exists %v, want %v", err == nil, tt.wantFile)
+			}
+		})
+	}
+}
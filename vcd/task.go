@@ -0,0 +1,128 @@
+package vcd
+
+import (
+	"context"
+	"errors"
+	"github.com/vmware/go-vcloud-director/govcd"
+	"net/url"
+	"time"
+)
+
+// task is a vCD task which waitTask polls until it is finished
+type task interface {
+	// state of the last refresh
+	state() taskState
+	refresh(ctx context.Context) error
+}
+
+type taskState struct {
+	Href           string
+	Status         string
+	Message        string
+	MinorErrorCode string
+}
+
+// govcdTask is a task returned by govcd
+type govcdTask struct {
+	task *govcd.Task
+}
+
+func (t govcdTask) state() taskState {
+	if t.task == nil || t.task.Task == nil {
+		return taskState{}
+	}
+
+	state := taskState{Href: t.task.Task.HREF, Status: t.task.Task.Status}
+	if t.task.Task.Error != nil {
+		state.Message = t.task.Task.Error.Message
+		state.MinorErrorCode = t.task.Task.Error.MinorErrorCode
+	}
+
+	return state
+}
+
+// refresh of govcd cannot be canceled, waitTask checks ctx between refreshes
+func (t govcdTask) refresh(ctx context.Context) error {
+	return t.task.Refresh()
+}
+
+type taskXml struct {
+	Href   string `xml:"href,attr"`
+	Status string `xml:"status,attr"`
+	Error  *struct {
+		Message        string `xml:"message,attr"`
+		MinorErrorCode string `xml:"minorErrorCode,attr"`
+	} `xml:"Error"`
+}
+
+// xmlTask is a task of a response which is not handled by govcd, e.g. of metadata
+type xmlTask struct {
+	vdc  *Vdc
+	task taskXml
+}
+
+func (t *xmlTask) state() taskState {
+	state := taskState{Href: t.task.Href, Status: t.task.Status}
+	if t.task.Error != nil {
+		state.Message = t.task.Error.Message
+		state.MinorErrorCode = t.task.Error.MinorErrorCode
+	}
+
+	return state
+}
+
+func (t *xmlTask) refresh(ctx context.Context) error {
+	taskUrl, err := url.Parse(t.task.Href)
+	if err != nil {
+		return err
+	}
+
+	href := t.task.Href
+	var refreshed taskXml
+	if err := t.vdc.WithContext(ctx).getXml(*taskUrl, nil, &refreshed); err != nil {
+		return err
+	}
+	if refreshed.Href == "" {
+		refreshed.Href = href
+	}
+	t.task = refreshed
+
+	return nil
+}
+
+// waitTask polls task until it is finished or ctx is done
+func (vdc *Vdc) waitTask(ctx context.Context, task task) error {
+	for {
+		state := task.state()
+		recordTask(ctx, state.Href)
+
+		switch state.Status {
+		case taskStatusSuccess:
+			return nil
+		case taskStatusError, taskStatusAborted, taskStatusCanceled:
+			return &TaskError{
+				Href:           state.Href,
+				Status:         state.Status,
+				Message:        state.Message,
+				MinorErrorCode: state.MinorErrorCode,
+			}
+		}
+
+		if state.Href == "" {
+			return errors.New("task is empty")
+		}
+
+		select {
+		case <-ctx.Done():
+			return deadlineError(ctx, "wait task "+state.Href, 1, ctx.Err())
+		case <-time.After(vdc.retryPolicy().TaskPollInterval):
+		}
+
+		if err := task.refresh(ctx); err != nil {
+			if ctx.Err() != nil {
+				return deadlineError(ctx, "wait task "+state.Href, 1, err)
+			}
+			return err
+		}
+	}
+}
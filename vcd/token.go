@@ -0,0 +1,77 @@
+package vcd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vmware/go-vcloud-director/govcd"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// exchangeRefreshToken exchanges a vCD API token (refresh token) for an access token
+func exchangeRefreshToken(client *govcd.VCDClient, endpoint *url.URL, org string, refreshToken string) (string, error) {
+	tokenUrl := url.URL{
+		Scheme: endpoint.Scheme,
+		Host:   endpoint.Host,
+	}
+
+	// system administrator uses provider endpoint
+	if strings.EqualFold(org, "system") {
+		tokenUrl.Path = "/oauth/provider/token"
+	} else {
+		tokenUrl.Path = fmt.Sprintf("/oauth/tenant/%s/token", url.PathEscape(org))
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+
+	req, err := http.NewRequest(http.MethodPost, tokenUrl.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Client.Http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("unexpected status %s: %s", resp.Status, string(body)))
+	}
+
+	var token *oauthTokenResponse
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", err
+	}
+
+	if token == nil || token.AccessToken == "" {
+		return "", errors.New("empty access token")
+	}
+
+	return token.AccessToken, nil
+}
+
+// setBearerToken makes every request of the client carry the access token
+func setBearerToken(client *govcd.VCDClient, accessToken string) {
+	client.Client.VCDAuthHeader = "Authorization"
+	client.Client.VCDToken = "Bearer " + accessToken
+}
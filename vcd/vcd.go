@@ -3,12 +3,16 @@
 package vcd
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/ty2/vcdfv/errcode"
 	"github.com/vmware/go-vcloud-director/govcd"
 	"github.com/vmware/go-vcloud-director/types/v56"
 	"net/url"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -17,8 +21,16 @@ type VcdConfig struct {
 	Insecure    bool
 	User        string
 	Password    string
-	Org         string
-	Vdc         string
+	// ApiToken is used as bearer token, RefreshToken is exchanged for a bearer token.
+	// User and Password are used when both are empty
+	ApiToken     string
+	RefreshToken string
+	Org          string
+	Vdc          string
+	// nil disables session reuse
+	SessionCache *SessionCache
+	// nil uses DefaultRetryPolicy
+	RetryPolicy *RetryPolicy
 }
 
 type VdcVApp struct {
@@ -40,14 +52,42 @@ type VdcDisk struct {
 	Size        int
 	Description string
 	Meta        *VdcDiskMeta
+	// the first of AttachedVms, a shared disk is attached to many VMs
 	AttachedVm  *DiskAttachedVm
+	AttachedVms []*DiskAttachedVm
+	// DiskSharingTypeShared when disk can be attached to many VMs
+	SharingType string
+}
+
+// sharing type of a disk which vCD attaches to many VMs
+const DiskSharingTypeShared = "DiskSharing"
+
+// AttachedTo reports whether disk is attached to VM of vmName
+func (disk *VdcDisk) AttachedTo(vmName string) bool {
+	for _, vm := range disk.AttachedVms {
+		if vm.Name == vmName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Shared reports whether disk can be attached to many VMs
+func (disk *VdcDisk) Shared() bool {
+	return disk.SharingType == DiskSharingTypeShared
 }
 
 type VdcDiskMeta struct {
-	VmName     string    `json:"vmName"`
-	DeviceName string    `json:"deviceName"`
-	CreatedAt  time.Time `json:"createdAt"`
-	UpdatedAt  time.Time `json:"updatedAt"`
+	VmName     string `json:"vmName"`
+	DeviceName string `json:"deviceName"`
+	// filesystem label and UUID, label is derived from disk name because ext4 label is limited to 16 bytes
+	FsLabel string `json:"fsLabel,omitempty"`
+	FsUuid  string `json:"fsUuid,omitempty"`
+	// clusterId of config of the cluster which owns the disk, empty when disk is not owned
+	ClusterId string    `json:"clusterId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 type DiskAttachedVm struct {
@@ -58,13 +98,24 @@ type DiskAttachedVm struct {
 type Vdc struct {
 	vcdClient *govcd.VCDClient
 	client    *govcd.Vdc
+	config    *VcdConfig
+	ctx       context.Context
+	// nil when mutations are not audited
+	audit AuditFunc
 }
 
 type DiskOpFn func(params *types.DiskAttachOrDetachParams) (govcd.Task, error)
 
 func NewVdc(config *VcdConfig) (*Vdc, error) {
 	// init VCD
-	vdc := &Vdc{}
+	vdc := &Vdc{config: config}
+
+	// reuse session of previous invocation, login again when it is missing, expired or rejected by VCD
+	if config.SessionCache != nil {
+		if err := vdc.restoreSession(config); err == nil {
+			return vdc, nil
+		}
+	}
 
 	// login to VCD
 	client, err := vdc.connect(config)
@@ -78,42 +129,179 @@ func NewVdc(config *VcdConfig) (*Vdc, error) {
 	// get VDC info
 	org, err := govcd.GetOrgByName(client, config.Org)
 	if err != nil {
-		return nil, err
+		return nil, errcode.Annotate(errcode.VcdApiFailed, err, "get org "+config.Org)
 	}
 
 	// get VDC client
 	vdcClient, err := org.GetVdcByName(config.Vdc)
 	if err != nil {
-		return nil, err
+		return nil, errcode.Annotate(errcode.VcdApiFailed, err, "get VDC "+config.Vdc)
 	}
 
 	// assign VDC client
 	vdc.client = &vdcClient
 
+	// cache session for later invocations, best effort
+	vdc.saveSession()
+
 	return vdc, nil
 }
 
+// WithContext returns a shallow copy of vdc, its operations are canceled when ctx is done
+func (vdc *Vdc) WithContext(ctx context.Context) *Vdc {
+	vdcCopy := *vdc
+	vdcCopy.ctx = ctx
+	return &vdcCopy
+}
+
+func (vdc *Vdc) context() context.Context {
+	if vdc.ctx == nil {
+		return context.Background()
+	}
+
+	return vdc.ctx
+}
+
+func (vdc *Vdc) retryPolicy() *RetryPolicy {
+	if vdc.config == nil || vdc.config.RetryPolicy == nil {
+		return DefaultRetryPolicy()
+	}
+
+	return vdc.config.RetryPolicy
+}
+
+func (vdc *Vdc) saveSession() {
+	config := vdc.config
+	if config.SessionCache == nil {
+		return
+	}
+
+	config.SessionCache.save(&session{
+		Endpoint:   config.ApiEndpoint,
+		User:       config.User,
+		Org:        config.Org,
+		Vdc:        config.Vdc,
+		AuthHeader: vdc.vcdClient.Client.VCDAuthHeader,
+		Token:      vdc.vcdClient.Client.VCDToken,
+		ApiVersion: vdc.vcdClient.Client.APIVersion,
+		VdcHref:    vdc.client.Vdc.HREF,
+		CreatedAt:  time.Now(),
+	})
+}
+
+// dropSession deletes cached session, e.g. it is rejected by vCD
+func (vdc *Vdc) dropSession() {
+	if vdc.config.SessionCache != nil {
+		vdc.config.SessionCache.delete(vdc.config.ApiEndpoint, vdc.config.User, vdc.config.Org)
+	}
+}
+
+// relogin replaces the token of current client, org and VDC clients share the same client
+func (vdc *Vdc) relogin() error {
+	vdc.dropSession()
+
+	client, err := vdc.connect(vdc.config)
+	if err != nil {
+		return err
+	}
+
+	vdc.vcdClient.Client.VCDAuthHeader = client.Client.VCDAuthHeader
+	vdc.vcdClient.Client.VCDToken = client.Client.VCDToken
+
+	vdc.saveSession()
+
+	return nil
+}
+
+func (vdc *Vdc) restoreSession(config *VcdConfig) error {
+	s, err := config.SessionCache.load(config.ApiEndpoint, config.User, config.Org)
+	if err != nil {
+		return err
+	}
+
+	if s.Vdc != config.Vdc || s.VdcHref == "" {
+		config.SessionCache.delete(config.ApiEndpoint, config.User, config.Org)
+		return errors.New("session is not for this VDC")
+	}
+
+	u, err := url.ParseRequestURI(config.ApiEndpoint)
+	if err != nil {
+		return fmt.Errorf("unable to parse url: %s", err)
+	}
+
+	client := govcd.NewVCDClient(*u, config.Insecure)
+	client.Client.VCDAuthHeader = s.AuthHeader
+	client.Client.VCDToken = s.Token
+	if s.ApiVersion != "" {
+		client.Client.APIVersion = s.ApiVersion
+	}
+
+	// get VDC by cached href, it is the only round trip and fails with 401 when VCD dropped the session
+	vdcClient := govcd.NewVdc(&client.Client)
+	vdcClient.Vdc.HREF = s.VdcHref
+	err = vdcClient.Refresh()
+	if err != nil {
+		config.SessionCache.delete(config.ApiEndpoint, config.User, config.Org)
+		return err
+	}
+
+	vdc.vcdClient = client
+	vdc.client = vdcClient
+
+	// extend expiry
+	config.SessionCache.save(s)
+
+	return nil
+}
+
+// hasCredential is false for a config which only reuses a cached session, it cannot login again
+func (config *VcdConfig) hasCredential() bool {
+	return config.Password != "" || config.ApiToken != "" || config.RefreshToken != ""
+}
+
 func (vdc *Vdc) connect(config *VcdConfig) (*govcd.VCDClient, error) {
+	if !config.hasCredential() {
+		return nil, errcode.New(errcode.AuthFailed, "no credential to login")
+	}
+
 	// Parse API endpoint
 	u, err := url.ParseRequestURI(config.ApiEndpoint)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse url: %s", err)
+		return nil, errcode.Wrap(errcode.InvalidArgument, err, "unable to parse url")
 	}
 
 	client := govcd.NewVCDClient(*u, config.Insecure)
 
+	// token login
+	if config.ApiToken != "" || config.RefreshToken != "" {
+		accessToken := config.ApiToken
+		if accessToken == "" {
+			accessToken, err = exchangeRefreshToken(client, u, config.Org, config.RefreshToken)
+			if err != nil {
+				return nil, errcode.Wrap(errcode.AuthFailed, err, "exchange refresh token")
+			}
+		}
+
+		setBearerToken(client, accessToken)
+		return client, nil
+	}
+
 	err = client.Authenticate(config.User, config.Password, config.Org)
 	if err != nil {
-		return nil, err
+		return nil, errcode.Wrap(errcode.AuthFailed, err, "authenticate "+config.User)
 	}
 
 	return client, nil
 }
 
 func (vdc *Vdc) FindVmByVAppNameAndVmName(vAppName string, vmName string) (*VAppVm, error) {
-	vApp, err := vdc.client.FindVAppByName(vAppName)
+	var vApp govcd.VApp
+	err := vdc.read(func(ctx context.Context) (err error) {
+		vApp, err = vdc.client.FindVAppByName(vAppName)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, findVAppError(err, vAppName)
 	}
 
 	var vAppVm *VAppVm
@@ -128,88 +316,193 @@ func (vdc *Vdc) FindVmByVAppNameAndVmName(vAppName string, vmName string) (*VApp
 	}
 
 	if vAppVm == nil {
-		return nil, errors.New("not found")
+		return nil, errcode.Newf(errcode.NotFound, "VM %s not found in vApp %s", vmName, vAppName)
 	}
 
 	return vAppVm, nil
 }
 
+// findVAppError annotates an error of FindVAppByName, it is not found only when the vApp does not exist
+func findVAppError(err error, vAppName string) error {
+	if isVAppNotFound(err) {
+		return errcode.Wrap(errcode.NotFound, err, "vApp "+vAppName+" not found")
+	}
+
+	return errcode.Annotate(errcode.VcdApiFailed, err, "find vApp "+vAppName)
+}
+
 func (vdc *Vdc) FindDiskByDiskName(diskName string) (*VdcDisk, error) {
-	err := vdc.client.Refresh()
+	vdcDisks, err := vdc.FindDisksByDiskName(diskName)
 	if err != nil {
 		return nil, err
 	}
 
-	var vdcDisk *VdcDisk
-	for _, res := range vdc.client.Vdc.ResourceEntities {
-		for _, item := range res.ResourceEntity {
-			if item.Type == types.MimeDisk && item.Name == diskName {
-				if vdcDisk != nil {
-					return nil, errors.New(fmt.Sprintf("duplicate disk found, %s", diskName))
-				}
-
-				disk, err := vdc.client.FindDiskByHREF(item.HREF)
-				if err != nil {
-					return nil, err
-				}
-
-				vm, err := disk.AttachedVM()
-				if err != nil {
-					return nil, err
-				}
-
-				// if attached vm
-				var diskAttachedVm *DiskAttachedVm
-				if vm != nil {
-					diskAttachedVm = &DiskAttachedVm{
-						Id:   vm.ID,
-						Name: vm.Name,
-					}
-				}
-
-				vdcDisk = &VdcDisk{
-					Id:          disk.Disk.Id,
-					Name:        disk.Disk.Name,
-					Size:        disk.Disk.Size,
-					Description: disk.Disk.Description,
-					Href:        disk.Disk.HREF,
-					AttachedVm:  diskAttachedVm,
-				}
-
-				diskMeta, err := vdc.DiskMeta(vdcDisk)
-				if err == nil {
-					vdcDisk.Meta = diskMeta
-				}
-			}
+	if len(vdcDisks) > 1 {
+		return nil, errcode.Newf(errcode.Duplicate, "duplicate disk found, %s", diskName)
+	}
+
+	return vdcDisks[0], nil
+}
+
+// FindDisksByDiskName returns all disks named diskName, disk names are not unique in VDC,
+// e.g. disks of clusters sharing the VDC
+func (vdc *Vdc) FindDisksByDiskName(diskName string) ([]*VdcDisk, error) {
+	records, err := vdc.queryDisks("name==" + queryFilterValue(diskName))
+	if err != nil {
+		return nil, err
+	}
+
+	var vdcDisks []*VdcDisk
+	for _, record := range records {
+		// filter of query is case insensitive
+		if record.Name != diskName {
+			continue
+		}
+
+		vdcDisk, err := vdc.recordToDisk(record)
+		if err != nil {
+			return nil, err
+		}
+		vdcDisks = append(vdcDisks, vdcDisk)
+	}
+
+	if len(vdcDisks) == 0 {
+		return nil, errcode.Newf(errcode.NotFound, "disk %s not found", diskName)
+	}
+
+	return vdcDisks, nil
+}
+
+// FindDiskById finds disk by id, e.g. urn:vcloud:disk:<uuid>, or by its uuid
+func (vdc *Vdc) FindDiskById(diskId string) (*VdcDisk, error) {
+	uuid := DiskUuid(diskId)
+	if uuid == "" {
+		return nil, errcode.Newf(errcode.InvalidArgument, "invalid disk id: %s", diskId)
+	}
+
+	href := vdc.vcdClient.Client.VCDHREF
+	href.Path = strings.TrimSuffix(href.Path, "/") + "/disk/" + uuid
+
+	vdcDisk, err := vdc.findDiskByHref(href.String())
+	if err != nil {
+		if isNotFound(err) {
+			return nil, errcode.Wrap(errcode.NotFound, err, "disk "+diskId+" not found")
 		}
+		return nil, err
 	}
 
-	if vdcDisk == nil {
-		return nil, errors.New("not found")
+	return vdcDisk, nil
+}
+
+// FindDiskByFsLabel finds disk by filesystem label of its disk meta, disks formatted before the label is stored
+// in meta have their name as label
+func (vdc *Vdc) FindDiskByFsLabel(label string) (*VdcDisk, error) {
+	// candidates are disks named label or with label in description, the label of meta is checked below
+	value := queryFilterValue(label)
+	records, err := vdc.queryDisks("(name==" + value + ",description==*" + value + "*)")
+	if err != nil {
+		return nil, err
+	}
+
+	var found *diskRecord
+	for _, record := range records {
+		fsLabel := record.Name
+		meta, err := vdc.DiskMeta(&VdcDisk{Description: record.Description})
+		if err == nil && meta != nil && meta.FsLabel != "" {
+			fsLabel = meta.FsLabel
+		}
+		if fsLabel != label {
+			continue
+		}
+
+		if found != nil {
+			return nil, errcode.Newf(errcode.Duplicate, "duplicate disk found by filesystem label, %s", label)
+		}
+		found = record
+	}
+
+	if found == nil {
+		return nil, errcode.Newf(errcode.NotFound, "disk of filesystem label %s not found", label)
+	}
+
+	return vdc.recordToDisk(found)
+}
+
+// findDiskByHref gets disk by its XML, sharingType of disk is unknown to types.Disk
+func (vdc *Vdc) findDiskByHref(href string) (*VdcDisk, error) {
+	diskUrl, err := url.Parse(href)
+	if err != nil {
+		return nil, errcode.Wrap(errcode.InvalidArgument, err, "parse disk href")
+	}
+
+	var disk diskXml
+	err = vdc.readXml(*diskUrl, nil, &disk)
+	if err != nil {
+		return nil, errcode.Annotate(errcode.VcdApiFailed, err, "find disk by href")
+	}
+
+	size := disk.Size
+	if size == 0 {
+		size = disk.SizeMb * 1024 * 1024
+	}
+
+	vdcDisk := &VdcDisk{
+		Id:          disk.Id,
+		Name:        disk.Name,
+		Size:        int(size),
+		Description: disk.Description,
+		Href:        disk.Href,
+		SharingType: disk.SharingType,
+	}
+
+	err = vdc.setAttachedVms(vdcDisk)
+	if err != nil {
+		return nil, err
+	}
+
+	diskMeta, err := vdc.DiskMeta(vdcDisk)
+	if err == nil {
+		vdcDisk.Meta = diskMeta
 	}
 
 	return vdcDisk, nil
 }
 
 func (vdc *Vdc) CreateDisk(disk *VdcDisk) (*VdcDisk, error) {
-	vdcDisk, err := vdc.client.CreateDisk(&types.DiskCreateParams{
-		Disk: &types.Disk{
-			Name:        disk.Name,
-			Size:        disk.Size,
-			Description: disk.Description,
-		},
-	})
+	// creating is not idempotent, only retry when VCD rejected the request
+	mutation := &Mutation{Operation: OpCreateDisk, DiskName: disk.Name}
+	err := vdc.mutate(mutation, false, func(ctx context.Context) error {
+		vdcDisk, err := vdc.client.CreateDisk(&types.DiskCreateParams{
+			Disk: &types.Disk{
+				Name:        disk.Name,
+				Size:        disk.Size,
+				Description: disk.Description,
+			},
+		})
+		if err != nil {
+			return err
+		}
 
-	disk.Href = vdcDisk.Disk.HREF
+		disk.Href = vdcDisk.Disk.HREF
+		mutation.DiskHref = disk.Href
 
-	task := govcd.NewTask(&vdc.vcdClient.Client)
-	for _, taskItem := range vdcDisk.Disk.Tasks.Task {
-		task.Task = taskItem
-		err = task.WaitTaskCompletion()
-	}
+		if vdcDisk.Disk.Tasks == nil {
+			return nil
+		}
+
+		for _, taskItem := range vdcDisk.Disk.Tasks.Task {
+			task := govcd.NewTask(&vdc.vcdClient.Client)
+			task.Task = taskItem
+			if err := vdc.waitTask(ctx, govcdTask{task}); err != nil {
+				// disk is created, do not create again
+				return &permanentError{err: err}
+			}
+		}
 
+		return nil
+	})
 	if err != nil {
-		return disk, err
+		return disk, errcode.Annotate(errcode.CreateFailed, err, "create disk "+disk.Name)
 	}
 
 	return disk, nil
@@ -229,11 +522,6 @@ func (vdc *Vdc) DiskMeta(disk *VdcDisk) (*VdcDiskMeta, error) {
 
 // Use independent disk's description as meta field
 func (vdc *Vdc) SetDiskMeta(disk *VdcDisk, newDiskMeta *VdcDiskMeta) (*VdcDisk, error) {
-	vcdDisk, err := vdc.client.FindDiskByHREF(disk.Href)
-	if err != nil {
-		return nil, err
-	}
-
 	// set date
 	meta, err := vdc.DiskMeta(disk)
 	if err == nil {
@@ -250,23 +538,32 @@ func (vdc *Vdc) SetDiskMeta(disk *VdcDisk, newDiskMeta *VdcDiskMeta) (*VdcDisk,
 	if err != nil {
 		return nil, err
 	}
-	vcdDisk.Disk.Description = string(b)
 
-	task, err := vcdDisk.Update(vcdDisk.Disk)
-	if err != nil {
-		return nil, err
-	}
+	mutation := &Mutation{Operation: OpUpdateDisk, DiskName: disk.Name, DiskHref: disk.Href}
+	err = vdc.mutate(mutation, true, func(ctx context.Context) error {
+		// get latest disk on every attempt, it may be modified concurrently
+		vcdDisk, err := vdc.client.FindDiskByHREF(disk.Href)
+		if err != nil {
+			return err
+		}
+		vcdDisk.Disk.Description = string(b)
+
+		task, err := vcdDisk.Update(vcdDisk.Disk)
+		if err != nil {
+			return err
+		}
 
-	err = task.WaitTaskCompletion()
+		return vdc.waitTask(ctx, govcdTask{&task})
+	})
 	if err != nil {
-		return nil, err
+		return nil, errcode.Annotate(errcode.MetaFailed, err, "update disk description")
 	}
 
-	// return refreshed disk info
-	return vdc.FindDiskByDiskName(vcdDisk.Disk.Name)
+	// return refreshed disk info, by href because disk name may not be unique
+	return vdc.findDiskByHref(disk.Href)
 }
 
-func (vdc *Vdc) DiskOp(disk *VdcDisk, busNumber int, unitNumber int, opFn DiskOpFn) error {
+func (vdc *Vdc) DiskOp(operation string, vm *VAppVm, disk *VdcDisk, busNumber int, unitNumber int, opFn DiskOpFn) error {
 	if err := VerifyHref(disk.Href); err != nil {
 		return err
 	}
@@ -285,16 +582,15 @@ func (vdc *Vdc) DiskOp(disk *VdcDisk, busNumber int, unitNumber int, opFn DiskOp
 		}
 	}
 
-	task, err := opFn(diskAttachOrDetachParams)
-	if err != nil {
-		return err
-	}
-	err = task.WaitTaskCompletion()
-	if err != nil {
-		return err
-	}
+	mutation := &Mutation{Operation: operation, DiskName: disk.Name, DiskHref: disk.Href, VmName: vm.Name}
+	return vdc.mutate(mutation, true, func(ctx context.Context) error {
+		task, err := opFn(diskAttachOrDetachParams)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		return vdc.waitTask(ctx, govcdTask{&task})
+	})
 }
 
 func (vdc *Vdc) AttachDisk(vm *VAppVm, disk *VdcDisk, busNumber int, unitNumber int) error {
@@ -302,14 +598,14 @@ func (vdc *Vdc) AttachDisk(vm *VAppVm, disk *VdcDisk, busNumber int, unitNumber
 		return err
 	}
 
-	vdcVM, err := vdc.vcdClient.FindVMByHREF(vm.Href)
+	vdcVM, err := vdc.findVmByHref(vm.Href)
 	if err != nil {
-		return err
+		return errcode.Annotate(errcode.VcdApiFailed, err, "find VM by href")
 	}
 
-	err = vdc.DiskOp(disk, busNumber, unitNumber, vdcVM.AttachDisk)
+	err = vdc.DiskOp(OpAttachDisk, vm, disk, busNumber, unitNumber, vdcVM.AttachDisk)
 	if err != nil {
-		return err
+		return errcode.Annotate(errcode.AttachFailed, err, fmt.Sprintf("attach disk %s to VM %s", disk.Name, vm.Name))
 	}
 
 	return nil
@@ -320,23 +616,75 @@ func (vdc *Vdc) DetachDisk(vm *VAppVm, disk *VdcDisk) error {
 		return err
 	}
 
-	vdcVM, err := vdc.vcdClient.FindVMByHREF(vm.Href)
+	vdcVM, err := vdc.findVmByHref(vm.Href)
 	if err != nil {
-		return err
+		return errcode.Annotate(errcode.VcdApiFailed, err, "find VM by href")
 	}
 
-	err = vdc.DiskOp(disk, -1, -1, vdcVM.DetachDisk)
+	err = vdc.DiskOp(OpDetachDisk, vm, disk, -1, -1, vdcVM.DetachDisk)
 	if err != nil {
+		return errcode.Annotate(errcode.DetachFailed, err, fmt.Sprintf("detach disk %s from VM %s", disk.Name, vm.Name))
+	}
+
+	return nil
+}
+
+// DeleteDisk deletes a detached disk, a disk which is already deleted is not an error
+func (vdc *Vdc) DeleteDisk(disk *VdcDisk) error {
+	if err := VerifyHref(disk.Href); err != nil {
 		return err
 	}
 
+	mutation := &Mutation{Operation: OpDeleteDisk, DiskName: disk.Name, DiskHref: disk.Href}
+	err := vdc.mutate(mutation, true, func(ctx context.Context) error {
+		vcdDisk, err := vdc.client.FindDiskByHREF(disk.Href)
+		if err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
+
+		task, err := vcdDisk.Delete()
+		if err != nil {
+			return err
+		}
+
+		return vdc.waitTask(ctx, govcdTask{&task})
+	})
+	if err != nil {
+		return errcode.Annotate(errcode.DeleteFailed, err, "delete disk "+disk.Name)
+	}
+
 	return nil
 }
 
+func (vdc *Vdc) findVmByHref(href string) (vm govcd.VM, err error) {
+	err = vdc.read(func(ctx context.Context) error {
+		vm, err = vdc.vcdClient.FindVMByHREF(href)
+		return err
+	})
+
+	return vm, err
+}
+
 func VerifyHref(href string) error {
 	if href == "" {
-		return errors.New("href is empty")
+		return errcode.New(errcode.InvalidArgument, "href is empty")
 	}
 
 	return nil
 }
+
+var uuidRegexp = regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$")
+
+// DiskUuid returns uuid of disk id urn:vcloud:disk:<uuid>, empty when it is not a uuid
+func DiskUuid(diskId string) string {
+	diskIdArr := strings.Split(diskId, ":")
+	uuid := diskIdArr[len(diskIdArr)-1]
+	if !uuidRegexp.MatchString(uuid) {
+		return ""
+	}
+
+	return strings.ToLower(uuid)
+}
@@ -3,45 +3,94 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/nightlyone/lockfile"
 	"github.com/ty2/vcdfv/config"
+	"github.com/ty2/vcdfv/errcode"
+	"github.com/ty2/vcdfv/logging"
+	"github.com/ty2/vcdfv/metrics"
 	"github.com/ty2/vcdfv/operation"
-	"gopkg.in/yaml.v2"
-	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 // FlexVolume Spec
 // https://github.com/kubernetes/community/blob/f60e9ca9f800236e412104843e3a3ded908904c9/contributors/devel/flexvolume.md
 
+// nil when config cannot be loaded
 var vcdfvConfig *config.Vcdfv
 
-func init() {
-	fileBytes, err := ioutil.ReadFile("/etc/kubernetes/vcdfv-config.yaml")
-	if err != nil {
-		output, _ := (&operation.StatusFailure{
-			Error: err,
+// nil when logFile is not configured
+var vcdfvLogger *logging.Logger
+
+// flushed to metricsTextfileDir at exit
+var vcdfvMetrics = metrics.NewRecorder()
+
+// FlexVolume call of this invocation, for metrics
+var vcdfvCall = "unknown"
+
+func main() {
+	args := os.Args
+	if len(args) > 1 {
+		vcdfvCall = args[1]
+	}
+	startedAt := time.Now()
+
+	configPath := config.Path()
+	var configErr error
+	vcdfvConfig, configErr = config.Load(configPath)
+	if configErr == nil {
+		vcdfvLogger = newLogger(args)
+	}
+	vcdfvLogger.Info("start", logging.Fields{"config": configPath})
+
+	// validate-config reports a broken config instead of failing with it, it does not attach or detach so no lock
+	if vcdfvCall == opValidateConfig {
+		result, err := (&operation.ValidateConfig{
+			Path:        configPath,
+			VcdfvConfig: vcdfvConfig,
+			LoadError:   configErr,
+			Online:      len(args) > 2 && args[2] == validateConfigOnlineFlag,
 		}).Exec()
-		log.Fatal(output)
+		printResult(result, err, startedAt)
+		return
 	}
 
-	err = yaml.Unmarshal([]byte(fileBytes), &vcdfvConfig)
-	if err != nil {
-		output, _ := (&operation.StatusFailure{
-			Error: err,
+	if configErr != nil {
+		result, err := (&operation.StatusFailure{
+			Error: configErr,
 		}).Exec()
-		log.Fatal(output)
+		printResult(result, err, startedAt)
+		return
+	}
+
+	// stats only read the volume and vCD, they do not wait for attach or detach of other calls
+	if vcdfvCall == opGetVolumeStats {
+		if len(args) != 3 {
+			result, err := (&operation.StatusFailure{
+				Error: errcode.Newf(errcode.InvalidArgument, "args len != 3, got len: %v", len(args)),
+			}).Exec()
+			printResult(result, err, startedAt)
+			return
+		}
+		result, err := (&operation.VolumeStats{
+			MountDir:    args[2],
+			VcdfvConfig: vcdfvConfig,
+			Context:     context.Background(),
+		}).Exec()
+		printResult(result, err, startedAt)
+		return
 	}
-}
 
-func main() {
 	lock, err := lockfile.New(filepath.Join(os.TempDir(), "lock.vcdfv.lck"))
 	if err != nil {
 		// cannot init lock
@@ -49,7 +98,7 @@ func main() {
 			Error: err,
 		}).Exec()
 
-		printResult(result, err)
+		printResult(result, err, startedAt)
 		return
 	}
 
@@ -59,23 +108,57 @@ func main() {
 		time.Sleep(time.Second * 30)
 
 		result, err := (&operation.StatusFailure{
-			Error: errors.New("waiting other process finish attach/detach disk"),
+			Error: errcode.New(errcode.Busy, "waiting other process finish attach/detach disk"),
 		}).Exec()
-		printResult(result, err)
+		printResult(result, err, startedAt)
 		return
 	}
 
 	defer lock.Unlock()
 
-	args := os.Args
+	// cancel vCD operations when kubelet or admin terminates the process
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
 
 	// operation
-	operation := argsToOperation(args)
+	operation := argsToOperation(ctx, args)
 	result, err := operation.Exec()
-	printResult(result, err)
+	printResult(result, err, startedAt)
 	return
 }
 
+func newLogger(args []string) *logging.Logger {
+	logger, err := logging.New(&logging.Config{
+		File:       vcdfvConfig.LogFile,
+		Level:      vcdfvConfig.LogLevel,
+		MaxSizeMB:  vcdfvConfig.LogMaxSizeMB,
+		MaxBackups: vcdfvConfig.LogMaxBackups,
+	})
+	if err != nil {
+		// logging must not break the driver
+		log.Output(2, "init logger: "+err.Error())
+		return nil
+	}
+
+	fields := logging.Fields{
+		"opId": logging.NewOperationId(),
+	}
+	if len(args) > 1 {
+		fields["call"] = args[1]
+	}
+	if len(args) > 2 {
+		fields["mountDir"] = args[2]
+	}
+
+	return logger.With(fields)
+}
+
 func lockProcess(lock lockfile.Lockfile) error {
 	err := lock.TryLock()
 	// error handling is essential, as we only try to get the lock.
@@ -86,7 +169,23 @@ func lockProcess(lock lockfile.Lockfile) error {
 	return nil
 }
 
-func printResult(result *operation.ExecResult, err error) {
+func printResult(result *operation.ExecResult, err error, startedAt time.Time) {
+	fields := logging.Fields{
+		"durationMs": time.Since(startedAt).Nanoseconds() / int64(time.Millisecond),
+	}
+	if result != nil {
+		fields["status"] = result.Status
+		fields["code"] = result.Code
+		fields["message"] = result.Message
+	}
+	if err != nil {
+		vcdfvLogger.Error("result", err, fields)
+	} else {
+		vcdfvLogger.Info("result", fields)
+	}
+
+	flushMetrics(result, startedAt)
+
 	// echo result as possible
 	if result != nil {
 		// convert to join
@@ -105,16 +204,36 @@ func printResult(result *operation.ExecResult, err error) {
 
 }
 
-func argsToOperation(args []string) operation.Operation {
+func flushMetrics(result *operation.ExecResult, startedAt time.Time) {
+	status, code := "", ""
+	if result != nil {
+		status, code = result.Status, result.Code
+	}
+
+	vcdfvMetrics.Inc(metrics.OperationsTotal, metrics.Labels{"operation": vcdfvCall, "status": status, "code": code})
+	vcdfvMetrics.Observe(metrics.OperationDurationSeconds, metrics.Labels{"operation": vcdfvCall}, time.Since(startedAt))
+	vcdfvMetrics.Set(metrics.LastOperationTimestamp, metrics.Labels{"operation": vcdfvCall}, float64(time.Now().Unix()))
+
+	if vcdfvConfig == nil {
+		return
+	}
+
+	if err := vcdfvMetrics.Flush(vcdfvConfig.MetricsTextfileDir); err != nil {
+		// the recorded metrics of this call are lost, e.g. the textfile is locked by other calls for too long
+		vcdfvLogger.Error("flush metrics, metrics of the call are dropped", err, logging.Fields{"dir": vcdfvConfig.MetricsTextfileDir})
+	}
+}
+
+func argsToOperation(ctx context.Context, args []string) operation.Operation {
 	if len(args) <= 1 {
-		err := errors.New("args len <= 1")
+		err := errcode.New(errcode.InvalidArgument, "args len <= 1")
 		return &operation.StatusFailure{
 			Error: err,
 		}
 	}
 
 	operationType := args[1]
-	validOperations := map[string]func(args []string) operation.Operation{
+	validOperations := map[string]func(ctx context.Context, args []string) operation.Operation{
 		opInit:    argsToInitOperation,
 		opMount:   argsToMountOperation,
 		opUnmount: argsToUnMountOperation,
@@ -131,11 +250,11 @@ func argsToOperation(args []string) operation.Operation {
 			i++
 		}
 		return &operation.StatusFailure{
-			Error: NewError(errorInvalidOperationCode, fmt.Sprintf(errorInvalidOperationDefaultMsg, strings.Join(keys, ","), args[0])),
+			Error: errcode.Newf(errcode.InvalidOperation, errorInvalidOperationDefaultMsg, strings.Join(keys, ","), args[0]),
 		}
 	} else {
 		// valid operation
-		op = opFn(args)
+		op = opFn(ctx, args)
 	}
 
 	// expect operation is not empty
@@ -149,13 +268,13 @@ func argsToOperation(args []string) operation.Operation {
 	return op
 }
 
-func argsToInitOperation(args []string) operation.Operation {
+func argsToInitOperation(ctx context.Context, args []string) operation.Operation {
 	return &operation.Init{}
 }
 
-func argsToMountOperation(args []string) operation.Operation {
+func argsToMountOperation(ctx context.Context, args []string) operation.Operation {
 	if l := len(args); l != 4 {
-		err := errors.New(fmt.Sprintf("args len != 4, got len: %v", l))
+		err := errcode.Newf(errcode.InvalidArgument, "args len != 4, got len: %v", l)
 		return &operation.StatusFailure{
 			Error: err,
 		}
@@ -165,20 +284,27 @@ func argsToMountOperation(args []string) operation.Operation {
 	err := json.Unmarshal([]byte(args[3]), option)
 	if err != nil {
 		return &operation.StatusFailure{
-			Error: err,
+			Error: errcode.Wrap(errcode.InvalidArgument, err, "options"),
 		}
 	}
 
+	logger := vcdfvLogger.With(logging.Fields{"volume": option.PvOrVolumeName})
 	return &operation.Mount{
 		MountDir:    args[2],
 		Options:     option,
 		VcdfvConfig: vcdfvConfig,
+		Context:     ctx,
+		Logger:      logger,
+		Metrics:     vcdfvMetrics,
+		Journals:    operation.JournalStore(vcdfvConfig),
+		Events:      operation.EventRecorder(vcdfvConfig, option, logger),
+		DryRun:      dryRun(),
 	}
 }
 
-func argsToUnMountOperation(args []string) operation.Operation {
+func argsToUnMountOperation(ctx context.Context, args []string) operation.Operation {
 	if l := len(args); l < 3 {
-		err := errors.New(fmt.Sprintf("args len < 3, got len: %v", l))
+		err := errcode.Newf(errcode.InvalidArgument, "args len < 3, got len: %v", l)
 		return &operation.StatusFailure{
 			Error: err,
 		}
@@ -187,5 +313,16 @@ func argsToUnMountOperation(args []string) operation.Operation {
 	return &operation.Unmount{
 		MountDir:    args[2],
 		VcdfvConfig: vcdfvConfig,
+		Context:     ctx,
+		// kubelet names the mount dir by pv or volume name
+		Logger:   vcdfvLogger.With(logging.Fields{"volume": filepath.Base(args[2])}),
+		Metrics:  vcdfvMetrics,
+		Journals: operation.JournalStore(vcdfvConfig),
+		DryRun:   dryRun(),
 	}
 }
+
+func dryRun() bool {
+	value, _ := strconv.ParseBool(os.Getenv(dryRunEnv))
+	return value
+}
@@ -0,0 +1,155 @@
+package vmdiskop
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const procPath = "/proc"
+
+// Holder is a process which keeps a mount busy, Paths are its open files, cwd, root and mapped files on the mount.
+// paths are in the mount namespace of the process, e.g. of its container
+type Holder struct {
+	Pid     int      `json:"pid"`
+	Command string   `json:"command"`
+	Paths   []string `json:"paths"`
+}
+
+// Holders returns processes which have files open, cwd, root or mapped files on the filesystem mounted at
+// mountPoint, or the device node of a block volume bound at mountPoint open. files are matched by device number,
+// a process of a container sees the filesystem at another path. processes which exit while they are read are skipped
+func Holders(mountPoint string) ([]*Holder, error) {
+	mountInfo, err := FindMountInfo(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	majMin := mountInfo.MajMin
+	if name := mountInfo.BoundDeviceName(); name != "" {
+		if majMin, err = deviceNumber(filepath.Join("/dev", name)); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+
+	self := os.Getpid()
+	var holders []*Holder
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		paths := processFiles(filepath.Join(procPath, entry.Name()), majMin)
+		if len(paths) == 0 {
+			continue
+		}
+
+		command, _ := ioutil.ReadFile(filepath.Join(procPath, entry.Name(), "comm"))
+		holders = append(holders, &Holder{
+			Pid:     pid,
+			Command: strings.TrimSpace(string(command)),
+			Paths:   paths,
+		})
+	}
+
+	return holders, nil
+}
+
+// processFiles returns paths of files of process dir which are on the filesystem or are the device node of majMin
+func processFiles(processDir string, majMin string) []string {
+	var paths []string
+	add := func(link string) {
+		// stat follows the link to the file the process has, whatever its mount namespace is
+		dev, rdev, err := fileDeviceNumbers(link)
+		if err != nil || (dev != majMin && rdev != majMin) {
+			return
+		}
+		path, _ := os.Readlink(link)
+		paths = append(paths, strings.TrimSuffix(path, " (deleted)"))
+	}
+
+	for _, link := range []string{"cwd", "root"} {
+		add(filepath.Join(processDir, link))
+	}
+
+	fds, _ := ioutil.ReadDir(filepath.Join(processDir, "fd"))
+	for _, fd := range fds {
+		add(filepath.Join(processDir, "fd", fd.Name()))
+	}
+
+	// a mapped file is listed once per mapped region
+	maps, err := os.Open(filepath.Join(processDir, "maps"))
+	if err != nil {
+		return paths
+	}
+	defer maps.Close()
+
+	mapped := map[string]bool{}
+	scanner := bufio.NewScanner(maps)
+	for scanner.Scan() {
+		dev, path, ok := parseMapsLine(scanner.Text())
+		if ok && dev == majMin && !mapped[path] {
+			mapped[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// parseMapsLine returns major:minor in decimal and path of a line of /proc/<pid>/maps of a mapped file
+func parseMapsLine(line string) (string, string, bool) {
+	// address perms offset dev inode pathname, dev is hex major:minor
+	fields := strings.Fields(line)
+	if len(fields) < 6 || fields[4] == "0" {
+		return "", "", false
+	}
+
+	dev := strings.SplitN(fields[3], ":", 2)
+	if len(dev) != 2 {
+		return "", "", false
+	}
+	major, err := strconv.ParseUint(dev[0], 16, 32)
+	if err != nil {
+		return "", "", false
+	}
+	minor, err := strconv.ParseUint(dev[1], 16, 32)
+	if err != nil {
+		return "", "", false
+	}
+
+	// path may have spaces
+	path := strings.TrimSuffix(strings.Join(fields[5:], " "), " (deleted)")
+
+	return fmt.Sprintf("%d:%d", major, minor), path, true
+}
+
+// majorMinor returns major:minor of a device number of linux
+func majorMinor(dev uint64) string {
+	major := (dev>>8)&0xfff | (dev>>32)&0xfffff000
+	minor := dev&0xff | (dev>>12)&0xffffff00
+
+	return fmt.Sprintf("%d:%d", major, minor)
+}
+
+// Signal sends sig to holders, a holder which already exited is not an error
+func Signal(holders []*Holder, sig syscall.Signal) error {
+	for _, holder := range holders {
+		if err := syscall.Kill(holder.Pid, sig); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package vmdiskop
+
+import "testing"
+
+func TestParseMapsLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		wantDev  string
+		wantPath string
+		wantOk   bool
+	}{
+		{
+			name:     "mapped file",
+			line:     "7f1c2a000000-7f1c2a021000 r--p 00000000 08:10 131074                     /data/lib.so",
+			wantDev:  "8:16",
+			wantPath: "/data/lib.so",
+			wantOk:   true,
+		},
+		{
+			name:     "hex major and minor",
+			line:     "7f1c2a000000-7f1c2a021000 r--p 00000000 fd:1a 12 /data/db",
+			wantDev:  "253:26",
+			wantPath: "/data/db",
+			wantOk:   true,
+		},
+		{
+			name:     "path with spaces",
+			line:     "7f1c2a000000-7f1c2a021000 rw-s 00000000 08:10 12 /data/a b",
+			wantDev:  "8:16",
+			wantPath: "/data/a b",
+			wantOk:   true,
+		},
+		{
+			name:     "deleted file",
+			line:     "7f1c2a000000-7f1c2a021000 rw-s 00000000 08:10 12 /data/tmp (deleted)",
+			wantDev:  "8:16",
+			wantPath: "/data/tmp",
+			wantOk:   true,
+		},
+		{
+			name: "anonymous mapping",
+			line: "7f1c2a000000-7f1c2a021000 rw-p 00000000 00:00 0 ",
+		},
+		{
+			name: "heap",
+			line: "55d5c8a00000-55d5c8a21000 rw-p 00000000 00:00 0                          [heap]",
+		},
+		{
+			name: "invalid dev",
+			line: "7f1c2a000000-7f1c2a021000 r--p 00000000 xx:10 12 /data/lib.so",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev, path, ok := parseMapsLine(tt.line)
+			if ok != tt.wantOk || dev != tt.wantDev || path != tt.wantPath {
+				t.Errorf("parseMapsLine() = %q, %q, %v, want %q, %q, %v", dev, path, ok, tt.wantDev, tt.wantPath, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestMajorMinor(t *testing.T) {
+	tests := []struct {
+		dev  uint64
+		want string
+	}{
+		{dev: 0x0810, want: "8:16"},
+		{dev: 0xfd01, want: "253:1"},
+		// minor above 255 and major above 4095 are in the high bits
+		{dev: 0x10fd00, want: "253:256"},
+		{dev: 0x100000000000, want: "4096:0"},
+	}
+
+	for _, tt := range tests {
+		if got := majorMinor(tt.dev); got != tt.want {
+			t.Errorf("majorMinor(%#x) = %q, want %q", tt.dev, got, tt.want)
+		}
+	}
+}
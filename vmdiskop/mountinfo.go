@@ -0,0 +1,141 @@
+package vmdiskop
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/ty2/vcdfv/errcode"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const mountInfoPath = "/proc/self/mountinfo"
+
+// filesystem of device nodes, a device node bound at a mount point is a mount of it
+const fsTypeDevtmpfs = "devtmpfs"
+
+// MountInfo is a line of /proc/self/mountinfo, see proc(5)
+type MountInfo struct {
+	MountId  int
+	ParentId int
+	// major:minor of the device of filesystem
+	MajMin string
+	// path in filesystem which is mounted, not / for a bind mount
+	Root       string
+	MountPoint string
+	Options    string
+	FsType     string
+	Source     string
+}
+
+// MountInfos returns mounts of this process in mount order
+func MountInfos() ([]*MountInfo, error) {
+	file, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseMountInfo(file)
+}
+
+func ParseMountInfo(reader io.Reader) ([]*MountInfo, error) {
+	var mountInfos []*MountInfo
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		// optional fields end with a single hyphen
+		fields := strings.Fields(line)
+		separator := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				separator = i
+				break
+			}
+		}
+		if separator < 0 || len(fields) < separator+3 {
+			return nil, fmt.Errorf("invalid mountinfo line: %s", line)
+		}
+
+		mountId, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount id of mountinfo line: %s", line)
+		}
+		parentId, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent id of mountinfo line: %s", line)
+		}
+
+		mountInfos = append(mountInfos, &MountInfo{
+			MountId:    mountId,
+			ParentId:   parentId,
+			MajMin:     fields[2],
+			Root:       unescapeMountInfo(fields[3]),
+			MountPoint: unescapeMountInfo(fields[4]),
+			Options:    fields[5],
+			FsType:     fields[separator+1],
+			Source:     unescapeMountInfo(fields[separator+2]),
+		})
+	}
+
+	return mountInfos, scanner.Err()
+}
+
+// unescapeMountInfo decodes space, tab, newline and backslash which the kernel escapes as octal, e.g. \040
+func unescapeMountInfo(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var buffer bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+4 <= len(value) {
+			if b, err := strconv.ParseUint(value[i+1:i+4], 8, 8); err == nil {
+				buffer.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+		}
+		buffer.WriteByte(value[i])
+	}
+
+	return buffer.String()
+}
+
+// FindMountInfo returns the mount at mountPoint, the top one when mounts are stacked
+func FindMountInfo(mountPoint string) (*MountInfo, error) {
+	mountInfos, err := MountInfos()
+	if err != nil {
+		return nil, err
+	}
+
+	mountPoint = filepath.Clean(mountPoint)
+	var found *MountInfo
+	for _, mountInfo := range mountInfos {
+		if mountInfo.MountPoint == mountPoint {
+			found = mountInfo
+		}
+	}
+
+	if found == nil {
+		return nil, errcode.Newf(errcode.DeviceNotFound, "%s is not a mount point", mountPoint)
+	}
+
+	return found, nil
+}
+
+// BoundDeviceName returns name of device node which is bound at mount point, empty when it is not a device node
+func (mountInfo *MountInfo) BoundDeviceName() string {
+	if mountInfo.FsType != fsTypeDevtmpfs || mountInfo.Root == "/" {
+		return ""
+	}
+
+	return filepath.Base(mountInfo.Root)
+}
@@ -0,0 +1,106 @@
+package vmdiskop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    MountInfo
+		wantErr bool
+	}{
+		{
+			name: "filesystem",
+			line: "36 35 8:16 / /var/lib/kubelet/pods/1/volumes/vcdfv~vcdfv/pv1 rw,relatime shared:1 - ext4 /dev/sdb rw",
+			want: MountInfo{MountId: 36, ParentId: 35, MajMin: "8:16", Root: "/",
+				MountPoint: "/var/lib/kubelet/pods/1/volumes/vcdfv~vcdfv/pv1", Options: "rw,relatime", FsType: "ext4", Source: "/dev/sdb"},
+		},
+		{
+			name: "no optional fields",
+			line: "25 1 8:1 / / rw - xfs /dev/sda1 rw,attr2",
+			want: MountInfo{MountId: 25, ParentId: 1, MajMin: "8:1", Root: "/", MountPoint: "/", Options: "rw", FsType: "xfs", Source: "/dev/sda1"},
+		},
+		{
+			name: "many optional fields",
+			line: "40 25 0:35 / /mnt rw shared:5 master:2 propagate_from:1 - tmpfs tmpfs rw",
+			want: MountInfo{MountId: 40, ParentId: 25, MajMin: "0:35", Root: "/", MountPoint: "/mnt", Options: "rw", FsType: "tmpfs", Source: "tmpfs"},
+		},
+		{
+			name: "escaped space, tab, newline and backslash",
+			line: `41 25 8:32 /data\040dir /mnt/a\040b\011c\012d\134e rw - ext4 /dev/sd\040c rw`,
+			want: MountInfo{MountId: 41, ParentId: 25, MajMin: "8:32", Root: "/data dir",
+				MountPoint: "/mnt/a b\tc\nd\\e", Options: "rw", FsType: "ext4", Source: "/dev/sd c"},
+		},
+		{
+			name: "bound device node",
+			line: "42 25 0:5 /sdb /var/lib/kubelet/plugins/volumeDevices/pv2 rw - devtmpfs udev rw,size=1g",
+			want: MountInfo{MountId: 42, ParentId: 25, MajMin: "0:5", Root: "/sdb",
+				MountPoint: "/var/lib/kubelet/plugins/volumeDevices/pv2", Options: "rw", FsType: "devtmpfs", Source: "udev"},
+		},
+		{
+			name: "incomplete escape is kept",
+			line: `43 25 8:48 / /mnt/x\04 rw - ext4 /dev/sdd rw`,
+			want: MountInfo{MountId: 43, ParentId: 25, MajMin: "8:48", Root: "/", MountPoint: `/mnt/x\04`, Options: "rw", FsType: "ext4", Source: "/dev/sdd"},
+		},
+		{name: "no separator", line: "44 25 8:1 / /mnt rw shared:1 ext4 /dev/sda1 rw", wantErr: true},
+		{name: "no source after separator", line: "44 25 8:1 / /mnt rw - ext4", wantErr: true},
+		{name: "invalid mount id", line: "x 25 8:1 / /mnt rw - ext4 /dev/sda1 rw", wantErr: true},
+		{name: "invalid parent id", line: "44 x 8:1 / /mnt rw - ext4 /dev/sda1 rw", wantErr: true},
+	}
+
+	for _, test := range tests {
+		mountInfos, err := ParseMountInfo(strings.NewReader(test.line + "\n"))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expect error, got %+v", test.name, mountInfos)
+			}
+			continue
+		}
+
+		if err != nil || len(mountInfos) != 1 {
+			t.Errorf("%s: ParseMountInfo() = %v, %v", test.name, mountInfos, err)
+			continue
+		}
+		if *mountInfos[0] != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, *mountInfos[0], test.want)
+		}
+	}
+}
+
+func TestParseMountInfoLines(t *testing.T) {
+	mountInfos, err := ParseMountInfo(strings.NewReader(
+		"25 1 8:1 / / rw - ext4 /dev/sda1 rw\n\n" +
+			"36 25 8:16 / /mnt rw - ext4 /dev/sdb rw\n" +
+			"37 36 8:32 / /mnt rw - ext4 /dev/sdc rw\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mountInfos) != 3 {
+		t.Fatalf("%d mounts, want 3", len(mountInfos))
+	}
+	// mounts are in mount order, the last mount at a mount point is on top
+	if mountInfos[2].MountId != 37 || mountInfos[2].ParentId != 36 {
+		t.Errorf("last mount %+v", mountInfos[2])
+	}
+}
+
+func TestBoundDeviceName(t *testing.T) {
+	tests := []struct {
+		mountInfo MountInfo
+		want      string
+	}{
+		{MountInfo{FsType: "devtmpfs", Root: "/sdb"}, "sdb"},
+		{MountInfo{FsType: "devtmpfs", Root: "/"}, ""},
+		{MountInfo{FsType: "ext4", Root: "/sdb"}, ""},
+	}
+
+	for _, test := range tests {
+		if got := test.mountInfo.BoundDeviceName(); got != test.want {
+			t.Errorf("BoundDeviceName() of %+v = %q, want %q", test.mountInfo, got, test.want)
+		}
+	}
+}
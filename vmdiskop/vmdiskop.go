@@ -1,13 +1,19 @@
 package vmdiskop
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/ty2/vcdfv/errcode"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -16,6 +22,9 @@ type lsblkOutput struct {
 	BlockDevices []*BlockDevice `json:"blockdevices"`
 }
 
+// lsblk TYPE of partition
+const deviceTypePartition = "part"
+
 type BlockDevice struct {
 	Name       string         `json:"name"`
 	FsType     string         `json:"fstype"`
@@ -24,45 +33,112 @@ type BlockDevice struct {
 	Children   []*BlockDevice `json:"children"`
 	MountPoint string         `json:"mountpoint"`
 	Size       string         `json:"size"`
+	MajMin     string         `json:"maj:min"`
+	Type       string         `json:"type"`
+	// partition table type of disk, e.g. gpt or dos
+	PtType    string `json:"pttype"`
+	PartLabel string `json:"partlabel"`
+	// disk of a partition, nil for a disk
+	parent *BlockDevice
 }
 
-func FindDeviceByDeviceName(deviceName string) (*BlockDevice, error) {
-	blockDevices, err := BlockDevices()
-	if err != nil {
-		return nil, err
+// FilesystemStats is capacity and inodes of a mounted filesystem in bytes, Available is what non root users can write
+type FilesystemStats struct {
+	Capacity   int64 `json:"capacity"`
+	Used       int64 `json:"used"`
+	Available  int64 `json:"available"`
+	Inodes     int64 `json:"inodes"`
+	InodesUsed int64 `json:"inodesUsed"`
+	InodesFree int64 `json:"inodesFree"`
+}
+
+// Disk returns disk of partition, device itself when it is a disk. SCSI device is the disk
+func (blockDevice *BlockDevice) Disk() *BlockDevice {
+	disk := blockDevice
+	for disk.parent != nil {
+		disk = disk.parent
 	}
 
-	var foundedBlockDevice *BlockDevice
+	return disk
+}
+
+// Find returns the first device or child of device which matches, depth first
+func Find(blockDevices []*BlockDevice, match func(blockDevice *BlockDevice) bool) *BlockDevice {
 	for _, blockDevice := range blockDevices {
-		if blockDevice.Name == deviceName {
-			foundedBlockDevice = blockDevice
-			break
+		if match(blockDevice) {
+			return blockDevice
+		}
+		if found := Find(blockDevice.Children, match); found != nil {
+			return found
 		}
 	}
 
+	return nil
+}
+
+func FindDeviceByDeviceName(deviceName string) (*BlockDevice, error) {
+	blockDevices, err := ListBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	foundedBlockDevice := Find(blockDevices, func(blockDevice *BlockDevice) bool {
+		return blockDevice.Name == deviceName
+	})
+
 	if foundedBlockDevice == nil {
-		return nil, errors.New("not found")
+		return nil, errcode.Newf(errcode.DeviceNotFound, "device %s not found", deviceName)
 	}
 
 	return foundedBlockDevice, nil
 }
 
+// FindDeviceByMountPoint finds device of filesystem mounted at mountPoint by mountinfo, lsblk only lists one mount
+// point of a device so bind mounts are missed. a partition is found as well as a disk
 func FindDeviceByMountPoint(mountPoint string) (*BlockDevice, error) {
-	blockDevices, err := BlockDevices()
+	mountInfo, err := FindMountInfo(mountPoint)
 	if err != nil {
 		return nil, err
 	}
 
-	var foundedBlockDevice *BlockDevice
-	for _, blockDevice := range blockDevices {
-		if blockDevice.MountPoint == mountPoint {
-			foundedBlockDevice = blockDevice
-			break
-		}
+	if deviceName := mountInfo.BoundDeviceName(); deviceName != "" {
+		return nil, errcode.Newf(errcode.DeviceNotFound, "device node %s is bound at mount point %s, it is not a filesystem", deviceName, mountPoint)
 	}
 
+	blockDevices, err := ListBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	foundedBlockDevice := Find(blockDevices, func(blockDevice *BlockDevice) bool {
+		return blockDevice.MajMin == mountInfo.MajMin
+	})
+
 	if foundedBlockDevice == nil {
-		return nil, errors.New("not found")
+		return nil, errcode.Newf(errcode.DeviceNotFound, "device %s of %s filesystem at mount point %s is not a block device", mountInfo.MajMin, mountInfo.FsType, mountPoint)
+	}
+
+	return foundedBlockDevice, nil
+}
+
+// FindDeviceByDeviceNode finds device of device node at path, e.g. a device node bound at mount dir of a block volume
+func FindDeviceByDeviceNode(path string) (*BlockDevice, error) {
+	majMin, err := deviceNumber(path)
+	if err != nil {
+		return nil, err
+	}
+
+	blockDevices, err := ListBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	foundedBlockDevice := Find(blockDevices, func(blockDevice *BlockDevice) bool {
+		return blockDevice.MajMin == majMin
+	})
+
+	if foundedBlockDevice == nil {
+		return nil, errcode.Newf(errcode.DeviceNotFound, "device %s of device node %s not found", majMin, path)
 	}
 
 	return foundedBlockDevice, nil
@@ -91,8 +167,9 @@ func ScanScsiHost() error {
 	return nil
 }
 
+// RemoveSCSIDevice removes SCSI device of disk, the disk of a partition is removed
 func RemoveSCSIDevice(blockDevice *BlockDevice) error {
-	scsiRemovePath := fmt.Sprintf("/sys/block/%s/device/delete", blockDevice.Name)
+	scsiRemovePath := fmt.Sprintf("/sys/block/%s/device/delete", blockDevice.Disk().Name)
 	err := ioutil.WriteFile(scsiRemovePath, []byte("1"), 0666)
 	if err != nil {
 		return err
@@ -101,32 +178,111 @@ func RemoveSCSIDevice(blockDevice *BlockDevice) error {
 	return nil
 }
 
+// IsFormatted reports whether device has a filesystem or a partition table, a partitioned disk is never formatted
+// as a whole, its partition is formatted instead
 func IsFormatted(blockDevice *BlockDevice) bool {
-	if blockDevice.FsType == "" && len(blockDevice.Children) <= 0 {
-		return false
+	return blockDevice.FsType != "" || HasPartitionTable(blockDevice)
+}
+
+func HasPartitionTable(blockDevice *BlockDevice) bool {
+	return blockDevice.PtType != "" || len(Partitions(blockDevice)) > 0
+}
+
+// Partitions returns partitions of disk in the order of lsblk
+func Partitions(blockDevice *BlockDevice) []*BlockDevice {
+	var partitions []*BlockDevice
+	for _, child := range blockDevice.Children {
+		if child.Type == deviceTypePartition {
+			partitions = append(partitions, child)
+		}
+	}
+
+	return partitions
+}
+
+// PartitionNumber returns number of partition in its partition table, 0 when it is unknown
+func PartitionNumber(partition *BlockDevice) int {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/block/%s/partition", partition.Name))
+	if err != nil {
+		return 0
+	}
+
+	number, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+
+	return number
+}
+
+// FindPartition finds partition of disk by partition number, or by partition label or filesystem label when number
+// is 0. position in lsblk is the number when sysfs has no partition number
+func FindPartition(blockDevice *BlockDevice, number int, label string) (*BlockDevice, error) {
+	for i, partition := range Partitions(blockDevice) {
+		if number > 0 {
+			partitionNumber := PartitionNumber(partition)
+			if partitionNumber == 0 {
+				partitionNumber = i + 1
+			}
+			if partitionNumber == number {
+				return partition, nil
+			}
+		} else if label != "" && (partition.PartLabel == label || partition.Label == label) {
+			return partition, nil
+		}
+	}
+
+	if number > 0 {
+		return nil, errcode.Newf(errcode.DeviceNotFound, "partition %d of device %s not found", number, blockDevice.Name)
+	}
+
+	return nil, errcode.Newf(errcode.DeviceNotFound, "partition of label %s of device %s not found", label, blockDevice.Name)
+}
+
+// CreateGptPartition creates a GPT partition table with a single partition of the whole disk, partition table of disk
+// is replaced
+func CreateGptPartition(blockDevice *BlockDevice, name string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	parted := exec.CommandContext(ctx, "parted", "--script", "--align", "optimal", fmt.Sprintf("/dev/%s", blockDevice.Name),
+		"mklabel", "gpt", "mkpart", name, "0%", "100%")
+	output, err := parted.CombinedOutput()
+	if err != nil {
+		return string(output), err
 	}
 
-	return true
+	// kernel reads the new partition table, udevadm settle of BlockDevices waits for its device
+	partprobe := exec.CommandContext(ctx, "partprobe", fmt.Sprintf("/dev/%s", blockDevice.Name))
+	partprobeOutput, err := partprobe.CombinedOutput()
+	return string(output) + string(partprobeOutput), err
 }
 
+// BlockDevices rescans SCSI hosts for attached disks and lists block devices
 func BlockDevices() ([]*BlockDevice, error) {
 	err := ScanScsiHost()
 	if err != nil {
 		return nil, err
 	}
 
+	return ListBlockDevices()
+}
+
+// ListBlockDevices lists block devices without rescan of SCSI hosts, it never writes to sysfs, e.g. for a dry run.
+// a disk which is just attached may not be listed
+func ListBlockDevices() ([]*BlockDevice, error) {
 	// Note that lsblk might be executed in time when udev does not have all
 	// information about recently added or modified devices yet. In this
 	// case it is recommended to use udevadm settle before lsblk to
 	// synchronize with udev.
 	// http://man7.org/linux/man-pages/man8/lsblk.8.html
 	udevadm := exec.Command("udevadm", "settle")
-	_, err = udevadm.Output()
+	_, err := udevadm.Output()
 	if err != nil {
 		return nil, err
 	}
 
-	lsblk := exec.Command("lsblk", "--json", "--fs", "-b", "-o", "NAME,FSTYPE,LABEL,UUID,MOUNTPOINT,SIZE")
+	lsblk := exec.Command("lsblk", "--json", "--fs", "-b", "-o", "NAME,FSTYPE,LABEL,UUID,MOUNTPOINT,SIZE,MAJ:MIN,TYPE,PTTYPE,PARTLABEL")
 	output, err := lsblk.Output()
 	if err != nil {
 		return nil, err
@@ -138,9 +294,54 @@ func BlockDevices() ([]*BlockDevice, error) {
 		return nil, errors.New(fmt.Sprintf("json unmarshal err: %s, %s", err.Error(), string(output)))
 	}
 
+	setParent(lsblkOutputStruct.BlockDevices, nil)
+
 	return lsblkOutputStruct.BlockDevices, nil
 }
 
+func setParent(blockDevices []*BlockDevice, parent *BlockDevice) {
+	for _, blockDevice := range blockDevices {
+		blockDevice.parent = parent
+		setParent(blockDevice.Children, blockDevice)
+	}
+}
+
+// The maximum length of the ext4 volume label is 16 bytes
+const ext4LabelMaxLen = 16
+
+// Ext4Label returns name when it fits the ext4 label, otherwise the first 64 bits of sha256 of name in hex,
+// e.g. PV names pvc-<uuid> are 40 bytes
+func Ext4Label(name string) string {
+	if len(name) <= ext4LabelMaxLen {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])[:ext4LabelMaxLen]
+}
+
+// CheckFilesystem runs e2fsck -p on ext filesystem of device, repaired reports whether errors are corrected.
+// other filesystems are not checked, e.g. xfs repairs its log on mount
+func CheckFilesystem(blockDevice *BlockDevice, timeout time.Duration) (bool, string, error) {
+	switch blockDevice.FsType {
+	case "ext2", "ext3", "ext4":
+	default:
+		return false, "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	e2fsck := exec.CommandContext(ctx, "e2fsck", "-p", fmt.Sprintf("/dev/%s", blockDevice.Name))
+	output, err := e2fsck.CombinedOutput()
+	// exit code 1 is errors corrected, 2 is errors corrected and reboot, which only matters for the root filesystem
+	if exitError, ok := err.(*exec.ExitError); ok && ctx.Err() == nil && (exitError.ExitCode() == 1 || exitError.ExitCode() == 2) {
+		return true, string(output), nil
+	}
+
+	return false, string(output), err
+}
+
 func FormatDeviceToExt4(dev *BlockDevice, label string, uuid string, timeout time.Duration) (string, error) {
 	mkfsExt4 := exec.Command("mkfs.ext4", fmt.Sprintf("/dev/%s", dev.Name), "-L", label, "-U", uuid)
 	stdoutReader, stdout, _ := os.Pipe()
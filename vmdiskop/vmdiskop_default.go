@@ -7,3 +7,31 @@ import "errors"
 func Mount(blockDevice *BlockDevice, mountPoint string, fsType string, readWrite string) error {
 	return errors.New("not support")
 }
+
+func MountSharedReadOnly(blockDevice *BlockDevice, mountPoint string, fsType string) error {
+	return errors.New("not support")
+}
+
+func BindDevice(blockDevice *BlockDevice, mountPoint string, readWrite string) error {
+	return errors.New("not support")
+}
+
+func UnmountLazy(mountPoint string) error {
+	return errors.New("not support")
+}
+
+func DeviceInUse(blockDevice *BlockDevice) (bool, error) {
+	return false, errors.New("not support")
+}
+
+func StatFilesystem(mountPoint string) (*FilesystemStats, error) {
+	return nil, errors.New("not support")
+}
+
+func deviceNumber(path string) (string, error) {
+	return "", errors.New("not support")
+}
+
+func fileDeviceNumbers(path string) (string, string, error) {
+	return "", "", errors.New("not support")
+}
@@ -3,14 +3,128 @@
 package vmdiskop
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"syscall"
 )
 
 func Mount(blockDevice *BlockDevice, mountPoint string, fsType string, readWrite string) error {
 	flag := uintptr(0)
 	if readWrite == "ro" {
-		flag = syscall.O_RDONLY
+		flag = syscall.MS_RDONLY
 	}
 	return syscall.Mount(fmt.Sprintf("/dev/%s", blockDevice.Name), mountPoint, fsType, flag, "")
 }
+
+// MountSharedReadOnly mounts filesystem read only which other VMs read at the same time. journal of ext3 and ext4 is
+// not replayed, a replay writes to the device even if the mount is read only
+func MountSharedReadOnly(blockDevice *BlockDevice, mountPoint string, fsType string) error {
+	data := ""
+	if fsType == "ext3" || fsType == "ext4" {
+		data = "noload"
+	}
+	return syscall.Mount(fmt.Sprintf("/dev/%s", blockDevice.Name), mountPoint, fsType, syscall.MS_RDONLY, data)
+}
+
+// BindDevice bind mounts device node of blockDevice at mountPoint. only a file can be the mount point of a device
+// node, an empty mount point dir created by kubelet is replaced by a file
+func BindDevice(blockDevice *BlockDevice, mountPoint string, readWrite string) error {
+	fileInfo, err := os.Stat(mountPoint)
+	if err == nil && fileInfo.IsDir() {
+		if err := os.Remove(mountPoint); err != nil {
+			return err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	file, err := os.OpenFile(mountPoint, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	err = syscall.Mount(fmt.Sprintf("/dev/%s", blockDevice.Name), mountPoint, "", syscall.MS_BIND, "")
+	if err != nil {
+		return err
+	}
+
+	// read only flag of bind mount is only applied by remount
+	if readWrite == "ro" {
+		err = syscall.Mount("", mountPoint, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+		if err != nil {
+			syscall.Unmount(mountPoint, 0)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnmountLazy detaches mount at mountPoint from the filesystem tree, the filesystem is released when it is no longer used
+func UnmountLazy(mountPoint string) error {
+	return syscall.Unmount(mountPoint, syscall.MNT_DETACH)
+}
+
+// DeviceInUse reports whether filesystem of device is still mounted, e.g. a lazily unmounted filesystem which is used.
+// a mounted device cannot be opened exclusively
+func DeviceInUse(blockDevice *BlockDevice) (bool, error) {
+	fd, err := syscall.Open(fmt.Sprintf("/dev/%s", blockDevice.Name), syscall.O_RDONLY|syscall.O_EXCL, 0)
+	if err == syscall.EBUSY {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	syscall.Close(fd)
+
+	return false, nil
+}
+
+// StatFilesystem returns stats of filesystem mounted at mountPoint by statfs
+func StatFilesystem(mountPoint string) (*FilesystemStats, error) {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(mountPoint, &statfs); err != nil {
+		return nil, err
+	}
+
+	blockSize := int64(statfs.Bsize)
+	return &FilesystemStats{
+		Capacity:   int64(statfs.Blocks) * blockSize,
+		Used:       int64(statfs.Blocks-statfs.Bfree) * blockSize,
+		Available:  int64(statfs.Bavail) * blockSize,
+		Inodes:     int64(statfs.Files),
+		InodesUsed: int64(statfs.Files - statfs.Ffree),
+		InodesFree: int64(statfs.Ffree),
+	}, nil
+}
+
+// deviceNumber returns major:minor of block device node at path
+func deviceNumber(path string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return "", err
+	}
+
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return "", errors.New(path + " is not a block device")
+	}
+
+	return majorMinor(uint64(stat.Rdev)), nil
+}
+
+// fileDeviceNumbers returns major:minor of filesystem of file at path, and of the device when it is a block device node
+func fileDeviceNumbers(path string) (string, string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return "", "", err
+	}
+
+	rdev := ""
+	if stat.Mode&syscall.S_IFMT == syscall.S_IFBLK {
+		rdev = majorMinor(uint64(stat.Rdev))
+	}
+
+	return majorMinor(uint64(stat.Dev)), rdev, nil
+}
+